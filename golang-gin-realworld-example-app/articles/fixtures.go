@@ -0,0 +1,95 @@
+package articles
+
+import (
+	"context"
+	"fmt"
+
+	"realworld-backend/fixtures"
+	"realworld-backend/users"
+)
+
+// Create the tags, articles, favorites and comments of f through the validators and the stores, the users
+// being the ones returned by users.LoadFixture. The articles are returned by the name the fixture refers to them with.
+func LoadFixture(ctx context.Context, articleStore ArticleStore, commentStore CommentStore, tagStore TagStore,
+	f *fixtures.Fixture, loadedUsers map[string]users.UserModel) (map[string]ArticleModel, error) {
+	articleUser := func(ref string) (ArticleUserModel, error) {
+		user, ok := loadedUsers[ref]
+		if !ok {
+			return ArticleUserModel{}, fmt.Errorf("unknown user %q", ref)
+		}
+		return articleStore.ArticleUser(ctx, user)
+	}
+
+	if _, err := tagStore.FindOrCreate(ctx, f.Tags); err != nil {
+		return nil, err
+	}
+
+	loaded := map[string]ArticleModel{}
+	for _, article := range f.Articles {
+		author, err := articleUser(article.Author)
+		if err != nil {
+			return nil, fmt.Errorf("article %s: %w", article.Key(), err)
+		}
+		validator := NewArticleModelValidator()
+		validator.Article.Title = article.Title
+		validator.Article.Description = article.Description
+		validator.Article.Body = article.Body
+		validator.Article.Tags = article.Tags
+		articleModel, err := validator.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("article %s: %w", article.Key(), err)
+		}
+		if articleModel.Tags, err = tagStore.FindOrCreate(ctx, article.Tags); err != nil {
+			return nil, err
+		}
+		articleModel.Author = author
+		if err := articleStore.Create(ctx, &articleModel); err != nil {
+			return nil, fmt.Errorf("article %s: %w", article.Key(), err)
+		}
+		loaded[article.Key()] = articleModel
+	}
+
+	findArticle := func(ref string) (ArticleModel, error) {
+		article, ok := loaded[ref]
+		if !ok {
+			return article, fmt.Errorf("unknown article %q", ref)
+		}
+		return article, nil
+	}
+	for _, favorite := range f.Favorites {
+		user, err := articleUser(favorite.User)
+		if err != nil {
+			return nil, fmt.Errorf("favorite: %w", err)
+		}
+		article, err := findArticle(favorite.Article)
+		if err != nil {
+			return nil, fmt.Errorf("favorite: %w", err)
+		}
+		if err := articleStore.Favorite(ctx, article, user); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, comment := range f.Comments {
+		author, err := articleUser(comment.Author)
+		if err != nil {
+			return nil, fmt.Errorf("comment: %w", err)
+		}
+		article, err := findArticle(comment.Article)
+		if err != nil {
+			return nil, fmt.Errorf("comment: %w", err)
+		}
+		validator := NewCommentModelValidator()
+		validator.Comment.Body = comment.Body
+		commentModel, err := validator.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("comment on %s: %w", comment.Article, err)
+		}
+		commentModel.Author = author
+		commentModel.ArticleID = article.ID
+		if err := commentStore.Create(ctx, &commentModel); err != nil {
+			return nil, err
+		}
+	}
+	return loaded, nil
+}
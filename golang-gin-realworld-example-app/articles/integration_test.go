@@ -30,23 +30,27 @@ func setupArticlesRouter() *gin.Engine {
 	db.Exec("DELETE FROM follow_models")
 	db.Exec("DELETE FROM user_models")
 
-	users.AutoMigrate()
+	users.AutoMigrate(db)
 	db.AutoMigrate(&ArticleModel{}, &CommentModel{}, &TagModel{}, &ArticleUserModel{}, &FavoriteModel{})
 
+	userStore := users.NewGormUserStore(db)
+	userHandler := users.NewHandler(userStore, users.NewGormTokenStore(db))
+	h := NewHandler(userStore, NewGormArticleStore(db), NewGormCommentStore(db), NewGormTagStore(db))
+
 	v1 := router.Group("/api")
-	users.UsersRegister(v1.Group("/users"))
-	users.UserRegister(v1.Group("/user"))
+	userHandler.UsersRegister(v1.Group("/users"))
+	userHandler.UserRegister(v1.Group("/user"))
 
 	// Articles routes
 	v1Articles := v1.Group("/articles")
-	v1Articles.Use(users.AuthMiddleware(false))
-	ArticlesAnonymousRegister(v1Articles)
+	v1Articles.Use(userHandler.AuthMiddleware(false))
+	h.ArticlesAnonymousRegister(v1Articles)
 
 	v1ArticlesAuth := v1.Group("/articles")
-	v1ArticlesAuth.Use(users.AuthMiddleware(true))
-	ArticlesRegister(v1ArticlesAuth)
+	v1ArticlesAuth.Use(userHandler.AuthMiddleware(true))
+	h.ArticlesRegister(v1ArticlesAuth)
 
-	v1.GET("/tags", TagList)
+	v1.GET("/tags", h.TagList)
 
 	return router
 }
@@ -573,3 +577,84 @@ func TestArticleIntegration_GetTags_Success(t *testing.T) {
 	tags := response["tags"].([]interface{})
 	assert.GreaterOrEqual(len(tags), 3) // Should have at least golang, testing, web
 }
+
+// Test 16: Update and Delete Article - Not the Author
+func TestArticleIntegration_ChangeArticle_Forbidden(t *testing.T) {
+	router := setupArticlesRouter()
+	assert := assert.New(t)
+
+	authorToken := createUserAndGetToken(router, "author16", "author16@test.com")
+	otherToken := createUserAndGetToken(router, "other16", "other16@test.com")
+
+	articleBody := map[string]interface{}{
+		"article": map[string]interface{}{
+			"title":       "Not Yours",
+			"description": "Only the author may change it",
+			"body":        "Hands off",
+		},
+	}
+
+	createResp := makeArticleRequest("POST", "/api/articles/", articleBody, authorToken, router)
+	var createResponse map[string]interface{}
+	json.Unmarshal(createResp.Body.Bytes(), &createResponse)
+	article := createResponse["article"].(map[string]interface{})
+	slug := article["slug"].(string)
+
+	updateBody := map[string]interface{}{
+		"article": map[string]interface{}{
+			"title": "Stolen Title",
+		},
+	}
+	w := makeArticleRequest("PUT", fmt.Sprintf("/api/articles/%s", slug), updateBody, otherToken, router)
+	assert.Equal(403, w.Code)
+	assert.Contains(w.Body.String(), `"code":"forbidden"`)
+
+	w = makeArticleRequest("DELETE", fmt.Sprintf("/api/articles/%s", slug), nil, otherToken, router)
+	assert.Equal(403, w.Code)
+
+	getResp := makeArticleRequest("GET", fmt.Sprintf("/api/articles/%s", slug), nil, "", router)
+	assert.Equal(200, getResp.Code)
+	assert.Contains(getResp.Body.String(), "Not Yours")
+}
+
+// Test 17: Delete Comment - Not the Author
+func TestArticleIntegration_DeleteComment_Forbidden(t *testing.T) {
+	router := setupArticlesRouter()
+	assert := assert.New(t)
+
+	authorToken := createUserAndGetToken(router, "author17", "author17@test.com")
+	commenterToken := createUserAndGetToken(router, "commenter17", "commenter17@test.com")
+
+	articleBody := map[string]interface{}{
+		"article": map[string]interface{}{
+			"title":       "Article for Foreign Comment",
+			"description": "Delete someone else's comment",
+			"body":        "Test comment ownership",
+		},
+	}
+
+	createResp := makeArticleRequest("POST", "/api/articles/", articleBody, authorToken, router)
+	var createResponse map[string]interface{}
+	json.Unmarshal(createResp.Body.Bytes(), &createResponse)
+	article := createResponse["article"].(map[string]interface{})
+	slug := article["slug"].(string)
+
+	commentBody := map[string]interface{}{
+		"comment": map[string]interface{}{
+			"body": "Not the article author's comment",
+		},
+	}
+
+	commentResp := makeArticleRequest("POST", fmt.Sprintf("/api/articles/%s/comments", slug), commentBody, commenterToken, router)
+	var commentResponse map[string]interface{}
+	json.Unmarshal(commentResp.Body.Bytes(), &commentResponse)
+	comment := commentResponse["comment"].(map[string]interface{})
+	commentID := int(comment["id"].(float64))
+
+	w := makeArticleRequest("DELETE", fmt.Sprintf("/api/articles/%s/comments/%d", slug, commentID), nil, authorToken, router)
+	assert.Equal(403, w.Code)
+
+	w = makeArticleRequest("DELETE", fmt.Sprintf("/api/articles/%s/comments/%d", slug, commentID+1), nil, commenterToken, router)
+	assert.Equal(404, w.Code)
+	assert.Contains(w.Body.String(), `"code":"not_found"`)
+}
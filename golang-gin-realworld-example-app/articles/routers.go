@@ -1,213 +1,324 @@
 package articles
 
 import (
-	"errors"
+	"fmt"
 	"realworld-backend/common"
+	"realworld-backend/metrics"
 	"realworld-backend/users"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strconv"
 )
 
-func ArticlesRegister(router *gin.RouterGroup) {
-	router.POST("/", ArticleCreate)
-	router.PUT("/:slug", ArticleUpdate)
-	router.DELETE("/:slug", ArticleDelete)
-	router.POST("/:slug/favorite", ArticleFavorite)
-	router.DELETE("/:slug/favorite", ArticleUnfavorite)
-	router.POST("/:slug/comments", ArticleCommentCreate)
-	router.DELETE("/:slug/comments/:id", ArticleCommentDelete)
+// Handler carries the dependencies of the article, comment and tag routes.
+// 	articleHandler := articles.NewHandler(userStore, articles.NewGormArticleStore(db),
+// 		articles.NewGormCommentStore(db), articles.NewGormTagStore(db))
+// 	articleHandler.ArticlesRegister(v1.Group("/articles"))
+type Handler struct {
+	Users    users.UserStore
+	Articles ArticleStore
+	Comments CommentStore
+	Tags     TagStore
 }
 
-func ArticlesAnonymousRegister(router *gin.RouterGroup) {
-	router.GET("/", ArticleList)
-	router.GET("/:slug", ArticleRetrieve)
-	router.GET("/:slug/comments", ArticleCommentList)
+func NewHandler(userStore users.UserStore, articleStore ArticleStore, commentStore CommentStore, tagStore TagStore) *Handler {
+	return &Handler{
+		Users:    userStore,
+		Articles: articleStore,
+		Comments: commentStore,
+		Tags:     tagStore,
+	}
+}
+
+func (h *Handler) ArticlesRegister(router *gin.RouterGroup) {
+	router.POST("/", h.ArticleCreate)
+	router.PUT("/:slug", h.ArticleUpdate)
+	router.DELETE("/:slug", h.ArticleDelete)
+	router.POST("/:slug/favorite", h.ArticleFavorite)
+	router.DELETE("/:slug/favorite", h.ArticleUnfavorite)
+	router.POST("/:slug/comments", h.ArticleCommentCreate)
+	router.DELETE("/:slug/comments/:id", h.ArticleCommentDelete)
+}
+
+func (h *Handler) ArticlesAnonymousRegister(router *gin.RouterGroup) {
+	router.GET("/", h.ArticleList)
+	router.GET("/:slug", h.ArticleRetrieve)
+	router.GET("/:slug/comments", h.ArticleCommentList)
+}
+
+func (h *Handler) TagsAnonymousRegister(router *gin.RouterGroup) {
+	router.GET("/", h.TagList)
+}
+
+// Resolve the author and the tags of the validated article, which the validator leaves to the stores.
+func (h *Handler) fillArticle(c *gin.Context, validator *ArticleModelValidator) error {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	author, err := h.Articles.ArticleUser(c.Request.Context(), myUserModel)
+	if err != nil {
+		return err
+	}
+	tags, err := h.Tags.FindOrCreate(c.Request.Context(), validator.Article.Tags)
+	if err != nil {
+		return err
+	}
+	validator.articleModel.Author = author
+	validator.articleModel.AuthorID = author.ID
+	validator.articleModel.Tags = tags
+	return nil
 }
 
-func TagsAnonymousRegister(router *gin.RouterGroup) {
-	router.GET("/", TagList)
+// Only the author may change an article or a comment, anybody else gets a 403.
+func requireAuthor(c *gin.Context, author ArticleUserModel, resource string) bool {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if author.UserModelID == 0 || author.UserModelID != myUserModel.ID {
+		common.AbortWithError(c, common.NewAPIError(common.CodeForbidden, fmt.Sprintf("Only the author can change this %s", resource)))
+		return false
+	}
+	return true
 }
 
-func ArticleCreate(c *gin.Context) {
+func (h *Handler) ArticleCreate(c *gin.Context) {
 	articleModelValidator := NewArticleModelValidator()
 	if err := articleModelValidator.Bind(c); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		common.AbortWithError(c, common.ValidationError(err))
+		return
+	}
+	if err := h.fillArticle(c, &articleModelValidator); err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
-	//fmt.Println(articleModelValidator.articleModel.Author.UserModel)
 
-	if err := SaveOne(&articleModelValidator.articleModel); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+	if err := h.Articles.Create(c.Request.Context(), &articleModelValidator.articleModel); err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
-	serializer := ArticleSerializer{c, articleModelValidator.articleModel}
+	metrics.ArticlesCreated.Inc()
+	serializer := ArticleSerializer{c, h.Users, h.Articles, articleModelValidator.articleModel}
 	c.JSON(http.StatusCreated, gin.H{"article": serializer.Response()})
 }
 
-func ArticleList(c *gin.Context) {
-	//condition := ArticleModel{}
-	tag := c.Query("tag")
-	author := c.Query("author")
-	favorited := c.Query("favorited")
-	limit := c.Query("limit")
-	offset := c.Query("offset")
-	articleModels, modelCount, err := FindManyArticle(tag, author, limit, offset, favorited)
+func (h *Handler) ArticleList(c *gin.Context) {
+	limit, offset := paginate(c.Query("limit"), c.Query("offset"))
+	articleModels, modelCount, err := h.Articles.FindMany(c.Request.Context(), ArticleQuery{
+		Tag:       c.Query("tag"),
+		Author:    c.Query("author"),
+		Favorited: c.Query("favorited"),
+		Limit:     limit,
+		Offset:    offset,
+	})
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
-	serializer := ArticlesSerializer{c, articleModels}
+	serializer := ArticlesSerializer{c, h.Users, h.Articles, articleModels}
 	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount})
 }
 
-func ArticleFeed(c *gin.Context) {
-	limit := c.Query("limit")
-	offset := c.Query("offset")
+func (h *Handler) ArticleFeed(c *gin.Context) {
+	limit, offset := paginate(c.Query("limit"), c.Query("offset"))
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
 	if myUserModel.ID == 0 {
-		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
+		common.AbortWithError(c, common.NewAPIError(common.CodeUnauthorized, "Authentication required"))
 		return
 	}
-	articleUserModel := GetArticleUserModel(myUserModel)
-	articleModels, modelCount, err := articleUserModel.GetArticleFeed(limit, offset)
+	articleUserModel, err := h.Articles.ArticleUser(c.Request.Context(), myUserModel)
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
-	serializer := ArticlesSerializer{c, articleModels}
+	articleModels, modelCount, err := h.Articles.Feed(c.Request.Context(), articleUserModel, limit, offset)
+	if err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
+		return
+	}
+	serializer := ArticlesSerializer{c, h.Users, h.Articles, articleModels}
 	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount})
 }
 
-func ArticleRetrieve(c *gin.Context) {
+func (h *Handler) ArticleRetrieve(c *gin.Context) {
 	slug := c.Param("slug")
 	if slug == "feed" {
-		ArticleFeed(c)
+		h.ArticleFeed(c)
 		return
 	}
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := h.Articles.FindOne(c.Request.Context(), ArticleModel{Slug: slug})
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
-	serializer := ArticleSerializer{c, articleModel}
+	serializer := ArticleSerializer{c, h.Users, h.Articles, articleModel}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
 
-func ArticleUpdate(c *gin.Context) {
+func (h *Handler) ArticleUpdate(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := h.Articles.FindOne(c.Request.Context(), ArticleModel{Slug: slug})
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
+		return
+	}
+	if !requireAuthor(c, articleModel.Author, "article") {
 		return
 	}
 	articleModelValidator := NewArticleModelValidatorFillWith(articleModel)
 	if err := articleModelValidator.Bind(c); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		common.AbortWithError(c, common.ValidationError(err))
+		return
+	}
+	if err := h.fillArticle(c, &articleModelValidator); err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
 
-	articleModelValidator.articleModel.ID = articleModel.ID
-	if err := articleModel.Update(articleModelValidator.articleModel); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+	if err := h.Articles.Update(c.Request.Context(), &articleModel, articleModelValidator.articleModel); err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
-	serializer := ArticleSerializer{c, articleModel}
+	serializer := ArticleSerializer{c, h.Users, h.Articles, articleModel}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
 
-func ArticleDelete(c *gin.Context) {
+func (h *Handler) ArticleDelete(c *gin.Context) {
 	slug := c.Param("slug")
-	err := DeleteArticleModel(&ArticleModel{Slug: slug})
+	articleModel, err := h.Articles.FindOne(c.Request.Context(), ArticleModel{Slug: slug})
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
+		return
+	}
+	if !requireAuthor(c, articleModel.Author, "article") {
+		return
+	}
+	err = h.Articles.Delete(c.Request.Context(), ArticleModel{Slug: slug})
+	if err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"article": "Delete success"})
 }
 
-func ArticleFavorite(c *gin.Context) {
+func (h *Handler) ArticleFavorite(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := h.Articles.FindOne(c.Request.Context(), ArticleModel{Slug: slug})
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
-	err = articleModel.favoriteBy(GetArticleUserModel(myUserModel))
-	serializer := ArticleSerializer{c, articleModel}
+	articleUserModel, err := h.Articles.ArticleUser(c.Request.Context(), myUserModel)
+	if err == nil {
+		err = h.Articles.Favorite(c.Request.Context(), articleModel, articleUserModel)
+	}
+	if err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
+		return
+	}
+	metrics.Favorites.WithLabelValues("favorite").Inc()
+	serializer := ArticleSerializer{c, h.Users, h.Articles, articleModel}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
 
-func ArticleUnfavorite(c *gin.Context) {
+func (h *Handler) ArticleUnfavorite(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := h.Articles.FindOne(c.Request.Context(), ArticleModel{Slug: slug})
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
-	err = articleModel.unFavoriteBy(GetArticleUserModel(myUserModel))
-	serializer := ArticleSerializer{c, articleModel}
+	articleUserModel, err := h.Articles.ArticleUser(c.Request.Context(), myUserModel)
+	if err == nil {
+		err = h.Articles.Unfavorite(c.Request.Context(), articleModel, articleUserModel)
+	}
+	if err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
+		return
+	}
+	metrics.Favorites.WithLabelValues("unfavorite").Inc()
+	serializer := ArticleSerializer{c, h.Users, h.Articles, articleModel}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
 
-func ArticleCommentCreate(c *gin.Context) {
+func (h *Handler) ArticleCommentCreate(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := h.Articles.FindOne(c.Request.Context(), ArticleModel{Slug: slug})
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid slug")))
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
 	commentModelValidator := NewCommentModelValidator()
 	if err := commentModelValidator.Bind(c); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		common.AbortWithError(c, common.ValidationError(err))
 		return
 	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	author, err := h.Articles.ArticleUser(c.Request.Context(), myUserModel)
+	if err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
+		return
+	}
+	commentModelValidator.commentModel.Author = author
+	commentModelValidator.commentModel.AuthorID = author.ID
 	commentModelValidator.commentModel.Article = articleModel
+	commentModelValidator.commentModel.ArticleID = articleModel.ID
 
-	if err := SaveOne(&commentModelValidator.commentModel); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+	if err := h.Comments.Create(c.Request.Context(), &commentModelValidator.commentModel); err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "comment"))
 		return
 	}
-	serializer := CommentSerializer{c, commentModelValidator.commentModel}
+	serializer := CommentSerializer{c, h.Users, commentModelValidator.commentModel}
 	c.JSON(http.StatusCreated, gin.H{"comment": serializer.Response()})
 }
 
-func ArticleCommentDelete(c *gin.Context) {
+func (h *Handler) ArticleCommentDelete(c *gin.Context) {
+	articleModel, err := h.Articles.FindOne(c.Request.Context(), ArticleModel{Slug: c.Param("slug")})
+	if err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
+		return
+	}
 	id64, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	id := uint(id64)
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid id")))
+		common.AbortWithError(c, common.NewAPIError(common.CodeNotFound, "The comment was not found").WithCause(err))
+		return
+	}
+	commentModel, err := h.Comments.FindOne(c.Request.Context(), id)
+	if err == nil && commentModel.ArticleID != articleModel.ID {
+		err = common.ErrNotFound
+	}
+	if err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "comment"))
+		return
+	}
+	if !requireAuthor(c, commentModel.Author, "comment") {
 		return
 	}
-	err = DeleteCommentModel([]uint{id})
+	err = h.Comments.Delete(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid id")))
+		common.AbortWithError(c, common.StoreAPIError(err, "comment"))
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"comment": "Delete success"})
 }
 
-func ArticleCommentList(c *gin.Context) {
+func (h *Handler) ArticleCommentList(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := h.Articles.FindOne(c.Request.Context(), ArticleModel{Slug: slug})
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("comments", errors.New("Invalid slug")))
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
-	err = articleModel.getComments()
+	comments, err := h.Comments.FindByArticle(c.Request.Context(), articleModel)
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("comments", errors.New("Database error")))
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
-	serializer := CommentsSerializer{c, articleModel.Comments}
+	serializer := CommentsSerializer{c, h.Users, comments}
 	c.JSON(http.StatusOK, gin.H{"comments": serializer.Response()})
 }
-func TagList(c *gin.Context) {
-	tagModels, err := getAllTags()
+func (h *Handler) TagList(c *gin.Context) {
+	tagModels, err := h.Tags.All(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
+		common.AbortWithError(c, common.StoreAPIError(err, "article"))
 		return
 	}
 	serializer := TagsSerializer{c, tagModels}
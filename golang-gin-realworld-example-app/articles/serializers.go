@@ -30,17 +30,20 @@ func (s *TagsSerializer) Response() []string {
 }
 
 type ArticleUserSerializer struct {
-	C *gin.Context
+	C     *gin.Context
+	Users users.UserStore
 	ArticleUserModel
 }
 
 func (s *ArticleUserSerializer) Response() users.ProfileResponse {
-	response := users.ProfileSerializer{s.C, s.ArticleUserModel.UserModel}
+	response := users.ProfileSerializer{C: s.C, Users: s.Users, UserModel: s.ArticleUserModel.UserModel}
 	return response.Response()
 }
 
 type ArticleSerializer struct {
-	C *gin.Context
+	C            *gin.Context
+	Users        users.UserStore
+	ArticleStore ArticleStore
 	ArticleModel
 }
 
@@ -59,13 +62,19 @@ type ArticleResponse struct {
 }
 
 type ArticlesSerializer struct {
-	C        *gin.Context
-	Articles []ArticleModel
+	C            *gin.Context
+	Users        users.UserStore
+	ArticleStore ArticleStore
+	Articles     []ArticleModel
 }
 
 func (s *ArticleSerializer) Response() ArticleResponse {
 	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
-	authorSerializer := ArticleUserSerializer{s.C, s.Author}
+	ctx := s.C.Request.Context()
+	myArticleUserModel, _ := s.ArticleStore.ArticleUser(ctx, myUserModel)
+	favorited, _ := s.ArticleStore.IsFavoritedBy(ctx, s.ArticleModel, myArticleUserModel)
+	favoritesCount, _ := s.ArticleStore.FavoritesCount(ctx, s.ArticleModel)
+	authorSerializer := ArticleUserSerializer{s.C, s.Users, s.Author}
 	response := ArticleResponse{
 		ID:          s.ID,
 		Slug:        slug.Make(s.Title),
@@ -76,8 +85,8 @@ func (s *ArticleSerializer) Response() ArticleResponse {
 		//UpdatedAt:      s.UpdatedAt.UTC().Format(time.RFC3339Nano),
 		UpdatedAt:      s.UpdatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
 		Author:         authorSerializer.Response(),
-		Favorite:       s.isFavoriteBy(GetArticleUserModel(myUserModel)),
-		FavoritesCount: s.favoritesCount(),
+		Favorite:       favorited,
+		FavoritesCount: favoritesCount,
 	}
 	response.Tags = make([]string, 0)
 	for _, tag := range s.Tags {
@@ -90,19 +99,21 @@ func (s *ArticleSerializer) Response() ArticleResponse {
 func (s *ArticlesSerializer) Response() []ArticleResponse {
 	response := []ArticleResponse{}
 	for _, article := range s.Articles {
-		serializer := ArticleSerializer{s.C, article}
+		serializer := ArticleSerializer{s.C, s.Users, s.ArticleStore, article}
 		response = append(response, serializer.Response())
 	}
 	return response
 }
 
 type CommentSerializer struct {
-	C *gin.Context
+	C     *gin.Context
+	Users users.UserStore
 	CommentModel
 }
 
 type CommentsSerializer struct {
 	C        *gin.Context
+	Users    users.UserStore
 	Comments []CommentModel
 }
 
@@ -115,7 +126,7 @@ type CommentResponse struct {
 }
 
 func (s *CommentSerializer) Response() CommentResponse {
-	authorSerializer := ArticleUserSerializer{s.C, s.Author}
+	authorSerializer := ArticleUserSerializer{s.C, s.Users, s.Author}
 	response := CommentResponse{
 		ID:        s.ID,
 		Body:      s.Body,
@@ -129,7 +140,7 @@ func (s *CommentSerializer) Response() CommentResponse {
 func (s *CommentsSerializer) Response() []CommentResponse {
 	response := []CommentResponse{}
 	for _, comment := range s.Comments {
-		serializer := CommentSerializer{s.C, comment}
+		serializer := CommentSerializer{s.C, s.Users, comment}
 		response = append(response, serializer.Response())
 	}
 	return response
@@ -0,0 +1,380 @@
+package articles
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+// The filters and the page of an article listing, at most one of Tag, Author and Favorited is used.
+type ArticleQuery struct {
+	Tag       string
+	Author    string
+	Favorited string
+	Limit     int
+	Offset    int
+}
+
+// Page size used when the client sends no or an invalid limit, and the largest one it may ask for.
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// Parse the limit/offset query strings into a window every dialect accepts,
+// MySQL for example rejects an OFFSET without a positive LIMIT.
+func paginate(limit, offset string) (int, int) {
+	limit_int, err := strconv.Atoi(limit)
+	if err != nil || limit_int <= 0 {
+		limit_int = defaultPageLimit
+	}
+	if limit_int > maxPageLimit {
+		limit_int = maxPageLimit
+	}
+	offset_int, err := strconv.Atoi(offset)
+	if err != nil || offset_int < 0 {
+		offset_int = 0
+	}
+	return limit_int, offset_int
+}
+
+// Everything the article handlers need about articles, their authors and favorites.
+// The articles returned by FindOne, FindMany and Feed have their Author (with its UserModel) and Tags loaded.
+// Lookups that match nothing return common.ErrNotFound, and like the UserStore every method takes the request context.
+type ArticleStore interface {
+	// The ArticleUserModel wrapping user, created on first use. The anonymous user gets an empty one.
+	ArticleUser(ctx context.Context, user users.UserModel) (ArticleUserModel, error)
+	// Find the article matching the non zero ID and Slug of condition.
+	FindOne(ctx context.Context, condition ArticleModel) (ArticleModel, error)
+	// A page of articles, newest first, and the number of articles matching the filters.
+	FindMany(ctx context.Context, query ArticleQuery) ([]ArticleModel, int, error)
+	// A page of the articles written by the users reader follows, most recently updated first.
+	Feed(ctx context.Context, reader ArticleUserModel, limit, offset int) ([]ArticleModel, int, error)
+	// Insert article with its Author and Tags, which should already exist. A taken slug gives a common.DuplicateError.
+	Create(ctx context.Context, article *ArticleModel) error
+	// Save the non zero Slug, Title, Description and Body of data into article and replace its tags by data.Tags.
+	Update(ctx context.Context, article *ArticleModel, data ArticleModel) error
+	// Delete the article matching the non zero ID and Slug of condition.
+	Delete(ctx context.Context, condition ArticleModel) error
+	Favorite(ctx context.Context, article ArticleModel, user ArticleUserModel) error
+	Unfavorite(ctx context.Context, article ArticleModel, user ArticleUserModel) error
+	// Always false for the anonymous user.
+	IsFavoritedBy(ctx context.Context, article ArticleModel, user ArticleUserModel) (bool, error)
+	FavoritesCount(ctx context.Context, article ArticleModel) (uint, error)
+}
+
+// The comments of the articles, returned with their Author (and its UserModel) loaded.
+type CommentStore interface {
+	// Insert comment, its ArticleID and Author should be set.
+	Create(ctx context.Context, comment *CommentModel) error
+	// The comments of article, oldest first.
+	FindByArticle(ctx context.Context, article ArticleModel) ([]CommentModel, error)
+	// The comment with id, ErrNotFound when there is none.
+	FindOne(ctx context.Context, id uint) (CommentModel, error)
+	Delete(ctx context.Context, id uint) error
+}
+
+type TagStore interface {
+	// The TagModel of every name, created when missing, in the order of names.
+	FindOrCreate(ctx context.Context, names []string) ([]TagModel, error)
+	All(ctx context.Context) ([]TagModel, error)
+}
+
+// The associations are created by their own stores, saving an article or a comment should not rewrite
+// its author or its tags (and the user behind the author) as gorm does by default.
+func withoutAssociationWrites(db *gorm.DB) *gorm.DB {
+	return db.Set("gorm:association_autoupdate", false).Set("gorm:association_autocreate", false)
+}
+
+type gormArticleStore struct {
+	db *gorm.DB
+}
+
+func NewGormArticleStore(db *gorm.DB) ArticleStore {
+	return &gormArticleStore{db: db}
+}
+
+func (s *gormArticleStore) ArticleUser(ctx context.Context, user users.UserModel) (ArticleUserModel, error) {
+	var articleUserModel ArticleUserModel
+	if user.ID == 0 {
+		return articleUserModel, nil
+	}
+	err := common.WithContext(ctx, s.db).Where(&ArticleUserModel{
+		UserModelID: user.ID,
+	}).FirstOrCreate(&articleUserModel).Error
+	articleUserModel.UserModel = user
+	return articleUserModel, err
+}
+
+func (s *gormArticleStore) loadRelations(ctx context.Context, models []ArticleModel) error {
+	tx := common.WithContext(ctx, s.db).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	for i, _ := range models {
+		err := relatedError(tx.Model(&models[i]).Related(&models[i].Author, "Author"))
+		if err == nil {
+			err = relatedError(tx.Model(&models[i].Author).Related(&models[i].Author.UserModel))
+		}
+		if err == nil {
+			err = relatedError(tx.Model(&models[i]).Related(&models[i].Tags, "Tags"))
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// The error of a query loading a relation. A missing related row is not one, the relation is left empty like before.
+func relatedError(db *gorm.DB) error {
+	if gorm.IsRecordNotFoundError(db.Error) {
+		return nil
+	}
+	return db.Error
+}
+
+func articleCondition(condition ArticleModel) (ArticleModel, bool) {
+	res := ArticleModel{Slug: condition.Slug}
+	res.ID = condition.ID
+	return res, res.ID != 0 || res.Slug != ""
+}
+
+func (s *gormArticleStore) FindOne(ctx context.Context, condition ArticleModel) (ArticleModel, error) {
+	var model ArticleModel
+	condition, ok := articleCondition(condition)
+	if !ok {
+		return model, common.ErrNotFound
+	}
+	if err := common.WithContext(ctx, s.db).Where(&condition).First(&model).Error; err != nil {
+		return model, common.StoreError(err)
+	}
+	models := []ArticleModel{model}
+	err := s.loadRelations(ctx, models)
+	return models[0], err
+}
+
+// Run the count and the page query of an article listing, then load the relations of each article.
+// The page is ordered explicitly since PostgreSQL and MySQL do not keep the insertion order like SQLite does.
+func (s *gormArticleStore) findPage(ctx context.Context, query *gorm.DB, order string, limit, offset int) ([]ArticleModel, int, error) {
+	var models []ArticleModel
+	var count int
+	if err := query.Count(&count).Error; err != nil {
+		return models, count, err
+	}
+	err := query.Select("article_models.*").Order(order).Order("article_models.id desc").
+		Offset(offset).Limit(limit).Find(&models).Error
+	if err != nil {
+		return models, count, err
+	}
+	err = s.loadRelations(ctx, models)
+	return models, count, err
+}
+
+// The filters are plain joins on article_models so that every dialect runs the same count and page queries.
+func (s *gormArticleStore) FindMany(ctx context.Context, q ArticleQuery) ([]ArticleModel, int, error) {
+	query := common.WithContext(ctx, s.db).Model(&ArticleModel{})
+	if q.Tag != "" {
+		query = query.
+			Joins("JOIN article_tags ON article_tags.article_model_id = article_models.id").
+			Joins("JOIN tag_models ON tag_models.id = article_tags.tag_model_id").
+			Where("tag_models.tag = ?", q.Tag)
+	} else if q.Author != "" {
+		query = query.
+			Joins("JOIN article_user_models ON article_user_models.id = article_models.author_id").
+			Joins("JOIN user_models ON user_models.id = article_user_models.user_model_id").
+			Where("user_models.username = ?", q.Author)
+	} else if q.Favorited != "" {
+		// Unfavorite soft deletes the row, so the deleted ones are skipped here.
+		query = query.
+			Joins("JOIN favorite_models ON favorite_models.favorite_id = article_models.id AND favorite_models.deleted_at IS NULL").
+			Joins("JOIN article_user_models ON article_user_models.id = favorite_models.favorite_by_id").
+			Joins("JOIN user_models ON user_models.id = article_user_models.user_model_id").
+			Where("user_models.username = ?", q.Favorited)
+	}
+	return s.findPage(ctx, query, "article_models.created_at desc", q.Limit, q.Offset)
+}
+
+func (s *gormArticleStore) Feed(ctx context.Context, reader ArticleUserModel, limit, offset int) ([]ArticleModel, int, error) {
+	query := common.WithContext(ctx, s.db).Model(&ArticleModel{}).
+		Joins("JOIN article_user_models ON article_user_models.id = article_models.author_id").
+		Joins("JOIN follow_models ON follow_models.following_id = article_user_models.user_model_id AND follow_models.deleted_at IS NULL").
+		Where("follow_models.followed_by_id = ?", reader.UserModelID)
+	return s.findPage(ctx, query, "article_models.updated_at desc", limit, offset)
+}
+
+func (s *gormArticleStore) Create(ctx context.Context, article *ArticleModel) error {
+	return common.StoreError(withoutAssociationWrites(common.WithContext(ctx, s.db)).Create(article).Error)
+}
+
+func (s *gormArticleStore) Update(ctx context.Context, article *ArticleModel, data ArticleModel) error {
+	tx := common.WithContext(ctx, s.db).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	err := tx.Model(article).Updates(ArticleModel{
+		Slug:        data.Slug,
+		Title:       data.Title,
+		Description: data.Description,
+		Body:        data.Body,
+	}).Error
+	if err == nil {
+		err = tx.Model(article).Association("Tags").Replace(data.Tags).Error
+		article.Tags = data.Tags
+	}
+	if err != nil {
+		tx.Rollback()
+		return common.StoreError(err)
+	}
+	return tx.Commit().Error
+}
+
+func (s *gormArticleStore) Delete(ctx context.Context, condition ArticleModel) error {
+	condition, ok := articleCondition(condition)
+	if !ok {
+		return common.ErrNotFound
+	}
+	res := common.WithContext(ctx, s.db).Where(&condition).Delete(ArticleModel{})
+	if res.Error == nil && res.RowsAffected == 0 {
+		return common.ErrNotFound
+	}
+	return res.Error
+}
+
+func (s *gormArticleStore) Favorite(ctx context.Context, article ArticleModel, user ArticleUserModel) error {
+	var favorite FavoriteModel
+	return common.WithContext(ctx, s.db).FirstOrCreate(&favorite, &FavoriteModel{
+		FavoriteID:   article.ID,
+		FavoriteByID: user.ID,
+	}).Error
+}
+
+func (s *gormArticleStore) Unfavorite(ctx context.Context, article ArticleModel, user ArticleUserModel) error {
+	return common.WithContext(ctx, s.db).Where(FavoriteModel{
+		FavoriteID:   article.ID,
+		FavoriteByID: user.ID,
+	}).Delete(FavoriteModel{}).Error
+}
+
+func (s *gormArticleStore) IsFavoritedBy(ctx context.Context, article ArticleModel, user ArticleUserModel) (bool, error) {
+	if article.ID == 0 || user.ID == 0 {
+		return false, nil
+	}
+	var count int
+	err := common.WithContext(ctx, s.db).Model(&FavoriteModel{}).Where(FavoriteModel{
+		FavoriteID:   article.ID,
+		FavoriteByID: user.ID,
+	}).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *gormArticleStore) FavoritesCount(ctx context.Context, article ArticleModel) (uint, error) {
+	var count uint
+	err := common.WithContext(ctx, s.db).Model(&FavoriteModel{}).Where(FavoriteModel{
+		FavoriteID: article.ID,
+	}).Count(&count).Error
+	return count, err
+}
+
+type gormCommentStore struct {
+	db *gorm.DB
+}
+
+func NewGormCommentStore(db *gorm.DB) CommentStore {
+	return &gormCommentStore{db: db}
+}
+
+func (s *gormCommentStore) Create(ctx context.Context, comment *CommentModel) error {
+	return withoutAssociationWrites(common.WithContext(ctx, s.db)).Create(comment).Error
+}
+
+func (s *gormCommentStore) FindByArticle(ctx context.Context, article ArticleModel) ([]CommentModel, error) {
+	var comments []CommentModel
+	if article.ID == 0 {
+		return comments, nil
+	}
+	tx := common.WithContext(ctx, s.db).Begin()
+	if tx.Error != nil {
+		return comments, tx.Error
+	}
+	if err := tx.Where(&CommentModel{ArticleID: article.ID}).Order("id").Find(&comments).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for i, _ := range comments {
+		if err := loadCommentAuthor(tx, &comments[i]); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	err := tx.Commit().Error
+	return comments, err
+}
+
+func loadCommentAuthor(tx *gorm.DB, comment *CommentModel) error {
+	if err := relatedError(tx.Model(comment).Related(&comment.Author, "Author")); err != nil {
+		return err
+	}
+	return relatedError(tx.Model(&comment.Author).Related(&comment.Author.UserModel))
+}
+
+func (s *gormCommentStore) FindOne(ctx context.Context, id uint) (CommentModel, error) {
+	var model CommentModel
+	if id == 0 {
+		return model, common.ErrNotFound
+	}
+	tx := common.WithContext(ctx, s.db).Begin()
+	if tx.Error != nil {
+		return model, tx.Error
+	}
+	if err := tx.Where("id = ?", id).First(&model).Error; err != nil {
+		tx.Rollback()
+		return model, common.StoreError(err)
+	}
+	if err := loadCommentAuthor(tx, &model); err != nil {
+		tx.Rollback()
+		return model, err
+	}
+	err := tx.Commit().Error
+	return model, err
+}
+
+func (s *gormCommentStore) Delete(ctx context.Context, id uint) error {
+	res := common.WithContext(ctx, s.db).Where("id = ?", id).Delete(&CommentModel{})
+	if res.Error == nil && res.RowsAffected == 0 {
+		return common.ErrNotFound
+	}
+	return res.Error
+}
+
+type gormTagStore struct {
+	db *gorm.DB
+}
+
+func NewGormTagStore(db *gorm.DB) TagStore {
+	return &gormTagStore{db: db}
+}
+
+func (s *gormTagStore) FindOrCreate(ctx context.Context, names []string) ([]TagModel, error) {
+	db := common.WithContext(ctx, s.db)
+	var tagList []TagModel
+	for _, tag := range names {
+		var tagModel TagModel
+		err := db.FirstOrCreate(&tagModel, TagModel{Tag: tag}).Error
+		if err != nil {
+			return tagList, err
+		}
+		tagList = append(tagList, tagModel)
+	}
+	return tagList, nil
+}
+
+func (s *gormTagStore) All(ctx context.Context) ([]TagModel, error) {
+	var models []TagModel
+	err := common.WithContext(ctx, s.db).Order("id").Find(&models).Error
+	return models, err
+}
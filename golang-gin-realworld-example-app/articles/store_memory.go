@@ -0,0 +1,465 @@
+package articles
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+// The slug is the only unique column of article_models.
+var errMemoryDuplicateSlug = &common.DuplicateError{Column: "slug"}
+
+type favorite struct {
+	articleID uint
+	userID    uint
+}
+
+// An ArticleStore keeping everything in memory, the authors are looked up in userStore when read.
+type memoryArticleStore struct {
+	mu           sync.RWMutex
+	userStore    users.UserStore
+	articleUsers []ArticleUserModel
+	articles     []ArticleModel
+	favorites    []favorite
+	nextID       uint
+}
+
+func NewMemoryArticleStore(userStore users.UserStore) ArticleStore {
+	return &memoryArticleStore{userStore: userStore, nextID: 1}
+}
+
+func (s *memoryArticleStore) ArticleUser(ctx context.Context, user users.UserModel) (ArticleUserModel, error) {
+	if err := ctx.Err(); err != nil {
+		return ArticleUserModel{}, err
+	}
+	if user.ID == 0 {
+		return ArticleUserModel{}, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, articleUser := range s.articleUsers {
+		if articleUser.UserModelID == user.ID {
+			articleUser.UserModel = user
+			return articleUser, nil
+		}
+	}
+	articleUser := ArticleUserModel{UserModelID: user.ID}
+	articleUser.ID = uint(len(s.articleUsers) + 1)
+	s.articleUsers = append(s.articleUsers, articleUser)
+	articleUser.UserModel = user
+	return articleUser, nil
+}
+
+// The stored article with its author filled from the user store, as the gorm store loads it.
+func (s *memoryArticleStore) load(ctx context.Context, article ArticleModel) ArticleModel {
+	for _, articleUser := range s.articleUsers {
+		if articleUser.ID == article.AuthorID {
+			article.Author = articleUser
+			article.Author.UserModel, _ = s.userStore.FindOne(ctx, users.UserModel{ID: articleUser.UserModelID})
+		}
+	}
+	article.Tags = append([]TagModel(nil), article.Tags...)
+	return article
+}
+
+func (s *memoryArticleStore) find(condition ArticleModel) (int, bool) {
+	condition, ok := articleCondition(condition)
+	if !ok {
+		return 0, false
+	}
+	for i, article := range s.articles {
+		if condition.ID != 0 && article.ID != condition.ID {
+			continue
+		}
+		if condition.Slug != "" && article.Slug != condition.Slug {
+			continue
+		}
+		return i, true
+	}
+	return 0, false
+}
+
+func (s *memoryArticleStore) slugTaken(slug string, exceptID uint) bool {
+	for _, article := range s.articles {
+		if article.Slug == slug && article.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryArticleStore) FindOne(ctx context.Context, condition ArticleModel) (ArticleModel, error) {
+	if err := ctx.Err(); err != nil {
+		return ArticleModel{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.find(condition)
+	if !ok {
+		return ArticleModel{}, common.ErrNotFound
+	}
+	return s.load(ctx, s.articles[i]), nil
+}
+
+func (s *memoryArticleStore) authorName(ctx context.Context, article ArticleModel) string {
+	return s.load(ctx, article).Author.UserModel.Username
+}
+
+func (s *memoryArticleStore) favoritedBy(ctx context.Context, article ArticleModel, username string) bool {
+	for _, f := range s.favorites {
+		if f.articleID != article.ID {
+			continue
+		}
+		for _, articleUser := range s.articleUsers {
+			if articleUser.ID != f.userID {
+				continue
+			}
+			user, err := s.userStore.FindOne(ctx, users.UserModel{ID: articleUser.UserModelID})
+			if err == nil && user.Username == username {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasTag(article ArticleModel, tag string) bool {
+	for _, tagModel := range article.Tags {
+		if tagModel.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort the matches by the time given, newest first, and cut the requested page out of them.
+func memoryPage(models []ArticleModel, at func(ArticleModel) time.Time, limit, offset int) ([]ArticleModel, int) {
+	sort.SliceStable(models, func(i, j int) bool {
+		if !at(models[i]).Equal(at(models[j])) {
+			return at(models[i]).After(at(models[j]))
+		}
+		return models[i].ID > models[j].ID
+	})
+	count := len(models)
+	if offset > count {
+		offset = count
+	}
+	models = models[offset:]
+	if limit < len(models) {
+		models = models[:limit]
+	}
+	return models, count
+}
+
+func (s *memoryArticleStore) FindMany(ctx context.Context, q ArticleQuery) ([]ArticleModel, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var models []ArticleModel
+	for _, article := range s.articles {
+		if q.Tag != "" && !hasTag(article, q.Tag) {
+			continue
+		} else if q.Tag == "" && q.Author != "" && s.authorName(ctx, article) != q.Author {
+			continue
+		} else if q.Tag == "" && q.Author == "" && q.Favorited != "" && !s.favoritedBy(ctx, article, q.Favorited) {
+			continue
+		}
+		models = append(models, s.load(ctx, article))
+	}
+	models, count := memoryPage(models, func(a ArticleModel) time.Time { return a.CreatedAt }, q.Limit, q.Offset)
+	return models, count, nil
+}
+
+func (s *memoryArticleStore) Feed(ctx context.Context, reader ArticleUserModel, limit, offset int) ([]ArticleModel, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	followings, err := s.userStore.Followings(ctx, users.UserModel{ID: reader.UserModelID})
+	if err != nil {
+		return nil, 0, err
+	}
+	var models []ArticleModel
+	for _, article := range s.articles {
+		article = s.load(ctx, article)
+		for _, following := range followings {
+			if article.Author.UserModelID == following.ID {
+				models = append(models, article)
+				break
+			}
+		}
+	}
+	models, count := memoryPage(models, func(a ArticleModel) time.Time { return a.UpdatedAt }, limit, offset)
+	return models, count, nil
+}
+
+func (s *memoryArticleStore) Create(ctx context.Context, article *ArticleModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.slugTaken(article.Slug, 0) {
+		return errMemoryDuplicateSlug
+	}
+	now := time.Now()
+	article.ID = s.nextID
+	article.CreatedAt = now
+	article.UpdatedAt = now
+	if article.Author.ID != 0 {
+		article.AuthorID = article.Author.ID
+	}
+	s.nextID++
+	stored := *article
+	stored.Author = ArticleUserModel{}
+	stored.Comments = nil
+	s.articles = append(s.articles, stored)
+	return nil
+}
+
+func (s *memoryArticleStore) Update(ctx context.Context, article *ArticleModel, data ArticleModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var condition ArticleModel
+	condition.ID = article.ID
+	i, ok := s.find(condition)
+	if !ok {
+		return common.ErrNotFound
+	}
+	if data.Slug != "" && s.slugTaken(data.Slug, article.ID) {
+		return errMemoryDuplicateSlug
+	}
+	stored := &s.articles[i]
+	if data.Slug != "" {
+		stored.Slug = data.Slug
+	}
+	if data.Title != "" {
+		stored.Title = data.Title
+	}
+	if data.Description != "" {
+		stored.Description = data.Description
+	}
+	if data.Body != "" {
+		stored.Body = data.Body
+	}
+	stored.Tags = append([]TagModel(nil), data.Tags...)
+	stored.UpdatedAt = time.Now()
+	*article = s.load(ctx, *stored)
+	return nil
+}
+
+func (s *memoryArticleStore) Delete(ctx context.Context, condition ArticleModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.find(condition)
+	if !ok {
+		return common.ErrNotFound
+	}
+	s.articles = append(s.articles[:i], s.articles[i+1:]...)
+	return nil
+}
+
+func (s *memoryArticleStore) Favorite(ctx context.Context, article ArticleModel, user ArticleUserModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.favorites {
+		if f.articleID == article.ID && f.userID == user.ID {
+			return nil
+		}
+	}
+	s.favorites = append(s.favorites, favorite{articleID: article.ID, userID: user.ID})
+	return nil
+}
+
+func (s *memoryArticleStore) Unfavorite(ctx context.Context, article ArticleModel, user ArticleUserModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.favorites[:0]
+	for _, f := range s.favorites {
+		if f.articleID != article.ID || f.userID != user.ID {
+			kept = append(kept, f)
+		}
+	}
+	s.favorites = kept
+	return nil
+}
+
+func (s *memoryArticleStore) IsFavoritedBy(ctx context.Context, article ArticleModel, user ArticleUserModel) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if article.ID == 0 || user.ID == 0 {
+		return false, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, f := range s.favorites {
+		if f.articleID == article.ID && f.userID == user.ID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryArticleStore) FavoritesCount(ctx context.Context, article ArticleModel) (uint, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var count uint
+	for _, f := range s.favorites {
+		if f.articleID == article.ID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// A CommentStore keeping everything in memory, the authors are looked up in userStore when read.
+type memoryCommentStore struct {
+	mu        sync.RWMutex
+	userStore users.UserStore
+	comments  []CommentModel
+	nextID    uint
+}
+
+func NewMemoryCommentStore(userStore users.UserStore) CommentStore {
+	return &memoryCommentStore{userStore: userStore, nextID: 1}
+}
+
+func (s *memoryCommentStore) Create(ctx context.Context, comment *CommentModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	comment.ID = s.nextID
+	comment.CreatedAt = now
+	comment.UpdatedAt = now
+	if comment.Author.ID != 0 {
+		comment.AuthorID = comment.Author.ID
+	}
+	if comment.Article.ID != 0 {
+		comment.ArticleID = comment.Article.ID
+	}
+	s.nextID++
+	stored := *comment
+	stored.Article = ArticleModel{}
+	s.comments = append(s.comments, stored)
+	return nil
+}
+
+func (s *memoryCommentStore) FindByArticle(ctx context.Context, article ArticleModel) ([]CommentModel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var comments []CommentModel
+	if article.ID == 0 {
+		return comments, nil
+	}
+	for _, comment := range s.comments {
+		if comment.ArticleID != article.ID {
+			continue
+		}
+		comment.Author.UserModel, _ = s.userStore.FindOne(ctx, users.UserModel{ID: comment.Author.UserModelID})
+		comments = append(comments, comment)
+	}
+	return comments, nil
+}
+
+func (s *memoryCommentStore) FindOne(ctx context.Context, id uint) (CommentModel, error) {
+	if err := ctx.Err(); err != nil {
+		return CommentModel{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, comment := range s.comments {
+		if comment.ID == id {
+			comment.Author.UserModel, _ = s.userStore.FindOne(ctx, users.UserModel{ID: comment.Author.UserModelID})
+			return comment, nil
+		}
+	}
+	return CommentModel{}, common.ErrNotFound
+}
+
+func (s *memoryCommentStore) Delete(ctx context.Context, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, comment := range s.comments {
+		if comment.ID == id {
+			s.comments = append(s.comments[:i], s.comments[i+1:]...)
+			return nil
+		}
+	}
+	return common.ErrNotFound
+}
+
+type memoryTagStore struct {
+	mu   sync.Mutex
+	tags []TagModel
+}
+
+func NewMemoryTagStore() TagStore {
+	return &memoryTagStore{}
+}
+
+func (s *memoryTagStore) FindOrCreate(ctx context.Context, names []string) ([]TagModel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tagList []TagModel
+	for _, name := range names {
+		found := false
+		for _, tag := range s.tags {
+			if tag.Tag == name {
+				tagList = append(tagList, tag)
+				found = true
+				break
+			}
+		}
+		if !found {
+			tag := TagModel{Tag: name}
+			tag.ID = uint(len(s.tags) + 1)
+			s.tags = append(s.tags, tag)
+			tagList = append(tagList, tag)
+		}
+	}
+	return tagList, nil
+}
+
+func (s *memoryTagStore) All(ctx context.Context) ([]TagModel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]TagModel(nil), s.tags...), nil
+}
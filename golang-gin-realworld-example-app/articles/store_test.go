@@ -0,0 +1,368 @@
+package articles
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/common"
+	"realworld-backend/fixtures"
+	"realworld-backend/users"
+)
+
+type testStores struct {
+	Users    users.UserStore
+	Articles ArticleStore
+	Comments CommentStore
+	Tags     TagStore
+}
+
+func (s testStores) newAuthor(t *testing.T, name string) ArticleUserModel {
+	ctx := context.Background()
+	user := users.UserModel{Username: name, Email: name + "@g.cn", PasswordHash: "hash"}
+	if err := s.Users.Create(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+	author, err := s.Articles.ArticleUser(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return author
+}
+
+func (s testStores) newArticle(t *testing.T, author ArticleUserModel, title string, tags ...string) ArticleModel {
+	ctx := context.Background()
+	tagModels, err := s.Tags.FindOrCreate(ctx, tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	article := ArticleModel{Slug: title, Title: title, Body: "body of " + title, Author: author, Tags: tagModels}
+	if err := s.Articles.Create(ctx, &article); err != nil {
+		t.Fatal(err)
+	}
+	return article
+}
+
+func slugsOf(models []ArticleModel) []string {
+	slugs := []string{}
+	for _, model := range models {
+		slugs = append(slugs, model.Slug)
+	}
+	return slugs
+}
+
+// The behaviour every set of article stores should share, run against each implementation below.
+func testArticleStoreConformance(t *testing.T, newStores func() testStores) {
+	t.Run("FindOne", func(t *testing.T) {
+		asserts := assert.New(t)
+		s := newStores()
+		ctx := context.Background()
+
+		_, err := s.Articles.FindOne(ctx, ArticleModel{Slug: "missing"})
+		asserts.ErrorIs(err, common.ErrNotFound)
+		_, err = s.Articles.FindOne(ctx, ArticleModel{Title: "missing"})
+		asserts.ErrorIs(err, common.ErrNotFound, "an empty condition should not match any article")
+
+		author := s.newAuthor(t, "writer")
+		article := s.newArticle(t, author, "first", "go", "gin")
+		asserts.NotZero(article.ID)
+
+		found, err := s.Articles.FindOne(ctx, ArticleModel{Slug: "first"})
+		asserts.NoError(err)
+		asserts.Equal(article.ID, found.ID)
+		asserts.Equal("body of first", found.Body)
+		asserts.Equal("writer", found.Author.UserModel.Username, "the author should be loaded")
+		asserts.Len(found.Tags, 2, "the tags should be loaded")
+
+		asserts.ErrorIs(s.Articles.Create(ctx, &ArticleModel{Slug: "first", Title: "first", Author: author}),
+			common.ErrDuplicate, "slug should be unique")
+	})
+
+	t.Run("UpdateAndDelete", func(t *testing.T) {
+		asserts := assert.New(t)
+		s := newStores()
+		ctx := context.Background()
+
+		article := s.newArticle(t, s.newAuthor(t, "editor"), "draft", "go", "gin")
+		tags, _ := s.Tags.FindOrCreate(ctx, []string{"gin", "gorm"})
+		asserts.NoError(s.Articles.Update(ctx, &article, ArticleModel{Slug: "final", Title: "final", Tags: tags}))
+		asserts.Equal("final", article.Title, "Update should change the struct")
+
+		found, err := s.Articles.FindOne(ctx, ArticleModel{Slug: "final"})
+		asserts.NoError(err)
+		asserts.Equal("body of draft", found.Body, "zero fields should be left alone")
+		var names []string
+		for _, tag := range found.Tags {
+			names = append(names, tag.Tag)
+		}
+		asserts.ElementsMatch([]string{"gin", "gorm"}, names, "the tags should be replaced")
+
+		asserts.NoError(s.Articles.Delete(ctx, ArticleModel{Slug: "final"}))
+		_, err = s.Articles.FindOne(ctx, ArticleModel{Slug: "final"})
+		asserts.ErrorIs(err, common.ErrNotFound)
+		asserts.ErrorIs(s.Articles.Delete(ctx, ArticleModel{Slug: "final"}), common.ErrNotFound)
+	})
+
+	t.Run("FindMany", func(t *testing.T) {
+		asserts := assert.New(t)
+		s := newStores()
+		ctx := context.Background()
+
+		alice, bob := s.newAuthor(t, "alice"), s.newAuthor(t, "bob")
+		for i := 1; i <= 3; i++ {
+			s.newArticle(t, alice, fmt.Sprintf("alice-%d", i), "go")
+			s.newArticle(t, bob, fmt.Sprintf("bob-%d", i), "rust")
+			time.Sleep(time.Millisecond)
+		}
+
+		models, count, err := s.Articles.FindMany(ctx, ArticleQuery{Limit: 20})
+		asserts.NoError(err)
+		asserts.Equal(6, count)
+		asserts.Equal([]string{"bob-3", "alice-3", "bob-2", "alice-2", "bob-1", "alice-1"}, slugsOf(models),
+			"articles should come newest first")
+
+		models, count, _ = s.Articles.FindMany(ctx, ArticleQuery{Limit: 2, Offset: 1})
+		asserts.Equal(6, count, "the count should ignore the page")
+		asserts.Equal([]string{"alice-3", "bob-2"}, slugsOf(models))
+
+		models, count, _ = s.Articles.FindMany(ctx, ArticleQuery{Tag: "go", Limit: 20})
+		asserts.Equal(3, count)
+		asserts.Equal([]string{"alice-3", "alice-2", "alice-1"}, slugsOf(models))
+
+		models, count, _ = s.Articles.FindMany(ctx, ArticleQuery{Author: "bob", Limit: 20})
+		asserts.Equal(3, count)
+		asserts.Equal([]string{"bob-3", "bob-2", "bob-1"}, slugsOf(models))
+
+		bob2, _ := s.Articles.FindOne(ctx, ArticleModel{Slug: "bob-2"})
+		asserts.NoError(s.Articles.Favorite(ctx, bob2, alice))
+		models, count, _ = s.Articles.FindMany(ctx, ArticleQuery{Favorited: "alice", Limit: 20})
+		asserts.Equal(1, count)
+		asserts.Equal([]string{"bob-2"}, slugsOf(models))
+		asserts.NoError(s.Articles.Unfavorite(ctx, bob2, alice))
+		_, count, _ = s.Articles.FindMany(ctx, ArticleQuery{Favorited: "alice", Limit: 20})
+		asserts.Equal(0, count, "unfavorited articles should not be listed")
+	})
+
+	t.Run("Feed", func(t *testing.T) {
+		asserts := assert.New(t)
+		s := newStores()
+		ctx := context.Background()
+
+		reader, alice, bob := s.newAuthor(t, "reader"), s.newAuthor(t, "alice"), s.newAuthor(t, "bob")
+		s.newArticle(t, alice, "from-alice")
+		s.newArticle(t, bob, "from-bob")
+
+		models, count, err := s.Articles.Feed(ctx, reader, 20, 0)
+		asserts.NoError(err)
+		asserts.Equal(0, count, "nobody followed yet")
+		asserts.Empty(models)
+
+		asserts.NoError(s.Users.Follow(ctx, reader.UserModel, alice.UserModel))
+		models, count, _ = s.Articles.Feed(ctx, reader, 20, 0)
+		asserts.Equal(1, count)
+		asserts.Equal([]string{"from-alice"}, slugsOf(models))
+
+		asserts.NoError(s.Users.Unfollow(ctx, reader.UserModel, alice.UserModel))
+		_, count, _ = s.Articles.Feed(ctx, reader, 20, 0)
+		asserts.Equal(0, count, "unfollowed authors should leave the feed")
+	})
+
+	t.Run("Favorite", func(t *testing.T) {
+		asserts := assert.New(t)
+		s := newStores()
+		ctx := context.Background()
+
+		author, fan := s.newAuthor(t, "author"), s.newAuthor(t, "fan")
+		article := s.newArticle(t, author, "liked")
+
+		asserts.NoError(s.Articles.Favorite(ctx, article, fan))
+		asserts.NoError(s.Articles.Favorite(ctx, article, fan), "favoriting twice should not be an error")
+		asserts.NoError(s.Articles.Favorite(ctx, article, author))
+		favorited, err := s.Articles.IsFavoritedBy(ctx, article, fan)
+		asserts.NoError(err)
+		asserts.True(favorited)
+		favorited, _ = s.Articles.IsFavoritedBy(ctx, article, ArticleUserModel{})
+		asserts.False(favorited, "the anonymous user favorites nothing")
+		count, err := s.Articles.FavoritesCount(ctx, article)
+		asserts.NoError(err)
+		asserts.Equal(uint(2), count)
+
+		asserts.NoError(s.Articles.Unfavorite(ctx, article, fan))
+		favorited, _ = s.Articles.IsFavoritedBy(ctx, article, fan)
+		asserts.False(favorited)
+		count, _ = s.Articles.FavoritesCount(ctx, article)
+		asserts.Equal(uint(1), count)
+	})
+
+	t.Run("Comments", func(t *testing.T) {
+		asserts := assert.New(t)
+		s := newStores()
+		ctx := context.Background()
+
+		author := s.newAuthor(t, "commenter")
+		article := s.newArticle(t, author, "discussed")
+		other := s.newArticle(t, author, "quiet")
+
+		first := CommentModel{ArticleID: article.ID, Author: author, Body: "first"}
+		second := CommentModel{ArticleID: article.ID, Author: author, Body: "second"}
+		asserts.NoError(s.Comments.Create(ctx, &first))
+		asserts.NoError(s.Comments.Create(ctx, &second))
+		asserts.NotZero(first.ID)
+
+		comments, err := s.Comments.FindByArticle(ctx, article)
+		asserts.NoError(err)
+		if asserts.Len(comments, 2) {
+			asserts.Equal("first", comments[0].Body, "comments should come oldest first")
+			asserts.Equal("commenter", comments[0].Author.UserModel.Username, "the author should be loaded")
+		}
+		comments, _ = s.Comments.FindByArticle(ctx, other)
+		asserts.Empty(comments)
+
+		found, err := s.Comments.FindOne(ctx, second.ID)
+		asserts.NoError(err)
+		asserts.Equal("second", found.Body)
+		asserts.Equal(article.ID, found.ArticleID)
+		asserts.Equal("commenter", found.Author.UserModel.Username, "the author should be loaded")
+		_, err = s.Comments.FindOne(ctx, 1000)
+		asserts.ErrorIs(err, common.ErrNotFound)
+
+		asserts.NoError(s.Comments.Delete(ctx, first.ID))
+		asserts.ErrorIs(s.Comments.Delete(ctx, first.ID), common.ErrNotFound)
+		comments, _ = s.Comments.FindByArticle(ctx, article)
+		asserts.Len(comments, 1)
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		asserts := assert.New(t)
+		s := newStores()
+		ctx := context.Background()
+
+		tags, err := s.Tags.FindOrCreate(ctx, []string{"go", "gin"})
+		asserts.NoError(err)
+		again, _ := s.Tags.FindOrCreate(ctx, []string{"gin", "gorm"})
+		asserts.Equal(tags[1].ID, again[0].ID, "existing tags should be reused")
+
+		all, err := s.Tags.All(ctx)
+		asserts.NoError(err)
+		asserts.Len(all, 3)
+	})
+
+	t.Run("Fixture", func(t *testing.T) {
+		asserts := assert.New(t)
+		s := newStores()
+		ctx := context.Background()
+
+		f, err := fixtures.Demo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		loadedUsers, err := users.LoadFixture(ctx, s.Users, f)
+		asserts.NoError(err)
+		loaded, err := LoadFixture(ctx, s.Articles, s.Comments, s.Tags, f, loadedUsers)
+		asserts.NoError(err)
+
+		dragon, err := s.Articles.FindOne(ctx, ArticleModel{Slug: "how-to-train-your-dragon"})
+		asserts.NoError(err, "the slug should be made from the title")
+		asserts.Equal(loaded["dragon"].ID, dragon.ID)
+		asserts.Equal("jake", dragon.Author.UserModel.Username)
+		asserts.Len(dragon.Tags, 2)
+		count, err := s.Articles.FavoritesCount(ctx, dragon)
+		asserts.NoError(err)
+		asserts.EqualValues(1, count)
+		comments, err := s.Comments.FindByArticle(ctx, dragon)
+		asserts.NoError(err)
+		if asserts.Len(comments, 1) {
+			asserts.Equal("anna", comments[0].Author.UserModel.Username)
+		}
+
+		_, err = LoadFixture(ctx, s.Articles, s.Comments, s.Tags, &fixtures.Fixture{
+			Articles: []fixtures.Article{{Author: "jake", Title: "How to train your dragon"}},
+		}, loadedUsers)
+		asserts.ErrorIs(err, common.ErrDuplicate, "a taken slug should be reported")
+		_, err = LoadFixture(ctx, s.Articles, s.Comments, s.Tags, &fixtures.Fixture{
+			Articles: []fixtures.Article{{Author: "bob", Title: "Bob's article"}},
+		}, loadedUsers)
+		asserts.ErrorContains(err, `unknown user "bob"`)
+	})
+
+	t.Run("EndedContext", func(t *testing.T) {
+		asserts := assert.New(t)
+		s := newStores()
+		ctx := context.Background()
+
+		article := s.newArticle(t, s.newAuthor(t, "late"), "late", "go")
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := s.Articles.FindOne(cancelled, ArticleModel{Slug: "late"})
+		asserts.ErrorIs(err, context.Canceled)
+		_, _, err = s.Articles.FindMany(cancelled, ArticleQuery{Limit: 20})
+		asserts.ErrorIs(err, context.Canceled)
+		_, err = s.Comments.FindByArticle(cancelled, article)
+		asserts.ErrorIs(err, context.Canceled)
+		_, err = s.Tags.All(cancelled)
+		asserts.ErrorIs(err, context.Canceled)
+	})
+}
+
+func TestGormArticleStores(t *testing.T) {
+	testArticleStoreConformance(t, func() testStores {
+		setupTestDB()
+		db := common.GetDB()
+		db.DropTableIfExists("article_tags")
+		db.AutoMigrate(&ArticleModel{})
+		return testStores{
+			Users:    users.NewGormUserStore(db),
+			Articles: NewGormArticleStore(db),
+			Comments: NewGormCommentStore(db),
+			Tags:     NewGormTagStore(db),
+		}
+	})
+}
+
+// A relation that fails to load fails the lookup, instead of an article or comment without its author or tags.
+func TestGormStoresRelationErrors(t *testing.T) {
+	asserts := assert.New(t)
+	setupTestDB()
+	db := common.GetDB()
+	db.DropTableIfExists("article_tags")
+	db.AutoMigrate(&ArticleModel{})
+	s := testStores{
+		Users:    users.NewGormUserStore(db),
+		Articles: NewGormArticleStore(db),
+		Comments: NewGormCommentStore(db),
+		Tags:     NewGormTagStore(db),
+	}
+	ctx := context.Background()
+	author := s.newAuthor(t, "broken")
+	article := s.newArticle(t, author, "broken", "go")
+	comment := CommentModel{ArticleID: article.ID, AuthorID: author.ID, Body: "first"}
+	asserts.NoError(s.Comments.Create(ctx, &comment))
+
+	asserts.NoError(db.DropTable("article_tags").Error)
+	_, err := s.Articles.FindOne(ctx, ArticleModel{Slug: article.Slug})
+	asserts.Error(err, "the tags should fail to load")
+	_, _, err = s.Articles.FindMany(ctx, ArticleQuery{Limit: 20})
+	asserts.Error(err)
+
+	asserts.NoError(db.DropTable(&users.UserModel{}).Error)
+	_, err = s.Comments.FindByArticle(ctx, article)
+	asserts.Error(err, "the authors should fail to load")
+	_, err = s.Comments.FindOne(ctx, comment.ID)
+	asserts.Error(err)
+	users.AutoMigrate(db)
+	db.AutoMigrate(&ArticleModel{})
+}
+
+func TestMemoryArticleStores(t *testing.T) {
+	testArticleStoreConformance(t, func() testStores {
+		userStore := users.NewMemoryUserStore()
+		return testStores{
+			Users:    userStore,
+			Articles: NewMemoryArticleStore(userStore),
+			Comments: NewMemoryCommentStore(userStore),
+			Tags:     NewMemoryTagStore(),
+		}
+	})
+}
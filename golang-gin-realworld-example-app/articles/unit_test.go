@@ -1,6 +1,7 @@
 package articles
 
 import (
+	"context"
 	"testing"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/bcrypt"
@@ -14,8 +15,8 @@ func setupTestDB() {
 	db := common.GetDB()
 
 	// Drop and recreate tables to ensure clean state
-	db.DropTableIfExists(&CommentModel{}, &FavoriteModel{}, &ArticleModel{}, &TagModel{}, &ArticleUserModel{}, &users.UserModel{})
-	db.AutoMigrate(&users.UserModel{}, &ArticleUserModel{}, &TagModel{}, &ArticleModel{}, &CommentModel{}, &FavoriteModel{})
+	db.DropTableIfExists(&CommentModel{}, &FavoriteModel{}, &ArticleModel{}, &TagModel{}, &ArticleUserModel{}, &users.FollowModel{}, &users.UserModel{})
+	db.AutoMigrate(&users.UserModel{}, &users.FollowModel{}, &ArticleUserModel{}, &TagModel{}, &ArticleModel{}, &CommentModel{}, &FavoriteModel{})
 }
 
 // Test 1: Create article with valid data
@@ -415,7 +416,7 @@ func TestTag_ListRetrieval(t *testing.T) {
 	assert.GreaterOrEqual(t, len(retrieved), 3)
 }
 
-// Test 16: DeleteArticleModel function
+// Test 16: ArticleStore.Delete
 func TestArticle_DeleteModel(t *testing.T) {
 	setupTestDB()
 	db := common.GetDB()
@@ -431,8 +432,8 @@ func TestArticle_DeleteModel(t *testing.T) {
 	db.Create(&article)
 	articleID := article.ID
 
-	// Call DeleteArticleModel function with condition (slug as condition)
-	err := DeleteArticleModel(map[string]interface{}{"slug": "delete-model-test"})
+	// Delete through the store with the slug as condition
+	err := NewGormArticleStore(db).Delete(context.Background(), ArticleModel{Slug: "delete-model-test"})
 
 	// Verify no error
 	assert.NoError(t, err)
@@ -443,7 +444,7 @@ func TestArticle_DeleteModel(t *testing.T) {
 	assert.Error(t, err) // Should not find it (deleted)
 }
 
-// Test 17: GetArticleFeed method
+// Test 17: ArticleStore.Feed
 func TestArticle_GetFeed(t *testing.T) {
 	setupTestDB()
 	db := common.GetDB()
@@ -470,8 +471,8 @@ func TestArticle_GetFeed(t *testing.T) {
 	}
 	db.Create(&article)
 
-	// Call GetArticleFeed method on ArticleUserModel
-	articles, count, err := articleUser.GetArticleFeed("20", "0")
+	// Ask the store for the feed of the ArticleUserModel
+	articles, count, err := NewGormArticleStore(db).Feed(context.Background(), articleUser, 20, 0)
 
 	// Verify results (may be 0 if no followings)
 	assert.NoError(t, err)
@@ -3,8 +3,8 @@ package articles
 import (
 	"github.com/gosimple/slug"
 	"realworld-backend/common"
-	"realworld-backend/users"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type ArticleModelValidator struct {
@@ -32,20 +32,31 @@ func NewArticleModelValidatorFillWith(articleModel ArticleModel) ArticleModelVal
 	return articleModelValidator
 }
 
+// The author and the tags are resolved by the handler through its stores.
 func (s *ArticleModelValidator) Bind(c *gin.Context) error {
-	myUserModel := c.MustGet("my_user_model").(users.UserModel)
-
 	err := common.Bind(c, s)
 	if err != nil {
 		return err
 	}
+	s.fill()
+	return nil
+}
+
+// Check the Article fields filled by hand and return the model they describe, like Bind does for a request.
+// The author and the tags are left to the caller as well.
+func (s *ArticleModelValidator) Validate() (ArticleModel, error) {
+	if err := binding.Validator.ValidateStruct(s); err != nil {
+		return ArticleModel{}, err
+	}
+	s.fill()
+	return s.articleModel, nil
+}
+
+func (s *ArticleModelValidator) fill() {
 	s.articleModel.Slug = slug.Make(s.Article.Title)
 	s.articleModel.Title = s.Article.Title
 	s.articleModel.Description = s.Article.Description
 	s.articleModel.Body = s.Article.Body
-	s.articleModel.Author = GetArticleUserModel(myUserModel)
-	s.articleModel.setTags(s.Article.Tags)
-	return nil
 }
 
 type CommentModelValidator struct {
@@ -60,13 +71,19 @@ func NewCommentModelValidator() CommentModelValidator {
 }
 
 func (s *CommentModelValidator) Bind(c *gin.Context) error {
-	myUserModel := c.MustGet("my_user_model").(users.UserModel)
-
 	err := common.Bind(c, s)
 	if err != nil {
 		return err
 	}
 	s.commentModel.Body = s.Comment.Body
-	s.commentModel.Author = GetArticleUserModel(myUserModel)
 	return nil
 }
+
+// Like ArticleModelValidator.Validate for a comment.
+func (s *CommentModelValidator) Validate() (CommentModel, error) {
+	if err := binding.Validator.ValidateStruct(s); err != nil {
+		return CommentModel{}, err
+	}
+	s.commentModel.Body = s.Comment.Body
+	return s.commentModel, nil
+}
@@ -0,0 +1,114 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"realworld-backend/metrics"
+)
+
+// gorm v1 knows nothing about context.Context, this SQLCommon runs every statement of a handle
+// with ctx instead, so a cancelled request or a passed deadline aborts the SQL in flight.
+type ctxSQLCommon struct {
+	ctx context.Context
+	db  *sql.DB
+}
+
+// Every statement is also counted and timed by metrics.ObserveQuery.
+func (c ctxSQLCommon) Exec(query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := c.db.ExecContext(c.ctx, query, args...)
+	metrics.ObserveQuery("exec", start, err)
+	return res, err
+}
+
+func (c ctxSQLCommon) Prepare(query string) (*sql.Stmt, error) {
+	start := time.Now()
+	stmt, err := c.db.PrepareContext(c.ctx, query)
+	metrics.ObserveQuery("prepare", start, err)
+	return stmt, err
+}
+
+func (c ctxSQLCommon) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := c.db.QueryContext(c.ctx, query, args...)
+	metrics.ObserveQuery("query", start, err)
+	return rows, err
+}
+
+func (c ctxSQLCommon) QueryRow(query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := c.db.QueryRowContext(c.ctx, query, args...)
+	metrics.ObserveQuery("query", start, row.Err())
+	return row
+}
+
+// Transactions started from the handle are rolled back by database/sql when ctx ends.
+func (c ctxSQLCommon) Begin() (*sql.Tx, error) {
+	return c.db.BeginTx(c.ctx, nil)
+}
+
+// gorm's Begin always passes context.Background(), the context of the handle is used instead.
+func (c ctxSQLCommon) BeginTx(_ context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return c.db.BeginTx(c.ctx, opts)
+}
+
+// A handle on the same connection pool as db whose statements all run with ctx.
+// The stores call it at the start of every method:
+//
+//	err := common.WithContext(ctx, s.db).Where(&condition).First(&model).Error
+//
+// A handle already inside a transaction is returned as is, the transaction carries the context it was started with.
+func WithContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	var sqlDB *sql.DB
+	switch common := db.CommonDB().(type) {
+	case *sql.DB:
+		sqlDB = common
+	case ctxSQLCommon:
+		sqlDB = common.db
+	default:
+		return db
+	}
+	// With a SQLCommon gorm.Open neither pings nor opens anything, it only wraps the pool.
+	withCtx, err := gorm.Open(db.Dialect().GetName(), ctxSQLCommon{ctx: ctx, db: sqlDB})
+	if err != nil {
+		return db
+	}
+	// The statements are logged by the logger of ctx, the one of the request with its request_id.
+	withCtx.SetLogger(gormLogger{ctx: ctx})
+	withCtx.LogMode(Logger(ctx).Enabled(ctx, slog.LevelDebug))
+	return withCtx
+}
+
+// Give every request handled after it a deadline of timeout.
+// The stores receive c.Request.Context(), so the SQL still running when it passes is cancelled.
+func RequestTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// The status to answer when a store call failed because the request context ended, used by AbortWithError:
+// 504 when its deadline passed and 503 when it was cancelled, the client went away or the server is shutting down.
+// The drivers do not all return the context error itself, so the request context is checked as well.
+func ContextErrorStatus(ctx context.Context, err error) (int, bool) {
+	if err == nil {
+		return 0, false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled) {
+		return http.StatusServiceUnavailable, true
+	}
+	return 0, false
+}
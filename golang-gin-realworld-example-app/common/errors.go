@@ -0,0 +1,285 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Stable, machine readable identifier of an API error, the clients branch on it instead of the message.
+type ErrorCode string
+
+const (
+	CodeValidation         ErrorCode = "validation_failed"
+	CodeMalformed          ErrorCode = "malformed_request"
+	CodeInvalidCredentials ErrorCode = "invalid_credentials"
+	CodeUnauthorized       ErrorCode = "unauthorized"
+	CodeForbidden          ErrorCode = "forbidden"
+	CodeEmailUnverified    ErrorCode = "email_unverified"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeDuplicate          ErrorCode = "duplicate"
+	CodeRateLimited        ErrorCode = "rate_limited"
+	CodeTimeout            ErrorCode = "timeout"
+	CodeUnavailable        ErrorCode = "unavailable"
+	CodeInternal           ErrorCode = "internal_error"
+)
+
+var codeStatus = map[ErrorCode]int{
+	CodeValidation:         http.StatusUnprocessableEntity,
+	CodeMalformed:          http.StatusBadRequest,
+	CodeInvalidCredentials: http.StatusUnauthorized,
+	CodeUnauthorized:       http.StatusUnauthorized,
+	CodeForbidden:          http.StatusForbidden,
+	CodeEmailUnverified:    http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeDuplicate:          http.StatusConflict,
+	CodeRateLimited:        http.StatusTooManyRequests,
+	CodeTimeout:            http.StatusGatewayTimeout,
+	CodeUnavailable:        http.StatusServiceUnavailable,
+	CodeInternal:           http.StatusInternalServerError,
+}
+
+// The HTTP status answered with code.
+func (code ErrorCode) Status() int {
+	if status, ok := codeStatus[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// The problem with one field of the request body, Path is the JSON path like "user.email"
+// and Code the rule that failed like "required", "min" or "unique".
+type FieldError struct {
+	Path    string `json:"path"`
+	Code    string `json:"code"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+	// The validator error the field comes from, its message is translated by the validator.
+	fe validator.FieldError
+}
+
+// The error every handler answers with, serialized as
+//
+//	{"error": {"code": "validation_failed", "message": "...", "fields": [{"path": "user.email", "code": "email", "message": "..."}]}}
+//
+// The cause is kept for the logs and never sent to the client.
+type APIError struct {
+	Code    ErrorCode    `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+	cause   error
+}
+
+func NewAPIError(code ErrorCode, message string) *APIError {
+	return &APIError{Code: code, Message: message}
+}
+
+func (e *APIError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.cause
+}
+
+func (e *APIError) Status() int {
+	return e.Code.Status()
+}
+
+func (e *APIError) WithField(field FieldError) *APIError {
+	e.Fields = append(e.Fields, field)
+	return e
+}
+
+func (e *APIError) WithCause(err error) *APIError {
+	e.cause = err
+	return e
+}
+
+// The body written for an APIError.
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+// Validation paths follow the JSON names of the request body instead of the Go field names.
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(jsonFieldName)
+	}
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// Turn the error returned by Bind into a 422 listing every invalid field,
+// or a 400 when the body could not be decoded at all. The messages are in English
+// until AbortWithError localizes them. An APIError, like the ones of FieldsError, is kept as is.
+func ValidationError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return NewAPIError(CodeMalformed, "The request body could not be decoded").WithCause(err)
+	}
+	res := NewAPIError(CodeValidation, "The request is invalid").WithCause(err)
+	for _, v := range errs {
+		// The namespace starts with the validator struct name, like UserModelValidator.user.email
+		path := v.Namespace()
+		if i := strings.Index(path, "."); i >= 0 {
+			path = path[i+1:]
+		}
+		res.WithField(FieldError{
+			Path:  path,
+			Code:  v.Tag(),
+			Param: v.Param(),
+			fe:    v,
+		})
+	}
+	return res.Localize(Translator(""))
+}
+
+// A 422 for the fields the validator tags cannot check, like a password the hasher refuses.
+// Their messages come from the field_<code> templates of the bundled languages.
+//
+//	return common.FieldsError(common.FieldError{Path: "user.password", Code: "too_long", Param: "72"})
+func FieldsError(fields ...FieldError) *APIError {
+	res := NewAPIError(CodeValidation, "The request is invalid")
+	for _, field := range fields {
+		res.WithField(field)
+	}
+	return res.Localize(Translator(""))
+}
+
+// Returned by the stores when a unique column already holds the value, errors.Is(err, ErrDuplicate) holds.
+var ErrDuplicate = errors.New("duplicate record")
+
+type DuplicateError struct {
+	// The column holding the duplicated value when the driver tells it, like "email".
+	Column string
+	Err    error
+}
+
+func (e *DuplicateError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("duplicate %s", e.Column)
+}
+
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+func (e *DuplicateError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// UNIQUE constraint failed: user_models.email
+	sqliteUniqueColumn = regexp.MustCompile(`UNIQUE constraint failed: \w+\.(\w+)`)
+	// Key (email)=(wzt@gg.cn) already exists.
+	postgresUniqueColumn = regexp.MustCompile(`Key \((\w+)\)=`)
+	// Duplicate entry 'wzt@gg.cn' for key 'user_models.uix_user_models_email', gorm names the index uix_<table>_<column>
+	mysqlUniqueColumn = regexp.MustCompile(`for key '(?:\w+\.)?uix_\w+?_models_(\w+)'`)
+)
+
+func submatch(re *regexp.Regexp, s string) string {
+	if m := re.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// Recognize the unique violation of each dialect.
+func duplicateError(err error) (*DuplicateError, bool) {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return &DuplicateError{Column: submatch(sqliteUniqueColumn, sqliteErr.Error()), Err: err}, true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return &DuplicateError{Column: submatch(postgresUniqueColumn, pqErr.Detail), Err: err}, true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		return &DuplicateError{Column: submatch(mysqlUniqueColumn, mysqlErr.Message), Err: err}, true
+	}
+	return nil, false
+}
+
+// The API error for a failed store call on resource, like "user" or "article":
+// 404 when nothing matched, 409 naming the field when a unique value is taken and 500 otherwise.
+//
+//	if err := h.Users.Create(ctx, &userModel); err != nil {
+//		common.AbortWithError(c, common.StoreAPIError(err, "user"))
+//		return
+//	}
+func StoreAPIError(err error, resource string) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	if errors.Is(err, ErrNotFound) {
+		return NewAPIError(CodeNotFound, fmt.Sprintf("The %s was not found", resource)).WithCause(err)
+	}
+	var dupErr *DuplicateError
+	if errors.As(err, &dupErr) {
+		res := NewAPIError(CodeDuplicate, fmt.Sprintf("The %s already exists", resource)).WithCause(err)
+		if dupErr.Column != "" {
+			res.WithField(FieldError{
+				Path: resource + "." + dupErr.Column,
+				Code: "unique",
+			})
+		}
+		return res.Localize(Translator(""))
+	}
+	// Kept as is, AbortWithError still has to recognize the context errors.
+	return err
+}
+
+// Abort the request with err: an APIError is written as is, an error of the request context
+// becomes a 504 or a 503 (see ContextErrorStatus) and anything else a 500 that does not leak the cause.
+// The field messages are translated to the Accept-Language of the request.
+func AbortWithError(c *gin.Context, err error) {
+	var apiErr *APIError
+	if status, ok := ContextErrorStatus(c.Request.Context(), err); ok {
+		code := CodeTimeout
+		if status == http.StatusServiceUnavailable {
+			code = CodeUnavailable
+		}
+		apiErr = NewAPIError(code, http.StatusText(status)).WithCause(err)
+	} else if !errors.As(err, &apiErr) {
+		apiErr = NewAPIError(CodeInternal, "Internal server error").WithCause(err)
+	}
+	// Recorded on the gin context for the logs, the response only carries the code and the message.
+	c.Error(err)
+	if len(apiErr.Fields) > 0 {
+		trans := Translator(c.GetHeader("Accept-Language"))
+		apiErr.Localize(trans)
+		c.Header("Content-Language", strings.ReplaceAll(trans.Locale(), "_", "-"))
+	}
+	c.AbortWithStatusJSON(apiErr.Status(), ErrorResponse{apiErr})
+}
@@ -0,0 +1,153 @@
+package common
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/locales"
+	"github.com/go-playground/locales/de"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/fr"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	de_translations "github.com/go-playground/validator/v10/translations/de"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	fr_translations "github.com/go-playground/validator/v10/translations/fr"
+)
+
+// A bundled language with the validator messages in it.
+type localeBundle struct {
+	locale   locales.Translator
+	register func(v *validator.Validate, trans ut.Translator) error
+	// The messages the validator does not know about, {0} is the field name. The keys are
+	// prefixed not to clash with the validator's own, which has a unique rule too.
+	templates map[string]string
+}
+
+// English comes first and is the fallback when none of the accepted languages is bundled.
+var localeBundles = []localeBundle{
+	{en.New(), en_translations.RegisterDefaultTranslations, map[string]string{
+		"field_unique":     "{0} has already been taken",
+		"field_required":   "{0} is required",
+		"field_invalid":    "{0} is invalid",
+		"field_too_long":   "{0} is too long",
+		"field_too_short":  "{0} is too short",
+		"field_too_simple": "{0} should mix more of lower case letters, upper case letters, digits and symbols",
+		"field_similar":    "{0} is too close to the username or the email",
+		"field_breached":   "{0} appeared in a data breach, choose another one",
+	}},
+	{fr.New(), fr_translations.RegisterDefaultTranslations, map[string]string{
+		"field_unique":     "{0} est déjà utilisé",
+		"field_required":   "{0} est obligatoire",
+		"field_invalid":    "{0} n'est pas valide",
+		"field_too_long":   "{0} est trop long",
+		"field_too_short":  "{0} est trop court",
+		"field_too_simple": "{0} doit mélanger davantage minuscules, majuscules, chiffres et symboles",
+		"field_similar":    "{0} ressemble trop au nom d'utilisateur ou à l'email",
+		"field_breached":   "{0} figure dans une fuite de données, choisissez-en un autre",
+	}},
+	{de.New(), de_translations.RegisterDefaultTranslations, map[string]string{
+		"field_unique":     "{0} ist bereits vergeben",
+		"field_required":   "{0} ist erforderlich",
+		"field_invalid":    "{0} ist ungültig",
+		"field_too_long":   "{0} ist zu lang",
+		"field_too_short":  "{0} ist zu kurz",
+		"field_too_simple": "{0} sollte mehr Kleinbuchstaben, Großbuchstaben, Ziffern und Sonderzeichen mischen",
+		"field_similar":    "{0} ähnelt zu sehr dem Benutzernamen oder der E-Mail",
+		"field_breached":   "{0} ist in einem Datenleck aufgetaucht, bitte ein anderes wählen",
+	}},
+}
+
+var universalTranslator *ut.UniversalTranslator
+
+func init() {
+	supported := make([]locales.Translator, len(localeBundles))
+	for i, bundle := range localeBundles {
+		supported[i] = bundle.locale
+	}
+	universalTranslator = ut.New(localeBundles[0].locale, supported...)
+
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	for _, bundle := range localeBundles {
+		trans, _ := universalTranslator.GetTranslator(bundle.locale.Locale())
+		if ok {
+			if err := bundle.register(v, trans); err != nil {
+				panic(err)
+			}
+		}
+		for key, text := range bundle.templates {
+			if err := trans.Add(key, text, false); err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+// The translator for the Accept-Language header: the languages are tried by quality, a regional
+// variant like fr-CA falls back to its language and English is used when nothing matches.
+func Translator(acceptLanguage string) ut.Translator {
+	trans, _ := universalTranslator.FindTranslator(acceptedLocales(acceptLanguage)...)
+	return trans
+}
+
+// The locales named by an Accept-Language header, best first, each followed by its base language.
+//
+//	acceptedLocales("de;q=0.5, fr-CA") == []string{"fr_CA", "fr", "de"}
+func acceptedLocales(header string) []string {
+	type accepted struct {
+		tag     string
+		quality float64
+	}
+	var tags []accepted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+		if tag == "" || tag == "*" || quality <= 0 {
+			continue
+		}
+		tags = append(tags, accepted{strings.ReplaceAll(tag, "-", "_"), quality})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].quality > tags[j].quality })
+
+	var res []string
+	for _, tag := range tags {
+		res = append(res, tag.tag)
+		if base, _, regional := strings.Cut(tag.tag, "_"); regional {
+			res = append(res, base)
+		}
+	}
+	return res
+}
+
+// The last segment of a field path, the name the messages talk about.
+func fieldName(path string) string {
+	return path[strings.LastIndex(path, ".")+1:]
+}
+
+func (f FieldError) translate(trans ut.Translator) string {
+	if f.fe != nil {
+		// Translate answers with the raw validator error when the rule has no translation.
+		if message := f.fe.Translate(trans); message != f.fe.Error() {
+			return message
+		}
+	} else if message, err := trans.T("field_"+f.Code, fieldName(f.Path)); err == nil {
+		return message
+	}
+	message, _ := trans.T("field_invalid", fieldName(f.Path))
+	return message
+}
+
+// Rewrite the message of every field in the language of trans.
+func (e *APIError) Localize(trans ut.Translator) *APIError {
+	for i := range e.Fields {
+		e.Fields[i].Message = e.Fields[i].translate(trans)
+	}
+	return e
+}
@@ -0,0 +1,313 @@
+package common
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The kid of the tokens issued before the keyring, which have none.
+const DefaultKeyID = "default"
+
+// The algorithms a SigningKey can use.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// The smallest RSA key ParsePrivateKey and ParsePublicKey accept.
+const MinRSABits = 2048
+
+// The iss and aud claims of the tokens, main sets them from config.JWT.
+var (
+	TokenIssuer   = "realworld"
+	TokenAudience = "realworld"
+)
+
+// A key signing or verifying the tokens, ID is the kid header of the tokens it signs.
+// HS256 keys have a Secret, RS256 and EdDSA keys a Private key, or only a Public one when they just verify.
+type SigningKey struct {
+	ID string
+	// HS256 when empty.
+	Algorithm string
+	Secret    []byte
+	Private   crypto.Signer
+	// Taken from Private when nil.
+	Public crypto.PublicKey
+}
+
+func (key SigningKey) canSign() bool {
+	return key.Secret != nil || key.Private != nil
+}
+
+func (key SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(key.Algorithm)
+}
+
+// The keys of the tokens: the active key signs the new ones, every key verifies the ones carrying its kid.
+// Replace swaps the keys while serving, so a key is rotated by adding the new one as active and removing the
+// old one once its last tokens expired.
+type Keyring struct {
+	mu     sync.RWMutex
+	keys   map[string]SigningKey
+	active string
+}
+
+func NewKeyring(keys []SigningKey, active string) (*Keyring, error) {
+	k := &Keyring{}
+	if err := k.Replace(keys, active); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// The keyring of GenToken and AuthMiddleware. It holds NBSecretPassword for the tests until main loads the
+// configured keys.
+var Keys, _ = NewKeyring([]SigningKey{{ID: DefaultKeyID, Secret: []byte(NBSecretPassword)}}, DefaultKeyID)
+
+// Swap every key at once, the keyring is left as it was on error.
+func (k *Keyring) Replace(keys []SigningKey, active string) error {
+	byID := make(map[string]SigningKey, len(keys))
+	for _, key := range keys {
+		if key.ID == "" {
+			return errors.New("keyring: a key needs a kid")
+		}
+		if _, ok := byID[key.ID]; ok {
+			return fmt.Errorf("keyring: kid %q is used twice", key.ID)
+		}
+		if key.Algorithm == "" {
+			key.Algorithm = AlgHS256
+		}
+		if key.Public == nil && key.Private != nil {
+			key.Public = key.Private.Public()
+		}
+		if err := checkKey(key); err != nil {
+			return fmt.Errorf("keyring: kid %q: %w", key.ID, err)
+		}
+		byID[key.ID] = key
+	}
+	if key, ok := byID[active]; !ok || !key.canSign() {
+		return fmt.Errorf("keyring: the active kid %q is not one of the keys able to sign", active)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys, k.active = byID, active
+	return nil
+}
+
+func checkKey(key SigningKey) error {
+	var ok bool
+	switch key.Algorithm {
+	case AlgHS256:
+		ok = len(key.Secret) > 0 && key.Private == nil && key.Public == nil
+	case AlgRS256:
+		_, ok = key.Public.(*rsa.PublicKey)
+	case AlgEdDSA:
+		_, ok = key.Public.(ed25519.PublicKey)
+	default:
+		return fmt.Errorf("unsupported algorithm %q", key.Algorithm)
+	}
+	if !ok || (key.Algorithm != AlgHS256 && key.Secret != nil) {
+		return fmt.Errorf("the key does not fit %s", key.Algorithm)
+	}
+	return nil
+}
+
+// The kid of the key signing the new tokens.
+func (k *Keyring) Active() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// Sign claims with the active key, its kid goes in the header.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	key := k.keys[k.active]
+	k.mu.RUnlock()
+	method := key.method()
+	if method == nil {
+		return "", fmt.Errorf("no signing key %q", k.active)
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+	if key.Private != nil {
+		return token.SignedString(key.Private)
+	}
+	return token.SignedString(key.Secret)
+}
+
+// The jwt.Keyfunc finding the key of a token by its kid, a token without one is checked with DefaultKeyID.
+// The algorithm of the token has to be the one of its key, a public RSA key is never taken for an HMAC secret.
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid := DefaultKeyID
+	if value, ok := token.Header["kid"]; ok {
+		if kid, ok = value.(string); !ok {
+			return nil, errors.New("the kid should be a string")
+		}
+	}
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("kid %q signs with %s, not %v", kid, key.Algorithm, token.Header["alg"])
+	}
+	if key.Algorithm == AlgHS256 {
+		return key.Secret, nil
+	}
+	return key.Public, nil
+}
+
+// The claims of the access tokens. UserID repeats Subject as a number, for the clients reading the id claim.
+type Claims struct {
+	UserID    uint   `json:"id"`
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// The algorithms Parse accepts, main sets them from config.JWT. A token signed with another one is refused
+// before its key is even looked up.
+var TokenAlgorithms = []string{AlgHS256, AlgRS256, AlgEdDSA}
+
+// Verify the signature of raw and its exp, iat, iss and aud claims, sub and jti are required too.
+// A claim of the wrong type fails the parsing, it never reaches the handlers.
+func (k *Keyring) Parse(raw string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(raw, claims, k.Keyfunc,
+		jwt.WithValidMethods(TokenAlgorithms),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(TokenIssuer),
+		jwt.WithAudience(TokenAudience),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" || claims.ID == "" {
+		return nil, fmt.Errorf("%w: sub and jti are required", jwt.ErrTokenRequiredClaimMissing)
+	}
+	if claims.UserID == 0 || claims.Subject != strconv.FormatUint(uint64(claims.UserID), 10) {
+		return nil, fmt.Errorf("%w: id %d does not match sub %q", jwt.ErrTokenInvalidClaims, claims.UserID, claims.Subject)
+	}
+	return claims, nil
+}
+
+// A JSON Web Key, RFC 7517, of the public half of an RS256 or EdDSA key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// The public keys of the keyring, the HS256 secrets are never published.
+func (k *Keyring) JWKS() JWKSet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.keys {
+		jwk := JWK{ID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].ID < set.Keys[j].ID })
+	return set
+}
+
+// Serve the JWKS of Keys, for the services verifying the tokens of the API.
+//
+//	r.GET("/.well-known/jwks.json", common.JWKSHandler)
+func JWKSHandler(c *gin.Context) {
+	// Short enough for the verifiers to see a new key soon after a rotation.
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, Keys.JWKS())
+}
+
+// Parse a PKCS #8 or PKCS #1 (RSA) private key in PEM.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	var key interface{}
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+	return signer, checkRSASize(signer.Public())
+}
+
+// Parse a PKIX public key in PEM.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return key, checkRSASize(key)
+}
+
+func checkRSASize(key crypto.PublicKey) error {
+	if rsaKey, ok := key.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < MinRSABits {
+		return fmt.Errorf("the RSA key has %d bits, it should have at least %d", rsaKey.N.BitLen(), MinRSABits)
+	}
+	return nil
+}
+
+// A random token ID, the jti claim.
+func newTokenID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,139 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The header a request ID is read from and answered in, so a proxy or a client can correlate its own logs.
+const RequestIDHeader = "X-Request-ID"
+
+// The longest request ID taken from a client, longer or unprintable ones are replaced.
+const maxRequestIDLength = 128
+
+type loggerKey struct{}
+
+type requestIDKey struct{}
+
+// A logger writing one JSON object per line to w, dropping the records below level.
+func NewLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// ctx carrying logger, returned by Logger(ctx).
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// The logger of ctx, set by RequestLogger for a request with its request_id attached, or slog.Default().
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// The ID RequestLogger gave the request of ctx, empty outside of a request.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Log every request after it is handled as one "request" record, an error record for a 5xx.
+// The request gets the X-Request-ID it came with, or a new random one, which is also answered in the header.
+// Handlers and stores reach the logger, with the request_id attached, through Logger(c.Request.Context()),
+// so the SQL logged by the handles of WithContext carries it too.
+// It should be the first middleware, the my_user_id set by AuthMiddleware is read once the request is handled.
+func RequestLogger(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+		requestLogger := logger.With("request_id", id)
+		ctx := context.WithValue(c.Request.Context(), requestIDKey{}, id)
+		c.Request = c.Request.WithContext(WithLogger(ctx, requestLogger))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []any{
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"path", c.Request.URL.Path,
+			"status", status,
+			"latency", time.Since(start),
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		}
+		if userID := c.GetUint("my_user_id"); userID != 0 {
+			attrs = append(attrs, "my_user_id", userID)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.Errors())
+		}
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		requestLogger.Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// Only short IDs of letters, digits and -_.: are taken from the client, they end up in every log line.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// The gorm logger of the handles of WithContext, the statements are logged at debug level by the logger of ctx.
+// The bound values are left out, they hold password hashes and emails.
+type gormLogger struct {
+	ctx context.Context
+}
+
+func (l gormLogger) Print(v ...interface{}) {
+	logger := Logger(l.ctx)
+	if !logger.Enabled(l.ctx, slog.LevelDebug) || len(v) < 2 {
+		return
+	}
+	switch {
+	case v[0] == "sql" && len(v) == 6:
+		logger.DebugContext(l.ctx, "query", "sql", v[3], "duration", v[2], "rows", v[5], "source", v[1])
+	default:
+		logger.DebugContext(l.ctx, "database", "message", fmt.Sprint(v[2:]...), "source", v[1])
+	}
+}
+
+// Answer a panicking handler with a 500 like AbortWithError, the panic and its stack end up in the record of
+// RequestLogger instead of gin's plain text output.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		AbortWithError(c, fmt.Errorf("panic: %v\n%s", recovered, debug.Stack()))
+	})
+}
@@ -2,8 +2,18 @@ package common
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"time"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
@@ -14,7 +24,15 @@ import (
 	"testing"
 )
 
+// The file permission checks below only make sense for the SQLite file.
+func skipUnlessSQLite(t *testing.T) {
+	if dialect, _, _ := ParseDSN(TestDSN()); dialect != DialectSQLite {
+		t.Skip("test database is not SQLite")
+	}
+}
+
 func TestConnectingDatabase(t *testing.T) {
+	skipUnlessSQLite(t)
 	asserts := assert.New(t)
 	db := Init()
 	// Test create & close DB
@@ -36,6 +54,7 @@ func TestConnectingDatabase(t *testing.T) {
 }
 
 func TestConnectingTestDatabase(t *testing.T) {
+	skipUnlessSQLite(t)
 	asserts := assert.New(t)
 	// Test create & close DB
 	db := TestDBInit()
@@ -59,6 +78,34 @@ func TestConnectingTestDatabase(t *testing.T) {
 	asserts.Error(err, "Db should not exist")
 }
 
+func TestParseDSN(t *testing.T) {
+	asserts := assert.New(t)
+
+	var dsnTests = []struct {
+		dsn     string
+		dialect string
+		source  string
+	}{
+		{"./../gorm.db", DialectSQLite, "./../gorm.db"},
+		{"sqlite3:///tmp/gorm.db", DialectSQLite, "/tmp/gorm.db"},
+		{"sqlite://gorm.db", DialectSQLite, "gorm.db"},
+		{"postgres://u:p@localhost:5432/realworld?sslmode=disable", DialectPostgres, "postgres://u:p@localhost:5432/realworld?sslmode=disable"},
+		{"postgresql://localhost/realworld", DialectPostgres, "postgresql://localhost/realworld"},
+		{"mysql://u:p@tcp(localhost:3306)/realworld", DialectMySQL, "u:p@tcp(localhost:3306)/realworld?parseTime=true"},
+	}
+	for _, dsnTest := range dsnTests {
+		dialect, source, err := ParseDSN(dsnTest.dsn)
+		asserts.NoError(err, dsnTest.dsn)
+		asserts.Equal(dsnTest.dialect, dialect, dsnTest.dsn)
+		asserts.Equal(dsnTest.source, source, dsnTest.dsn)
+	}
+
+	_, _, err := ParseDSN("mssql://localhost")
+	asserts.Error(err, "unsupported scheme should return error")
+	_, _, err = ParseDSN("mysql://localhost:3306")
+	asserts.Error(err, "invalid mysql dsn should return error")
+}
+
 func TestRandString(t *testing.T) {
 	asserts := assert.New(t)
 
@@ -79,7 +126,22 @@ func TestGenToken(t *testing.T) {
 	token := GenToken(2)
 
 	asserts.IsType(token, string("token"), "token type should be string")
-	asserts.Len(token, 115, "JWT's length should be 115")
+	claims, err := Keys.Parse(token)
+	asserts.NoError(err)
+	asserts.Equal(uint(2), claims.UserID)
+	asserts.Equal("2", claims.Subject)
+	asserts.Equal(TokenIssuer, claims.Issuer)
+	asserts.Equal(jwt.ClaimStrings{TokenAudience}, claims.Audience)
+	asserts.Len(claims.ID, 32)
+	other, _ := Keys.Parse(GenToken(2))
+	asserts.NotEqual(claims.ID, other.ID, "every token should have its own jti")
+
+	keys := Keys
+	defer func() { Keys = keys }()
+	Keys = &Keyring{}
+	_, err = GenAccessToken(2, "")
+	asserts.Error(err, "a keyring without key should not sign")
+	asserts.Panics(func() { GenToken(2) }, "GenToken should not hand out an empty token")
 }
 
 func TestNewValidatorError(t *testing.T) {
@@ -229,10 +291,10 @@ func TestGenToken_Expiration(t *testing.T) {
 		exp := int64(claims["exp"].(float64))
 		now := time.Now().Unix()
 		
-		// Token should expire approximately 24 hours from now
+		// Token should expire AccessTokenTTL from now
 		// Allow 5 second tolerance
-		expectedExp := now + 24*60*60
-		asserts.InDelta(expectedExp, exp, 5, "Token should expire in 24 hours")
+		expectedExp := now + int64(AccessTokenTTL/time.Second)
+		asserts.InDelta(expectedExp, exp, 5, "Token should expire after AccessTokenTTL")
 	}
 }
 
@@ -298,4 +360,449 @@ func TestCommonError_Structure(t *testing.T) {
 	asserts.Len(multiError.Errors, 2)
 	asserts.Equal("error1", multiError.Errors["field1"])
 	asserts.Equal("error2", multiError.Errors["field2"])
-}
\ No newline at end of file
+}
+func TestWithContext(t *testing.T) {
+	asserts := assert.New(t)
+	db := TestDBInit()
+	defer TestDBFree(db)
+
+	asserts.NoError(WithContext(context.Background(), db).Exec("SELECT 1").Error, "a live context should run the query")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	withCtx := WithContext(ctx, db)
+	asserts.ErrorIs(withCtx.Exec("SELECT 1").Error, context.Canceled, "a cancelled context should stop the query")
+	asserts.ErrorIs(withCtx.Begin().Error, context.Canceled, "a cancelled context should not start a transaction")
+	asserts.NoError(WithContext(context.Background(), withCtx).Exec("SELECT 1").Error,
+		"a handle can be rebound to another context")
+}
+
+func TestRequestTimeout(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(RequestTimeout(10 * time.Millisecond))
+	r.GET("/slow", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		AbortWithError(c, StoreAPIError(c.Request.Context().Err(), "slow"))
+	})
+	r.GET("/fast", func(c *gin.Context) {
+		AbortWithError(c, StoreAPIError(ErrNotFound, "fast"))
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	asserts.Equal(http.StatusGatewayTimeout, w.Code, "a passed deadline should answer 504")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/fast", nil))
+	asserts.Equal(http.StatusNotFound, w.Code, "other store errors should keep their status")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	status, ok := ContextErrorStatus(ctx, errors.New("driver: interrupted"))
+	asserts.True(ok)
+	asserts.Equal(http.StatusServiceUnavailable, status, "a cancelled request should answer 503")
+	_, ok = ContextErrorStatus(context.Background(), ErrNotFound)
+	asserts.False(ok)
+}
+
+func TestValidationError(t *testing.T) {
+	asserts := assert.New(t)
+
+	type Login struct {
+		Username string `json:"username" binding:"required,alphanum,min=4,max=255"`
+		Password string `json:"password" binding:"required,min=8,max=255"`
+	}
+	type LoginValidator struct {
+		User Login `json:"user"`
+	}
+
+	r := gin.New()
+	r.POST("/login", func(c *gin.Context) {
+		var validator LoginValidator
+		if err := Bind(c, &validator); err != nil {
+			AbortWithError(c, ValidationError(err))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "you are logged in"})
+	})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"user":{"username": "wz","password": "0122"}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+	asserts.JSONEq(`{"error":{"code":"validation_failed","message":"The request is invalid","fields":[
+		{"path":"user.username","code":"min","param":"4","message":"username must be at least 4 characters in length"},
+		{"path":"user.password","code":"min","param":"8","message":"password must be at least 8 characters in length"}]}}`, w.Body.String(),
+		"every invalid field should be listed with its JSON path")
+
+	w = post(`{"user":`)
+	asserts.Equal(http.StatusBadRequest, w.Code, "a body that does not decode is malformed")
+	asserts.Contains(w.Body.String(), `"code":"malformed_request"`)
+}
+
+func TestStoreAPIError(t *testing.T) {
+	asserts := assert.New(t)
+
+	err := StoreAPIError(ErrNotFound, "article")
+	var apiErr *APIError
+	if asserts.ErrorAs(err, &apiErr) {
+		asserts.Equal(CodeNotFound, apiErr.Code)
+		asserts.Equal(http.StatusNotFound, apiErr.Status())
+		asserts.Equal("The article was not found", apiErr.Message)
+	}
+	asserts.ErrorIs(err, ErrNotFound, "the cause should be kept")
+
+	err = StoreAPIError(&DuplicateError{Column: "slug"}, "article")
+	if asserts.ErrorAs(err, &apiErr) {
+		asserts.Equal(http.StatusConflict, apiErr.Status())
+		asserts.Equal([]FieldError{{Path: "article.slug", Code: "unique", Message: "slug has already been taken"}}, apiErr.Fields)
+	}
+
+	forbidden := NewAPIError(CodeForbidden, "not yours")
+	asserts.Same(forbidden, StoreAPIError(forbidden, "article"), "API errors should pass through")
+	asserts.ErrorIs(StoreAPIError(context.Canceled, "article"), context.Canceled)
+}
+
+func TestDuplicateError(t *testing.T) {
+	asserts := assert.New(t)
+	db := TestDBInit()
+	defer TestDBFree(db)
+
+	type UniqueModel struct {
+		ID    uint
+		Email string `gorm:"unique_index"`
+	}
+	asserts.NoError(db.AutoMigrate(&UniqueModel{}).Error)
+	asserts.NoError(db.Create(&UniqueModel{Email: "wzt@gg.cn"}).Error)
+	err := StoreError(db.Create(&UniqueModel{Email: "wzt@gg.cn"}).Error)
+	asserts.ErrorIs(err, ErrDuplicate, "a unique violation should be recognized")
+	var dupErr *DuplicateError
+	if asserts.ErrorAs(err, &dupErr) {
+		asserts.Equal("email", dupErr.Column)
+	}
+	asserts.NotErrorIs(StoreError(errors.New("no such table: unique_models")), ErrDuplicate)
+}
+
+func TestAbortWithError(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.GET("/forbidden", func(c *gin.Context) {
+		AbortWithError(c, NewAPIError(CodeForbidden, "Only the author can change this article"))
+	})
+	r.GET("/crash", func(c *gin.Context) {
+		AbortWithError(c, errors.New("UNIQUE constraint failed: secret_table.column"))
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/forbidden", nil))
+	asserts.Equal(http.StatusForbidden, w.Code)
+	asserts.JSONEq(`{"error":{"code":"forbidden","message":"Only the author can change this article"}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/crash", nil))
+	asserts.Equal(http.StatusInternalServerError, w.Code)
+	asserts.JSONEq(`{"error":{"code":"internal_error","message":"Internal server error"}}`, w.Body.String(),
+		"driver errors should not leak to the client")
+}
+
+func TestAcceptedLocales(t *testing.T) {
+	asserts := assert.New(t)
+
+	asserts.Empty(acceptedLocales(""))
+	asserts.Equal([]string{"fr_CA", "fr", "de"}, acceptedLocales("de;q=0.5, fr-CA"), "better quality should come first")
+	asserts.Equal([]string{"de", "en"}, acceptedLocales("de, *;q=0.1, fr;q=0, en;q=0.3"), "refused and wildcard languages should be skipped")
+
+	asserts.Equal("en", Translator("").Locale())
+	asserts.Equal("fr", Translator("fr-CA,fr;q=0.9").Locale(), "a regional variant should fall back to its language")
+	asserts.Equal("de", Translator("ja, de;q=0.8").Locale(), "missing languages should be skipped")
+	asserts.Equal("en", Translator("ja, zh;q=0.8").Locale(), "English should be the last resort")
+}
+
+func TestLocalizedValidationError(t *testing.T) {
+	asserts := assert.New(t)
+
+	type Login struct {
+		Username string `json:"username" binding:"required,alphanum,min=4,max=255"`
+		Email    string `json:"email" binding:"required,email"`
+	}
+	type LoginValidator struct {
+		User Login `json:"user"`
+	}
+
+	r := gin.New()
+	r.POST("/login", func(c *gin.Context) {
+		var validator LoginValidator
+		if err := Bind(c, &validator); err != nil {
+			AbortWithError(c, ValidationError(err))
+			return
+		}
+		AbortWithError(c, StoreAPIError(&DuplicateError{Column: "email"}, "user"))
+	})
+	post := func(body, acceptLanguage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Language", acceptLanguage)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"user":{"username": "wz","email": "gg"}}`, "fr-FR, en;q=0.5")
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+	asserts.Equal("fr", w.Header().Get("Content-Language"))
+	asserts.Contains(w.Body.String(), `"message":"username doit faire une taille minimum de 4 caractères"`)
+	asserts.Contains(w.Body.String(), `"message":"email doit être une adresse email valide"`)
+
+	w = post(`{"user":{"username": "wz","email": "gg"}}`, "de")
+	asserts.Equal("de", w.Header().Get("Content-Language"))
+	asserts.Contains(w.Body.String(), `"code":"min"`, "the codes should not be translated")
+	asserts.Contains(w.Body.String(), `"message":"email muss eine gültige E-Mail-Adresse sein"`)
+
+	w = post(`{"user":{"username": "wangzitian0","email": "wzt@gg.cn"}}`, "de")
+	asserts.Equal(http.StatusConflict, w.Code)
+	asserts.Contains(w.Body.String(), `"message":"email ist bereits vergeben"`)
+
+	w = post(`{"user":{"username": "wangzitian0","email": "wzt@gg.cn"}}`, "ja")
+	asserts.Equal("en", w.Header().Get("Content-Language"))
+	asserts.Contains(w.Body.String(), `"message":"email has already been taken"`)
+}
+
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var record map[string]interface{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("not a JSON line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRequestLogger(t *testing.T) {
+	asserts := assert.New(t)
+	db := TestDBInit()
+	defer TestDBFree(db)
+
+	var buf bytes.Buffer
+	r := gin.New()
+	r.Use(RequestLogger(NewLogger(&buf, slog.LevelDebug)), Recovery())
+	r.GET("/users/:id", func(c *gin.Context) {
+		c.Set("my_user_id", uint(7))
+		WithContext(c.Request.Context(), db).Exec("SELECT ?", "secret")
+		c.Status(http.StatusNoContent)
+	})
+	r.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	req := httptest.NewRequest("GET", "/users/7", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal("abc-123", w.Header().Get(RequestIDHeader), "a client request ID should be kept")
+	records := decodeLogLines(t, &buf)
+	if asserts.Len(records, 2) {
+		query, request := records[0], records[1]
+		asserts.Equal("query", query["msg"])
+		asserts.Equal("abc-123", query["request_id"], "the SQL should carry the request ID")
+		asserts.NotContains(buf.String(), "secret", "the bound values should not be logged")
+		asserts.Equal("request", request["msg"])
+		asserts.Equal("INFO", request["level"])
+		asserts.Equal("abc-123", request["request_id"])
+		asserts.Equal("/users/:id", request["route"])
+		asserts.EqualValues(http.StatusNoContent, request["status"])
+		asserts.EqualValues(7, request["my_user_id"])
+		asserts.Contains(request, "latency")
+	}
+
+	buf.Reset()
+	req = httptest.NewRequest("GET", "/panic", nil)
+	req.Header.Set(RequestIDHeader, "not a valid id")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusInternalServerError, w.Code)
+	asserts.Len(w.Header().Get(RequestIDHeader), 32, "an invalid request ID should be replaced")
+	records = decodeLogLines(t, &buf)
+	if asserts.Len(records, 1) {
+		asserts.Equal("ERROR", records[0]["level"])
+		asserts.Equal(w.Header().Get(RequestIDHeader), records[0]["request_id"])
+		asserts.Contains(fmt.Sprint(records[0]["errors"]), "panic: boom")
+	}
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	asserts := assert.New(t)
+
+	asserts.Same(slog.Default(), Logger(context.Background()))
+	asserts.Empty(RequestID(context.Background()))
+	logger := NewLogger(io.Discard, slog.LevelInfo)
+	asserts.Same(logger, Logger(WithLogger(context.Background(), logger)))
+}
+
+func TestKeyring(t *testing.T) {
+	asserts := assert.New(t)
+	old := SigningKey{ID: "2026-01", Secret: []byte("an old secret of at least 32 bytes!!")}
+	current := SigningKey{ID: "2026-04", Secret: []byte("the current secret of 32 bytes at least")}
+	keyring, err := NewKeyring([]SigningKey{old}, "2026-01")
+	asserts.NoError(err)
+	claims := jwt.MapClaims{"id": 1, "exp": time.Now().Add(time.Hour).Unix()}
+	verify := func(token string) error {
+		_, err := jwt.Parse(token, keyring.Keyfunc)
+		return err
+	}
+
+	oldToken, err := keyring.Sign(claims)
+	asserts.NoError(err)
+	asserts.NoError(verify(oldToken))
+
+	asserts.NoError(keyring.Replace([]SigningKey{current, old}, "2026-04"))
+	asserts.Equal("2026-04", keyring.Active())
+	newToken, _ := keyring.Sign(claims)
+	parsed, _ := jwt.Parse(newToken, keyring.Keyfunc)
+	asserts.Equal("2026-04", parsed.Header["kid"], "the new tokens should be signed by the active key")
+	asserts.NoError(verify(oldToken), "a retired key should still verify its tokens")
+
+	asserts.NoError(keyring.Replace([]SigningKey{current}, "2026-04"))
+	asserts.ErrorContains(verify(oldToken), `unknown kid "2026-01"`)
+	asserts.NoError(verify(newToken))
+
+	asserts.Error(keyring.Replace([]SigningKey{old}, "2026-04"), "the active key should be in the keyring")
+	asserts.Error(keyring.Replace([]SigningKey{current, current}, "2026-04"))
+	asserts.Equal("2026-04", keyring.Active(), "a failed Replace should keep the keys")
+
+	// Issued before the keyring, without kid
+	legacy, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(current.Secret)
+	asserts.Error(verify(legacy))
+	asserts.NoError(keyring.Replace([]SigningKey{current, {ID: DefaultKeyID, Secret: current.Secret}}, "2026-04"))
+	asserts.NoError(verify(legacy), "a token without kid should be checked with the default key")
+
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	asserts.Error(verify(none))
+}
+
+func TestAsymmetricKeys(t *testing.T) {
+	asserts := assert.New(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	asserts.NoError(err)
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	asserts.NoError(err)
+	secret := SigningKey{ID: "hmac", Secret: []byte("a secret of at least 32 bytes long!!")}
+	keyring, err := NewKeyring([]SigningKey{
+		secret,
+		{ID: "rsa", Algorithm: AlgRS256, Private: rsaKey},
+		{ID: "ed", Algorithm: AlgEdDSA, Public: edPublic},
+	}, "rsa")
+	asserts.NoError(err)
+	now := time.Now()
+	claims := jwt.MapClaims{"id": 1, "sub": "1", "jti": "a", "iss": TokenIssuer, "aud": TokenAudience,
+		"iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+
+	token, err := keyring.Sign(claims)
+	asserts.NoError(err)
+	parsed, _, _ := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	asserts.Equal("RS256", parsed.Header["alg"])
+	_, err = keyring.Parse(token)
+	asserts.NoError(err)
+
+	edToken := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	edToken.Header["kid"] = "ed"
+	signed, _ := edToken.SignedString(edKey)
+	_, err = keyring.Parse(signed)
+	asserts.NoError(err, "a verify-only key should check its tokens")
+	asserts.Error(keyring.Replace([]SigningKey{{ID: "ed", Algorithm: AlgEdDSA, Public: edPublic}}, "ed"),
+		"a key without its private half cannot sign")
+
+	// The public key of "rsa" used as an HMAC secret
+	publicDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = "rsa"
+	for _, key := range [][]byte{publicDER, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})} {
+		signed, _ := forged.SignedString(key)
+		_, err = keyring.Parse(signed)
+		asserts.ErrorContains(err, "signs with RS256", "a token should not pick the algorithm of its key")
+	}
+
+	set := keyring.JWKS()
+	asserts.Len(set.Keys, 2, "the HMAC secrets should not be published")
+	asserts.Equal(JWK{KeyType: "OKP", ID: "ed", Use: "sig", Algorithm: AlgEdDSA, Curve: "Ed25519",
+		X: base64.RawURLEncoding.EncodeToString(edPublic)}, set.Keys[0])
+	asserts.Equal("RSA", set.Keys[1].KeyType)
+	asserts.Equal("AQAB", set.Keys[1].E)
+	n, _ := base64.RawURLEncoding.DecodeString(set.Keys[1].N)
+	asserts.Equal(rsaKey.N.Bytes(), n)
+}
+
+func TestParseClaims(t *testing.T) {
+	asserts := assert.New(t)
+	now := time.Now()
+	valid := jwt.MapClaims{"id": 1, "sub": "1", "jti": "a", "iss": TokenIssuer, "aud": TokenAudience,
+		"iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+	token, _ := Keys.Sign(valid)
+	_, err := Keys.Parse(token)
+	asserts.NoError(err)
+
+	for name, change := range map[string]func(jwt.MapClaims){
+		"iss":        func(c jwt.MapClaims) { c["iss"] = "someone-else" },
+		"aud":        func(c jwt.MapClaims) { c["aud"] = "another-api" },
+		"exp":        func(c jwt.MapClaims) { delete(c, "exp") },
+		"expired":    func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() },
+		"iat":        func(c jwt.MapClaims) { c["iat"] = now.Add(time.Hour).Unix() },
+		"sub":        func(c jwt.MapClaims) { delete(c, "sub") },
+		"jti":        func(c jwt.MapClaims) { c["jti"] = "" },
+		"no aud":     func(c jwt.MapClaims) { delete(c, "aud") },
+		"no iss":     func(c jwt.MapClaims) { delete(c, "iss") },
+		"id string":  func(c jwt.MapClaims) { c["id"] = "1" },
+		"id float":   func(c jwt.MapClaims) { c["id"] = 1.5 },
+		"id other":   func(c jwt.MapClaims) { c["id"] = 2 },
+		"no id":      func(c jwt.MapClaims) { delete(c, "id") },
+		"sub object": func(c jwt.MapClaims) { c["sub"] = map[string]int{"id": 1} },
+	} {
+		claims := jwt.MapClaims{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		change(claims)
+		token, _ := Keys.Sign(claims)
+		_, err := Keys.Parse(token)
+		asserts.Error(err, name)
+	}
+
+	defer func(algorithms []string) { TokenAlgorithms = algorithms }(TokenAlgorithms)
+	TokenAlgorithms = []string{AlgRS256}
+	_, err = Keys.Parse(token)
+	asserts.ErrorIs(err, jwt.ErrTokenSignatureInvalid, "an algorithm off the list should be refused")
+}
+
+func TestParseKeys(t *testing.T) {
+	asserts := assert.New(t)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	private, err := ParsePrivateKey(pkcs1)
+	asserts.NoError(err)
+	asserts.True(rsaKey.Equal(private))
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	private, err = ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	asserts.NoError(err)
+	der, _ = x509.MarshalPKIXPublicKey(private.Public())
+	public, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	asserts.NoError(err)
+	asserts.Equal(edKey.Public(), public)
+
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	_, err = ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(small)}))
+	asserts.ErrorContains(err, "at least 2048")
+	_, err = ParsePublicKey([]byte("not a key"))
+	asserts.ErrorContains(err, "no PEM block")
+}
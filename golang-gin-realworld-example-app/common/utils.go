@@ -2,8 +2,12 @@
 package common
 
 import (
+	cryptorand "crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"math/rand"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -24,31 +28,74 @@ func RandString(n int) string {
 	return string(b)
 }
 
-// Keep this two config private, it should not expose to open source
-const NBSecretPassword = "A String Very Very Very Strong!!@##$!@#$"
+// A random string of n bytes from crypto/rand in URL safe base64, for the secrets RandString is too weak for.
+func RandToken(n int) string {
+	b := make([]byte, n)
+	cryptorand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Keep this two config private, it should not expose to open source.
+// NBSecretPassword is only the key of the tests, main loads the keys of config.JWT into Keys at startup.
+var NBSecretPassword = "A String Very Very Very Strong!!@##$!@#$"
+
 const NBRandomPassword = "A String Very Very Very Niubilty!!@##$!@#4"
 
+// How long the access tokens live, main sets it from config.JWT. The clients get a new one with their refresh token.
+var AccessTokenTTL = 15 * time.Minute
+
+// A signed access token, with the claims the revocation list needs.
+type AccessToken struct {
+	Token     string
+	ID        string
+	ExpiresAt time.Time
+}
+
+// Sign an access token for the user id, session is its sid claim, the family of refresh tokens it was issued with.
+func GenAccessToken(id uint, session string) (AccessToken, error) {
+	// Signed by the active key of Keys, with its kid in the header
+	now := time.Now()
+	access := AccessToken{ID: newTokenID(), ExpiresAt: now.Add(AccessTokenTTL)}
+	claims := Claims{
+		UserID:    id,
+		SessionID: session,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(id), 10),
+			Issuer:    TokenIssuer,
+			Audience:  jwt.ClaimStrings{TokenAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(access.ExpiresAt),
+			ID:        access.ID,
+		},
+	}
+	var err error
+	access.Token, err = Keys.Sign(claims)
+	return access, err
+}
+
 // A Util function to generate jwt_token which can be used in the request header
+// It belongs to no session, a logout only revokes the token itself.
+// Meant for the tests, it panics when Keys cannot sign: the code serving requests uses GenAccessToken.
 func GenToken(id uint) string {
-	jwt_token := jwt.New(jwt.GetSigningMethod("HS256"))
-	// Set some claims
-	jwt_token.Claims = jwt.MapClaims{
-		"id":  id,
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
+	access, err := GenAccessToken(id, "")
+	if err != nil {
+		panic(fmt.Sprintf("common.GenToken: %v", err))
 	}
-	// Sign and get the complete encoded token as a string
-	token, _ := jwt_token.SignedString([]byte(NBSecretPassword))
-	return token
+	return access.Token
 }
 
 // My own Error type that will help return my customized Error info
 //  {"database": {"hello":"no such table", error: "not_exists"}}
+//
+// Deprecated: the handlers answer with an APIError through AbortWithError, which carries a stable code.
 type CommonError struct {
 	Errors map[string]interface{} `json:"errors"`
 }
 
 // To handle the error returned by c.Bind in gin framework
 // https://github.com/go-playground/validator/blob/v9/_examples/translations/main.go
+//
+// Deprecated: use ValidationError.
 func NewValidatorError(err error) CommonError {
 	res := CommonError{}
 	res.Errors = make(map[string]interface{})
@@ -57,9 +104,9 @@ func NewValidatorError(err error) CommonError {
 		// can translate each error one at a time.
 		//fmt.Println("gg",v.NameNamespace)
 		if v.Param() != "" {
-			res.Errors[v.Field()] = fmt.Sprintf("{%v: %v}", v.Tag(), v.Param())
+			res.Errors[v.StructField()] = fmt.Sprintf("{%v: %v}", v.Tag(), v.Param())
 		} else {
-			res.Errors[v.Field()] = fmt.Sprintf("{key: %v}", v.Tag())
+			res.Errors[v.StructField()] = fmt.Sprintf("{key: %v}", v.Tag())
 		}
 
 	}
@@ -67,6 +114,8 @@ func NewValidatorError(err error) CommonError {
 }
 
 // Warp the error info in a object
+//
+// Deprecated: use NewAPIError.
 func NewError(key string, err error) CommonError {
 	res := CommonError{}
 	res.Errors = make(map[string]interface{})
@@ -81,3 +130,18 @@ func Bind(c *gin.Context, obj interface{}) error {
 	b := binding.Default(c.Request.Method, c.ContentType())
 	return c.ShouldBindWith(obj, b)
 }
+
+// Whether the request matches one of routes, written like gin matched it, "POST /api/articles/", or a bare
+// method like "PUT" for every route of the method. Every request matches when no route is given.
+func MatchRoute(c *gin.Context, routes ...string) bool {
+	if len(routes) == 0 {
+		return true
+	}
+	for _, route := range routes {
+		method, path, hasPath := strings.Cut(route, " ")
+		if method == c.Request.Method && (!hasPath || path == c.FullPath()) {
+			return true
+		}
+	}
+	return false
+}
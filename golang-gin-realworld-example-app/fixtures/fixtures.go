@@ -0,0 +1,187 @@
+/*
+The fixtures module describes data to load into the database: users, follows, tags, articles, favorites and
+comments, the relations being written by reference instead of by ID.
+
+	users:
+	  - username: jake
+	    email: jake@jake.jake
+	    password: password123
+	articles:
+	  - ref: dragon
+	    author: jake
+	    title: How to train your dragon
+	    tags: [dragons, training]
+	comments:
+	  - author: jake
+	    article: dragon
+	    body: It takes a Jacobian
+
+A user is referred to by its ref, or by its username when it has none. An article by its ref, or by its title.
+Files are YAML or JSON, which is read as YAML.
+
+This module only knows the format. The users and articles modules load a Fixture through their validators and
+stores with their LoadFixture functions, so passwords are hashed and slugs made like for the API. The `seed`
+command loads demo.yaml, bundled here, and the tests build the data they need the same way.
+*/
+package fixtures
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Fixture struct {
+	Users     []User     `yaml:"users"`
+	Follows   []Follow   `yaml:"follows"`
+	Tags      []string   `yaml:"tags"`
+	Articles  []Article  `yaml:"articles"`
+	Favorites []Favorite `yaml:"favorites"`
+	Comments  []Comment  `yaml:"comments"`
+}
+
+type User struct {
+	Ref      string `yaml:"ref"`
+	Username string `yaml:"username"`
+	Email    string `yaml:"email"`
+	Password string `yaml:"password"`
+	Bio      string `yaml:"bio"`
+	Image    string `yaml:"image"`
+}
+
+// The name the other entries use for the user.
+func (u User) Key() string {
+	if u.Ref != "" {
+		return u.Ref
+	}
+	return u.Username
+}
+
+// Follower follows Followed, both are user references.
+type Follow struct {
+	Follower string `yaml:"follower"`
+	Followed string `yaml:"followed"`
+}
+
+// Tags are names, the missing ones are created.
+type Article struct {
+	Ref         string   `yaml:"ref"`
+	Author      string   `yaml:"author"`
+	Title       string   `yaml:"title"`
+	Description string   `yaml:"description"`
+	Body        string   `yaml:"body"`
+	Tags        []string `yaml:"tags"`
+}
+
+// The name the other entries use for the article.
+func (a Article) Key() string {
+	if a.Ref != "" {
+		return a.Ref
+	}
+	return a.Title
+}
+
+type Favorite struct {
+	User    string `yaml:"user"`
+	Article string `yaml:"article"`
+}
+
+type Comment struct {
+	Author  string `yaml:"author"`
+	Article string `yaml:"article"`
+	Body    string `yaml:"body"`
+}
+
+//go:embed demo.yaml
+var demo []byte
+
+// The demo data loaded by the `seed` command.
+func Demo() (*Fixture, error) {
+	return Parse(bytes.NewReader(demo))
+}
+
+// Read the fixture file at path, a .yaml, .yml or .json file.
+func ReadFile(path string) (*Fixture, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return nil, fmt.Errorf("fixture %s: unsupported format, use .yaml, .yml or .json", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("fixture: %w", err)
+	}
+	defer file.Close()
+	f, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("fixture %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Decode a YAML or JSON fixture and check its references. Unknown keys are rejected like in the config files.
+func Parse(r io.Reader) (*Fixture, error) {
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	var f Fixture
+	if err := decoder.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Check that every reference names an entry, and that no two entries share a name.
+// The fields themselves are checked by the validators when loading.
+func (f *Fixture) Validate() error {
+	var errs []error
+	users := map[string]bool{}
+	for i, user := range f.Users {
+		if user.Key() == "" {
+			errs = append(errs, fmt.Errorf("users[%d]: a ref or a username is required", i))
+		} else if users[user.Key()] {
+			errs = append(errs, fmt.Errorf("users[%d]: %q is already used", i, user.Key()))
+		}
+		users[user.Key()] = true
+	}
+	articles := map[string]bool{}
+	for i, article := range f.Articles {
+		if article.Key() == "" {
+			errs = append(errs, fmt.Errorf("articles[%d]: a ref or a title is required", i))
+		} else if articles[article.Key()] {
+			errs = append(errs, fmt.Errorf("articles[%d]: %q is already used", i, article.Key()))
+		}
+		articles[article.Key()] = true
+	}
+
+	ref := func(entry, field string, known map[string]bool, name string) {
+		if !known[name] {
+			errs = append(errs, fmt.Errorf("%s: unknown %s %q", entry, field, name))
+		}
+	}
+	for i, follow := range f.Follows {
+		ref(fmt.Sprintf("follows[%d]", i), "follower", users, follow.Follower)
+		ref(fmt.Sprintf("follows[%d]", i), "followed", users, follow.Followed)
+	}
+	for i, article := range f.Articles {
+		ref(fmt.Sprintf("articles[%d]", i), "author", users, article.Author)
+	}
+	for i, favorite := range f.Favorites {
+		ref(fmt.Sprintf("favorites[%d]", i), "user", users, favorite.User)
+		ref(fmt.Sprintf("favorites[%d]", i), "article", articles, favorite.Article)
+	}
+	for i, comment := range f.Comments {
+		ref(fmt.Sprintf("comments[%d]", i), "author", users, comment.Author)
+		ref(fmt.Sprintf("comments[%d]", i), "article", articles, comment.Article)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,95 @@
+package fixtures
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	asserts := assert.New(t)
+
+	yamlFixture, err := Parse(strings.NewReader(`
+users:
+  - username: jake
+    email: jake@jake.jake
+  - ref: a
+    username: anna
+follows:
+  - follower: a
+    followed: jake
+articles:
+  - author: jake
+    title: Dragons
+    tags: [dragons]
+favorites:
+  - user: a
+    article: Dragons
+`))
+	asserts.NoError(err)
+	jsonFixture, err := Parse(strings.NewReader(`{
+		"users": [{"username": "jake", "email": "jake@jake.jake"}, {"ref": "a", "username": "anna"}],
+		"follows": [{"follower": "a", "followed": "jake"}],
+		"articles": [{"author": "jake", "title": "Dragons", "tags": ["dragons"]}],
+		"favorites": [{"user": "a", "article": "Dragons"}]
+	}`))
+	asserts.NoError(err)
+	asserts.Equal(yamlFixture, jsonFixture, "JSON should read like YAML")
+	asserts.Equal("a", jsonFixture.Users[1].Key())
+	asserts.Equal("jake", jsonFixture.Users[0].Key(), "the username should be the default ref")
+	asserts.Equal("Dragons", jsonFixture.Articles[0].Key(), "the title should be the default ref")
+
+	empty, err := Parse(strings.NewReader(""))
+	asserts.NoError(err)
+	asserts.Equal(&Fixture{}, empty)
+
+	_, err = Parse(strings.NewReader("users:\n  - username: jake\n    password_hash: x\n"))
+	asserts.ErrorContains(err, "password_hash", "unknown keys should be rejected")
+}
+
+func TestValidateReferences(t *testing.T) {
+	asserts := assert.New(t)
+
+	f := &Fixture{
+		Users:     []User{{Username: "jake"}, {Username: "jake"}, {}},
+		Follows:   []Follow{{Follower: "jake", Followed: "anna"}},
+		Articles:  []Article{{Author: "bob", Title: "Dragons"}},
+		Favorites: []Favorite{{User: "jake", Article: "Cats"}},
+		Comments:  []Comment{{Author: "jake", Article: "Dragons"}},
+	}
+	err := f.Validate()
+	asserts.ErrorContains(err, `users[1]: "jake" is already used`)
+	asserts.ErrorContains(err, "users[2]: a ref or a username is required")
+	asserts.ErrorContains(err, `follows[0]: unknown followed "anna"`)
+	asserts.ErrorContains(err, `articles[0]: unknown author "bob"`)
+	asserts.ErrorContains(err, `favorites[0]: unknown article "Cats"`)
+	asserts.NotContains(err.Error(), "comments[0]", "a known reference should pass")
+}
+
+func TestReadFile(t *testing.T) {
+	asserts := assert.New(t)
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "fixture.yml")
+	asserts.NoError(os.WriteFile(path, []byte("tags: [go]\n"), 0o600))
+	f, err := ReadFile(path)
+	asserts.NoError(err)
+	asserts.Equal([]string{"go"}, f.Tags)
+
+	_, err = ReadFile(filepath.Join(dir, "fixture.toml"))
+	asserts.ErrorContains(err, "unsupported format")
+	_, err = ReadFile(filepath.Join(dir, "missing.json"))
+	asserts.ErrorIs(err, os.ErrNotExist)
+}
+
+func TestDemo(t *testing.T) {
+	asserts := assert.New(t)
+
+	f, err := Demo()
+	asserts.NoError(err, "the bundled demo should be valid")
+	asserts.NotEmpty(f.Users)
+	asserts.NotEmpty(f.Articles)
+}
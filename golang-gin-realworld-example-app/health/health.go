@@ -0,0 +1,133 @@
+/*
+The health module answers the probes of the orchestrator.
+
+/healthz tells whether the process is alive and only fails when it cannot answer at all, restarting the
+instance would not fix a broken database. /readyz runs every registered check and answers 503 when one fails,
+so the traffic goes to the other instances until this one recovers:
+
+	{"status": "fail", "checks": {
+	  "database": {"status": "ok", "duration_ms": 0.4},
+	  "migrations": {"status": "fail", "error": "1 pending migrations: 0002 user_disabled_at", "duration_ms": 0.9}}}
+
+main registers the database and migration checks, the caches and queues add theirs with Add.
+*/
+package health
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long a check may take before it counts as failed, when New is given no timeout.
+const DefaultTimeout = 2 * time.Second
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// A dependency check, it should give up when ctx ends.
+type Check func(ctx context.Context) error
+
+type Result struct {
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_ms"`
+}
+
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type Checker struct {
+	timeout time.Duration
+	names   []string
+	checks  map[string]Check
+}
+
+func New(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{timeout: timeout, checks: map[string]Check{}}
+}
+
+// Register check under name, adding a name twice replaces its check. Call it before serving.
+func (h *Checker) Add(name string, check Check) {
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// Run every check at once, each with the timeout of the Checker.
+func (h *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(h.names))}
+	results := make([]Result, len(h.names))
+	var wg sync.WaitGroup
+	for i, name := range h.names {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+			start := time.Now()
+			// A check ignoring ctx is left behind once the timeout passed, and counts as failed.
+			done := make(chan error, 1)
+			go func() { done <- check(ctx) }()
+			var err error
+			select {
+			case err = <-done:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			results[i] = Result{Status: StatusOK, Duration: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				results[i].Status = StatusFail
+				results[i].Error = err.Error()
+			}
+		}(i, h.checks[name])
+	}
+	wg.Wait()
+	for i, name := range h.names {
+		report.Checks[name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func (h *Checker) Register(router gin.IRoutes) {
+	router.GET("/healthz", h.Live)
+	router.GET("/readyz", h.Ready)
+}
+
+// Answer 200 as long as the process serves requests.
+func (h *Checker) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": StatusOK})
+}
+
+// Answer the Report of every check, with 503 when one failed.
+func (h *Checker) Ready(c *gin.Context) {
+	report := h.Run(c.Request.Context())
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, report)
+}
+
+// Check that a connection of the pool answers.
+func Ping(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
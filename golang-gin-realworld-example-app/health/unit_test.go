@@ -0,0 +1,77 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestRun(t *testing.T) {
+	asserts := assert.New(t)
+	checker := New(50 * time.Millisecond)
+	checker.Add("ok", func(ctx context.Context) error { return nil })
+	checker.Add("broken", func(ctx context.Context) error { return errors.New("connection refused") })
+	checker.Add("stuck", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	start := time.Now()
+	report := checker.Run(context.Background())
+	asserts.Less(time.Since(start), 500*time.Millisecond, "a stuck check should not hold the probe")
+	asserts.Equal(StatusFail, report.Status)
+	asserts.Equal(StatusOK, report.Checks["ok"].Status)
+	asserts.Equal("connection refused", report.Checks["broken"].Error)
+	asserts.Equal(context.DeadlineExceeded.Error(), report.Checks["stuck"].Error)
+
+	checker.Add("broken", func(ctx context.Context) error { return nil })
+	checker.Add("stuck", func(ctx context.Context) error { return nil })
+	report = checker.Run(context.Background())
+	asserts.Equal(StatusOK, report.Status, "adding a name again should replace its check")
+	asserts.Len(report.Checks, 3)
+}
+
+func TestEndpoints(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker := New(0)
+	checker.Add("database", Ping(db))
+	r := gin.New()
+	checker.Register(r)
+
+	get := func(path string) (int, Report) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var report Report
+		asserts.NoError(json.Unmarshal(w.Body.Bytes(), &report))
+		return w.Code, report
+	}
+
+	code, report := get("/readyz")
+	asserts.Equal(http.StatusOK, code)
+	asserts.Equal(StatusOK, report.Checks["database"].Status)
+
+	db.Close()
+	code, report = get("/readyz")
+	asserts.Equal(http.StatusServiceUnavailable, code)
+	asserts.Equal(StatusFail, report.Checks["database"].Status)
+	asserts.Contains(report.Checks["database"].Error, "closed")
+
+	code, report = get("/healthz")
+	asserts.Equal(http.StatusOK, code, "liveness should not depend on the database")
+	asserts.Equal(StatusOK, report.Status)
+}
@@ -1,94 +1,106 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-
-	"github.com/gin-gonic/gin"
-	"github.com/gin-contrib/cors"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"time"
 
 	"github.com/jinzhu/gorm"
-	"realworld-backend/articles"
+
 	"realworld-backend/common"
+	"realworld-backend/config"
+	"realworld-backend/mail"
+	"realworld-backend/ratelimit"
 	"realworld-backend/users"
 )
 
-func Migrate(db *gorm.DB) {
-	users.AutoMigrate()
-	db.AutoMigrate(&articles.ArticleModel{})
-	db.AutoMigrate(&articles.TagModel{})
-	db.AutoMigrate(&articles.FavoriteModel{})
-	db.AutoMigrate(&articles.ArticleUserModel{})
-	db.AutoMigrate(&articles.CommentModel{})
-	// Add indexes for performance optimization
-	db.Model(&articles.ArticleModel{}).AddIndex("idx_article_created_at", "created_at")
-	db.Model(&articles.ArticleModel{}).AddIndex("idx_article_slug", "slug")
-	db.Model(&articles.CommentModel{}).AddIndex("idx_comment_article_id", "article_id")
-	fmt.Println("Performance indexes created successfully")
-
+const usage = `usage: realworld-server [command] [arguments]
+
+commands:
+  serve                                  start the API server, the default
+  migrate up | down [steps] | status     apply or roll back the schema migrations
+  seed [FILE]                            load a fixture file, the demo users and articles by default
+  user create -username NAME -email EMAIL [-password PASSWORD]
+  user disable -email EMAIL
+  user reset-password -email EMAIL [-password PASSWORD]
+  token issue -email EMAIL               print a JWT for the user
+`
+
+// What every command gets: the settings, the opened database and where to print.
+type cli struct {
+	cfg *config.Config
+	db  *gorm.DB
+	out io.Writer
+	// The buckets of the rate limits, newRouter keeps them in memory when nil.
+	limits ratelimit.Store
+	// Sends the emails, newRouter only logs them when nil.
+	mailer mail.Mailer
+	// Runs the work of the handlers after their request, on goroutines of their own when nil.
+	background func(fn func())
 }
 
-func main() {
-
-	db := common.Init()
-	Migrate(db)
-	defer db.Close()
-
-	r := gin.Default()
-
-	// Configure CORS
-	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:4100"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		AllowCredentials: true,
-	}))
-
-	v1 := r.Group("/api")
-	users.UsersRegister(v1.Group("/users"))
-	v1.Use(users.AuthMiddleware(false))
-	articles.ArticlesAnonymousRegister(v1.Group("/articles"))
-	articles.TagsAnonymousRegister(v1.Group("/tags"))
-
-	v1.Use(users.AuthMiddleware(true))
-	users.UserRegister(v1.Group("/user"))
-	users.ProfileRegister(v1.Group("/profiles"))
-
-	articles.ArticlesRegister(v1.Group("/articles"))
+var commands = map[string]func(c *cli, args []string) error{
+	"serve":   runServe,
+	"migrate": runMigrate,
+	"seed":    runSeed,
+	"user":    runUser,
+	"token":   runToken,
+}
 
-	testAuth := r.Group("/api/ping")
+var errUsage = errors.New("see `realworld-server help`")
 
-	testAuth.GET("/", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"message": "pong",
-		})
-	})
+func main() {
 
-	// test 1 to 1
-	tx1 := db.Begin()
-	userA := users.UserModel{
-		Username: "AAAAAAAAAAAAAAAA",
-		Email:    "aaaa@g.cn",
-		Bio:      "hehddeda",
-		Image:    nil,
+	cfg, err := config.Load(os.Getenv(config.FileEnv))
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if err := loadKeys(cfg.JWT); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	common.TokenIssuer, common.TokenAudience = cfg.JWT.Issuer, cfg.JWT.Audience
+	common.AccessTokenTTL = time.Duration(cfg.JWT.AccessTokenTTL)
+	common.TokenAlgorithms = cfg.JWT.Algorithms
+	users.SetPasswordHasher(users.NewPasswordHasher(cfg.Password))
+	if users.Policy, err = users.NewPasswordPolicy(cfg.Password); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	// JSON lines on stderr, stdout is left to the output of the commands.
+	slog.SetDefault(common.NewLogger(os.Stderr, cfg.Log.Level))
+	if users.Policy.Breached != nil {
+		slog.Info("breached passwords loaded", "count", users.Policy.Breached.Count)
 	}
-	tx1.Save(&userA)
-	tx1.Commit()
-	fmt.Println(userA)
 
-	//db.Save(&ArticleUserModel{
-	//    UserModelID:userA.ID,
-	//})
-	//var userAA ArticleUserModel
-	//db.Where(&ArticleUserModel{
-	//    UserModelID:userA.ID,
-	//}).First(&userAA)
-	//fmt.Println(userAA)
+	args := os.Args[1:]
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	if err := run(cfg, args, os.Stdout); err != nil {
+		slog.Error("command failed", "command", args[0], "error", err)
+		os.Exit(1)
+	}
+}
 
-	r.Run() // listen and serve on 0.0.0.0:8080
-	// Add indexes for performance optimization
-	db.Model(&articles.ArticleModel{}).AddIndex("idx_article_created_at", "created_at")
-	db.Model(&articles.ArticleModel{}).AddIndex("idx_article_slug", "slug")
-	db.Model(&articles.CommentModel{}).AddIndex("idx_comment_article_id", "article_id")
-	fmt.Println("Performance indexes created successfully")
+// Run the command named by args[0] with the rest of args.
+func run(cfg *config.Config, args []string, out io.Writer) error {
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(out, usage)
+		return nil
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command, %w", errUsage)
+	}
 
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	defer db.Close()
+	return command(&cli{cfg: cfg, db: db, out: out}, args[1:])
 }
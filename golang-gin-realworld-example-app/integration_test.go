@@ -24,12 +24,13 @@ func setupRouter() *gin.Engine {
 	db.Exec("DELETE FROM follow_models")
 	db.Exec("DELETE FROM user_models")
 	
-	users.AutoMigrate()
+	users.AutoMigrate(db)
+	userHandler := users.NewHandler(users.NewGormUserStore(db), users.NewGormTokenStore(db))
 	
 	v1 := router.Group("/api")
-	users.UsersRegister(v1.Group("/users"))
-	users.UserRegister(v1.Group("/user"))
-	users.ProfileRegister(v1.Group("/profiles"))
+	userHandler.UsersRegister(v1.Group("/users"))
+	userHandler.UserRegister(v1.Group("/user"))
+	userHandler.ProfileRegister(v1.Group("/profiles"))
 	
 	return router
 }
@@ -100,7 +101,8 @@ func TestIntegration_UserRegistration_DuplicateEmail(t *testing.T) {
 	}
 	w := makeRequest("POST", "/api/users/", requestBody2, router)
 	
-	assert.Equal(422, w.Code)
+	assert.Equal(409, w.Code)
+	assert.Contains(w.Body.String(), `"code":"duplicate"`)
 }
 
 // Test 3: User Login Success
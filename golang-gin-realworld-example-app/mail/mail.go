@@ -0,0 +1,148 @@
+/*
+The mail module sends the emails of the API, like the password reset and the email verification links.
+
+The handlers only know the Mailer interface. SMTPMailer hands the messages to a mail server, FileMailer writes
+them to a directory as .eml files and LogMailer only logs them, which is enough to copy a link in development.
+MemoryMailer keeps them for the tests. Point mail.smtp_url at a local sink like Mailpit to see the real thing:
+
+	docker compose -f docker-compose.test.yml up -d mailpit
+	REALWORLD_MAIL_TRANSPORT=smtp REALWORLD_MAIL_SMTP_URL=smtp://localhost:51025 go run .
+
+mail.go: the messages and the mailers
+
+smtp.go: the SMTP client
+*/
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"realworld-backend/common"
+	"realworld-backend/config"
+)
+
+// A plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sends the messages, Send returns once the message is handed over, not delivered.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Build the mailer of cfg.Transport, validated with the configuration.
+func NewMailer(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Transport {
+	case "smtp":
+		mailer, err := NewSMTPMailer(cfg.SMTPURL, cfg.From)
+		if err != nil {
+			return nil, fmt.Errorf("mail.smtp_url: %w", err)
+		}
+		return mailer, nil
+	case "file":
+		return &FileMailer{Dir: cfg.Dir, From: cfg.From}, nil
+	default:
+		return LogMailer{}, nil
+	}
+}
+
+// Logs the messages at info level instead of sending them, their links included: for development only.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	common.Logger(ctx).Info("mail not sent, logged", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// Writes every message to Dir as an .eml file, which mail clients open.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), common.RandString(6))
+	return os.WriteFile(filepath.Join(m.Dir, name), encode(m.From, msg, time.Now()), 0o600)
+}
+
+// Keeps the messages in memory, for the tests.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// The messages sent so far, the oldest first.
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// The message in RFC 5322 format, its body quoted-printable so that long links and accents get through.
+func encode(from string, msg Message, date time.Time) []byte {
+	var b bytes.Buffer
+	header := func(key, value string) {
+		// A CR or LF in a value would start a new header.
+		value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+		fmt.Fprintf(&b, "%s: %s\r\n", textproto.CanonicalMIMEHeaderKey(key), value)
+	}
+	header("From", from)
+	header("To", msg.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", common.RandToken(16), domain(from)))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	b.WriteString("\r\n")
+	// The writer ends the lines with CRLF.
+	w := quotedprintable.NewWriter(&b)
+	w.Write([]byte(msg.Body))
+	w.Close()
+	return b.Bytes()
+}
+
+// The domain of an address like "RealWorld <no-reply@example.com>".
+func domain(address string) string {
+	_, host, ok := strings.Cut(strings.TrimRight(address, "> "), "@")
+	if !ok {
+		return "localhost"
+	}
+	return host
+}
+
+// Log the failures of Send instead of answering them, the client should not learn whether a mail went out.
+func SendLogged(ctx context.Context, mailer Mailer, msg Message) {
+	if err := mailer.Send(ctx, msg); err != nil {
+		common.Logger(ctx).Error("mail not sent", "subject", msg.Subject, "error", err.Error())
+	}
+}
@@ -0,0 +1,204 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"net"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"realworld-backend/config"
+)
+
+var testMessage = Message{
+	To:      "jake@jake.jake",
+	Subject: "Réinitialisez votre mot de passe",
+	Body:    "Hi jake,\n\nhttps://conduit.example/reset-password?token=" + strings.Repeat("a", 90) + "\n",
+}
+
+// Parse an encoded message back and decode its body.
+func readMessage(t *testing.T, raw []byte) (*netmail.Message, string) {
+	msg, err := netmail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg, string(body)
+}
+
+func TestEncode(t *testing.T) {
+	asserts := assert.New(t)
+	raw := encode("RealWorld <no-reply@conduit.example>", testMessage, time.Now())
+	msg, body := readMessage(t, raw)
+
+	asserts.Equal("RealWorld <no-reply@conduit.example>", msg.Header.Get("From"))
+	asserts.Equal("jake@jake.jake", msg.Header.Get("To"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	asserts.NoError(err)
+	asserts.Equal(testMessage.Subject, subject)
+	asserts.True(strings.HasSuffix(msg.Header.Get("Message-ID"), "@conduit.example>"))
+	asserts.Contains(body, "=\r\n", "the long link should be wrapped")
+	asserts.NotContains(string(raw), "\n\n", "the lines should end with CRLF")
+
+	raw = encode("RealWorld <no-reply@conduit.example>", Message{To: "jake@jake.jake\r\nBcc: eve@evil.example"}, time.Now())
+	msg, _ = readMessage(t, raw)
+	asserts.Empty(msg.Header.Get("Bcc"), "a line break should not inject a header")
+}
+
+func TestFileMailer(t *testing.T) {
+	asserts := assert.New(t)
+	dir := filepath.Join(t.TempDir(), "mail")
+	mailer, err := NewMailer(config.MailConfig{Transport: "file", Dir: dir, From: "no-reply@localhost"})
+	asserts.NoError(err)
+
+	asserts.NoError(mailer.Send(context.Background(), testMessage))
+	asserts.NoError(mailer.Send(context.Background(), testMessage))
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	asserts.NoError(err)
+	if !asserts.Len(files, 2, "every message should get its own file") {
+		return
+	}
+	raw, err := os.ReadFile(files[0])
+	asserts.NoError(err)
+	msg, body := readMessage(t, raw)
+	asserts.Equal("jake@jake.jake", msg.Header.Get("To"))
+	asserts.Contains(body, "Hi jake,")
+}
+
+func TestMemoryMailer(t *testing.T) {
+	asserts := assert.New(t)
+	mailer := &MemoryMailer{}
+	asserts.NoError(mailer.Send(context.Background(), testMessage))
+	asserts.Equal([]Message{testMessage}, mailer.Messages())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	asserts.Error(mailer.Send(ctx, testMessage))
+
+	logged, err := NewMailer(config.MailConfig{Transport: "log"})
+	asserts.NoError(err)
+	asserts.IsType(LogMailer{}, logged)
+}
+
+// A mail server speaking just enough SMTP, which hands every message it accepts to received.
+type smtpSink struct {
+	listener net.Listener
+	received chan string
+	// The PLAIN credentials of the last AUTH, decoded.
+	auth chan string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	sink := &smtpSink{listener: listener, received: make(chan string, 10), auth: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	return sink
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 sink ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.Fields(line + " x")[0])
+		switch command {
+		case "EHLO":
+			reply("250-sink")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			fields := strings.Fields(line)
+			decoded, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			s.auth <- string(decoded)
+			reply("235 ok")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			reply("250 ok")
+		case "DATA":
+			reply("354 go on")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.received <- data.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown")
+		}
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	asserts := assert.New(t)
+	sink := newSMTPSink(t)
+	addr := sink.listener.Addr().String()
+
+	mailer, err := NewMailer(config.MailConfig{Transport: "smtp", SMTPURL: "smtp://jake:secret@" + addr, From: "RealWorld <no-reply@localhost>"})
+	if !asserts.NoError(err) {
+		return
+	}
+	asserts.NoError(mailer.Send(context.Background(), testMessage))
+	select {
+	case auth := <-sink.auth:
+		asserts.Equal("\x00jake\x00secret", auth)
+	default:
+		asserts.Fail("the credentials of the URL should be sent")
+	}
+	select {
+	case data := <-sink.received:
+		msg, body := readMessage(t, []byte(data))
+		asserts.Equal("jake@jake.jake", msg.Header.Get("To"))
+		asserts.Contains(body, "Hi jake,")
+	case <-time.After(5 * time.Second):
+		asserts.Fail("the sink should receive the message")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	asserts.Error(mailer.Send(ctx, testMessage), "a cancelled context should not send")
+
+	_, err = NewSMTPMailer("http://"+addr, "no-reply@localhost")
+	asserts.Error(err)
+	_, err = NewSMTPMailer("smtp://"+addr, "not an address")
+	asserts.Error(err)
+	m, err := NewSMTPMailer("smtps://smtp.example.com", "no-reply@localhost")
+	asserts.NoError(err)
+	asserts.Equal("smtp.example.com:465", m.addr)
+}
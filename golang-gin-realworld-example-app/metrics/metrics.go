@@ -0,0 +1,133 @@
+/*
+The metrics module collects what the server does, served at /metrics in the Prometheus text format.
+
+Everything is registered on Registry, not on the global registry of the Prometheus client:
+
+	http_requests_total, http_request_duration_seconds     per method and route, see Middleware
+	db_queries_total, db_query_duration_seconds            the statements run through common.WithContext
+	go_sql_*                                               the pool of the database given to RegisterDB
+	realworld_registrations_total, realworld_logins_total,
+	realworld_articles_created_total, realworld_favorites_total
+	go_*, process_*                                        the Go runtime and the process
+
+The domain counters are incremented by the users and articles handlers once the change is saved.
+*/
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The registry served by Handler.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by method, route and status.",
+	}, []string{"method", "route", "status"})
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time spent handling the HTTP requests, by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	DBQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_queries_total",
+		Help: "SQL statements run, by operation (exec, query, prepare) and result (ok, error).",
+	}, []string{"operation", "result"})
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Time spent running the SQL statements, by operation.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+
+	Registrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "realworld_registrations_total",
+		Help: "Users registered through the API.",
+	})
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "realworld_logins_total",
+		Help: "Login attempts, by result (success, failure, disabled, throttled).",
+	}, []string{"result"})
+	Refreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "realworld_token_refreshes_total",
+		Help: "Refresh token trades, by result (success, invalid, reused), reused ones hint at a stolen token.",
+	}, []string{"result"})
+	PasswordResets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "realworld_password_resets_total",
+		Help: "Password reset steps, by result (requested, completed, invalid).",
+	}, []string{"result"})
+	EmailVerifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "realworld_email_verifications_total",
+		Help: "Email verification steps, by result (sent, confirmed, invalid).",
+	}, []string{"result"})
+	ArticlesCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "realworld_articles_created_total",
+		Help: "Articles created through the API.",
+	})
+	Favorites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "realworld_favorites_total",
+		Help: "Articles favorited and unfavorited, by action (favorite, unfavorite).",
+	}, []string{"action"})
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "realworld_rate_limited_total",
+		Help: "Requests refused with a 429, by limit (login, registration, write, password_reset).",
+	}, []string{"limit"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests, HTTPDuration,
+		DBQueries, DBQueryDuration,
+		Registrations, Logins, Refreshes, PasswordResets, EmailVerifications, ArticlesCreated, Favorites, RateLimited,
+	)
+}
+
+// Serve Registry in the Prometheus text format.
+//
+//	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Count and time every request handled after it. The route is the pattern, like /api/articles/:slug,
+// so the slugs and ids do not each make a series. The requests matching no route share "unmatched".
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		HTTPDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Record a statement of operation started at start, called by common.WithContext for every statement.
+func ObserveQuery(operation string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	DBQueries.WithLabelValues(operation, result).Inc()
+	DBQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Export the pool statistics of db as go_sql_* with the label db_name=name.
+// A database can only be registered once under a name, the server registers its pool when it starts.
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMiddleware(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/articles/:slug", func(c *gin.Context) {
+		c.Status(http.StatusNotFound)
+	})
+
+	before := testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "/articles/:slug", "404"))
+	unmatched := testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "unmatched", "404"))
+	for _, path := range []string{"/articles/a", "/articles/b", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	asserts.Equal(before+2, testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "/articles/:slug", "404")),
+		"the requests should be counted by route, not by path")
+	asserts.Equal(unmatched+1, testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "unmatched", "404")))
+}
+
+func TestObserveQuery(t *testing.T) {
+	asserts := assert.New(t)
+
+	ok := testutil.ToFloat64(DBQueries.WithLabelValues("exec", "ok"))
+	failed := testutil.ToFloat64(DBQueries.WithLabelValues("exec", "error"))
+	ObserveQuery("exec", time.Now(), nil)
+	ObserveQuery("exec", time.Now(), errors.New("locked"))
+	asserts.Equal(ok+1, testutil.ToFloat64(DBQueries.WithLabelValues("exec", "ok")))
+	asserts.Equal(failed+1, testutil.ToFloat64(DBQueries.WithLabelValues("exec", "error")))
+}
+
+func TestHandler(t *testing.T) {
+	asserts := assert.New(t)
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	asserts.NoError(RegisterDB(db, "metrics_test"))
+	asserts.Error(RegisterDB(db, "metrics_test"), "a name should only be registered once")
+	Registrations.Inc()
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Header().Get("Content-Type"), "text/plain")
+	body := w.Body.String()
+	asserts.Contains(body, "# TYPE realworld_registrations_total counter")
+	asserts.Contains(body, `go_sql_open_connections{db_name="metrics_test"}`)
+	asserts.Contains(body, "go_goroutines")
+}
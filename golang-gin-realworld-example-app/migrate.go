@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+
+	"realworld-backend/migrations"
+)
+
+const migrateUsage = "usage: migrate up | down [steps] | status"
+
+// Handle `migrate up`, `migrate down [steps]` and `migrate status`.
+func runMigrate(c *cli, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	switch args[0] {
+	case "up":
+		done, err := migrations.Up(c.db)
+		for _, m := range done {
+			fmt.Fprintf(c.out, "applied %04d %s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Fprintln(c.out, "database is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("steps should be a positive number, got %q", args[1])
+			}
+			steps = n
+		}
+		done, err := migrations.Down(c.db, steps)
+		for _, m := range done {
+			fmt.Fprintf(c.out, "rolled back %04d %s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		status, err := migrations.Status(c.db)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range status {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+}
@@ -0,0 +1,119 @@
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// The schema as AutoMigrate used to build it from UserModel, FollowModel, ArticleModel, TagModel, FavoriteModel,
+// ArticleUserModel and CommentModel, plus the performance indexes.
+// AutoMigrate only adds what is missing, so databases created before the migrations existed adopt it as is.
+
+type baselineUser struct {
+	ID           uint    `gorm:"primary_key"`
+	Username     string  `gorm:"column:username"`
+	Email        string  `gorm:"column:email;unique_index"`
+	Bio          string  `gorm:"column:bio;size:1024"`
+	Image        *string `gorm:"column:image"`
+	PasswordHash string  `gorm:"column:password;not null"`
+}
+
+func (baselineUser) TableName() string { return "user_models" }
+
+type baselineFollow struct {
+	gorm.Model
+	FollowingID  uint
+	FollowedByID uint
+}
+
+func (baselineFollow) TableName() string { return "follow_models" }
+
+type baselineArticle struct {
+	gorm.Model
+	Slug        string `gorm:"unique_index"`
+	Title       string
+	Description string `gorm:"size:2048"`
+	Body        string `gorm:"size:2048"`
+	AuthorID    uint
+}
+
+func (baselineArticle) TableName() string { return "article_models" }
+
+type baselineArticleUser struct {
+	gorm.Model
+	UserModelID uint
+}
+
+func (baselineArticleUser) TableName() string { return "article_user_models" }
+
+type baselineFavorite struct {
+	gorm.Model
+	FavoriteID   uint
+	FavoriteByID uint
+}
+
+func (baselineFavorite) TableName() string { return "favorite_models" }
+
+type baselineTag struct {
+	gorm.Model
+	Tag string `gorm:"unique_index"`
+}
+
+func (baselineTag) TableName() string { return "tag_models" }
+
+// The join table gorm creates for the many2many:article_tags relationship.
+type baselineArticleTag struct {
+	ArticleModelID uint `gorm:"primary_key;auto_increment:false"`
+	TagModelID     uint `gorm:"primary_key;auto_increment:false"`
+}
+
+func (baselineArticleTag) TableName() string { return "article_tags" }
+
+type baselineComment struct {
+	gorm.Model
+	ArticleID uint
+	AuthorID  uint
+	Body      string `gorm:"size:2048"`
+}
+
+func (baselineComment) TableName() string { return "comment_models" }
+
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "baseline",
+		Up: func(tx *gorm.DB) error {
+			err := tx.AutoMigrate(
+				&baselineUser{},
+				&baselineFollow{},
+				&baselineArticle{},
+				&baselineArticleUser{},
+				&baselineFavorite{},
+				&baselineTag{},
+				&baselineArticleTag{},
+				&baselineComment{},
+			).Error
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&baselineArticle{}).AddIndex("idx_article_created_at", "created_at").Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&baselineArticle{}).AddIndex("idx_article_slug", "slug").Error; err != nil {
+				return err
+			}
+			return tx.Model(&baselineComment{}).AddIndex("idx_comment_article_id", "article_id").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(
+				&baselineComment{},
+				&baselineArticleTag{},
+				&baselineTag{},
+				&baselineFavorite{},
+				&baselineArticleUser{},
+				&baselineArticle{},
+				&baselineFollow{},
+				&baselineUser{},
+			).Error
+		},
+	})
+}
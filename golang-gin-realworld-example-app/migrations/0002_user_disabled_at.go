@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The users disabled by `user disable` keep their rows, disabled_at tells them apart.
+
+type disabledAtUser struct {
+	DisabledAt *time.Time `gorm:"column:disabled_at"`
+}
+
+func (disabledAtUser) TableName() string { return "user_models" }
+
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "user_disabled_at",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&disabledAtUser{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Model(&disabledAtUser{}).DropColumn("disabled_at").Error
+		},
+	})
+}
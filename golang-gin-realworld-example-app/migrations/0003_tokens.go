@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The refresh tokens, stored as their SHA-256, and the revocation list of the access tokens.
+
+type refreshToken struct {
+	ID              uint      `gorm:"primary_key"`
+	UserID          uint      `gorm:"column:user_id;index"`
+	Family          string    `gorm:"column:family;index"`
+	TokenHash       string    `gorm:"column:token_hash;unique_index"`
+	AccessTokenID   string    `gorm:"column:access_token_id"`
+	AccessExpiresAt time.Time `gorm:"column:access_expires_at"`
+	CreatedAt       time.Time
+	ExpiresAt       time.Time  `gorm:"column:expires_at;index"`
+	UsedAt          *time.Time `gorm:"column:used_at"`
+	RevokedAt       *time.Time `gorm:"column:revoked_at"`
+}
+
+func (refreshToken) TableName() string { return "refresh_tokens" }
+
+type revokedToken struct {
+	TokenID   string    `gorm:"column:jti;primary_key"`
+	ExpiresAt time.Time `gorm:"column:expires_at;index"`
+}
+
+func (revokedToken) TableName() string { return "revoked_tokens" }
+
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "tokens",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&refreshToken{}, &revokedToken{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&refreshToken{}, &revokedToken{}).Error
+		},
+	})
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The password reset links, stored as the SHA-256 of their token.
+
+type passwordReset struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"column:user_id;index"`
+	TokenHash string `gorm:"column:token_hash;unique_index"`
+	CreatedAt time.Time
+	ExpiresAt time.Time  `gorm:"column:expires_at;index"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+}
+
+func (passwordReset) TableName() string { return "password_resets" }
+
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "password_resets",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&passwordReset{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&passwordReset{}).Error
+		},
+	})
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The email the users confirmed owning, and the verification links sent to them. The users registered before
+// start unverified.
+
+type verifiedEmailUser struct {
+	VerifiedEmail string `gorm:"column:verified_email"`
+}
+
+func (verifiedEmailUser) TableName() string { return "user_models" }
+
+type emailVerification struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"column:user_id;index"`
+	Email     string `gorm:"column:email"`
+	TokenHash string `gorm:"column:token_hash;unique_index"`
+	CreatedAt time.Time
+	ExpiresAt time.Time  `gorm:"column:expires_at;index"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+}
+
+func (emailVerification) TableName() string { return "email_verifications" }
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "email_verification",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&verifiedEmailUser{}, &emailVerification{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.DropTableIfExists(&emailVerification{}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&verifiedEmailUser{}).DropColumn("verified_email").Error
+		},
+	})
+}
@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The logins, for the throttling of the failed ones and the history of the users.
+
+type loginAttempt struct {
+	ID        uint      `gorm:"primary_key"`
+	UserID    uint      `gorm:"column:user_id;index"`
+	Email     string    `gorm:"column:email;index"`
+	IP        string    `gorm:"column:ip;index"`
+	UserAgent string    `gorm:"column:user_agent"`
+	Outcome   string    `gorm:"column:outcome"`
+	CreatedAt time.Time `gorm:"column:created_at;index"`
+}
+
+func (loginAttempt) TableName() string { return "login_attempts" }
+
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "login_attempts",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&loginAttempt{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&loginAttempt{}).Error
+		},
+	})
+}
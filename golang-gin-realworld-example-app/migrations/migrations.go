@@ -0,0 +1,219 @@
+/*
+The migrations module evolves the database schema through ordered, versioned steps.
+
+Each file NNNN_name.go registers one Migration with an Up and a Down function. The versions applied to a
+database are recorded in the schema_migrations table, so Up only runs the pending ones and Down rolls back
+the most recent ones.
+
+A migration must not use the models of the users and articles modules, which keep changing: it freezes
+the shape of the tables it touches in its own unexported structs.
+*/
+package migrations
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// One row per applied migration.
+type SchemaMigration struct {
+	Version   int64 `gorm:"primary_key;auto_increment:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// The state of a known migration in a database, AppliedAt is nil while it is pending.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+var registry []Migration
+
+// Called from the init function of every migration file.
+func register(m Migration) {
+	for _, other := range registry {
+		if other.Version == m.Version {
+			panic(fmt.Sprintf("migrations: version %d registered twice (%s and %s)", m.Version, other.Name, m.Name))
+		}
+	}
+	registry = append(registry, m)
+	sort.Slice(registry, func(i, j int) bool { return registry[i].Version < registry[j].Version })
+}
+
+// All the known migrations, oldest first.
+func All() []Migration {
+	return append([]Migration(nil), registry...)
+}
+
+func ensureTable(db *gorm.DB) error {
+	return db.AutoMigrate(&SchemaMigration{}).Error
+}
+
+func applied(db *gorm.DB) (map[int64]SchemaMigration, error) {
+	if err := ensureTable(db); err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	res := make(map[int64]SchemaMigration, len(rows))
+	for _, row := range rows {
+		res[row.Version] = row
+	}
+	return res, nil
+}
+
+// List every known migration with the time it was applied.
+func Status(db *gorm.DB) ([]MigrationStatus, error) {
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	var res []MigrationStatus
+	for _, m := range registry {
+		status := MigrationStatus{Migration: m}
+		if row, ok := done[m.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+		res = append(res, status)
+	}
+	return res, nil
+}
+
+// The known migrations that are not applied yet, oldest first.
+func Pending(db *gorm.DB) ([]Migration, error) {
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	var res []Migration
+	for _, m := range registry {
+		if _, ok := done[m.Version]; !ok {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+// An error naming the pending migrations, nil when the schema is up to date. Unlike Pending it only reads,
+// a database without schema_migrations has them all pending, so the readiness probe can call it.
+func Check(db *gorm.DB) error {
+	done := map[int64]bool{}
+	if db.HasTable(&SchemaMigration{}) {
+		var rows []SchemaMigration
+		if err := db.Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			done[row.Version] = true
+		}
+	}
+	var pending []string
+	for _, m := range registry {
+		if !done[m.Version] {
+			pending = append(pending, fmt.Sprintf("%04d %s", m.Version, m.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migrations: %s", len(pending), strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// Apply the pending migrations in order and return the ones that ran.
+// Each one runs in its own transaction together with its schema_migrations row,
+// MySQL commits DDL statements implicitly though, so a failure there can leave a step half applied.
+func Up(db *gorm.DB) ([]Migration, error) {
+	pending, err := Pending(db)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range pending {
+		err := run(db, func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d %s up: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// Roll back the steps most recently applied migrations, newest first, and return the ones that ran.
+func Down(db *gorm.DB, steps int) ([]Migration, error) {
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	var versions []int64
+	for version := range done {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	if steps < len(versions) {
+		versions = versions[:steps]
+	}
+
+	var res []Migration
+	for _, version := range versions {
+		m, ok := find(version)
+		if !ok {
+			return res, fmt.Errorf("migration %d is applied but unknown to this binary", version)
+		}
+		err := run(db, func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{Version: m.Version}).Error
+		})
+		if err != nil {
+			return res, fmt.Errorf("migration %d %s down: %w", m.Version, m.Name, err)
+		}
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+func find(version int64) (Migration, bool) {
+	for _, m := range registry {
+		if m.Version == version {
+			return m, true
+		}
+	}
+	return Migration{}, false
+}
+
+func run(db *gorm.DB, step func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := step(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
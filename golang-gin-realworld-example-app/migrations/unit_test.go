@@ -0,0 +1,136 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+// The migrations drop tables, so by default they get a SQLite file of their own instead of the shared test database.
+func openTestDB(t *testing.T) *gorm.DB {
+	if os.Getenv(common.TestDSNEnv) != "" {
+		db := common.TestDBInit()
+		t.Cleanup(func() { common.TestDBFree(db) })
+		return db
+	}
+	db, err := common.Open(filepath.Join(t.TempDir(), "migrations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestUpDownStatus(t *testing.T) {
+	asserts := assert.New(t)
+	db := openTestDB(t)
+
+	asserts.ErrorContains(Check(db), "0001 baseline", "an empty database should have every migration pending")
+	asserts.False(db.HasTable(&SchemaMigration{}), "Check should not create schema_migrations")
+
+	status, err := Status(db)
+	asserts.NoError(err)
+	asserts.Len(status, len(All()), "status should list every migration")
+	for _, s := range status {
+		asserts.Nil(s.AppliedAt, "nothing should be applied on an empty database")
+	}
+
+	done, err := Up(db)
+	asserts.NoError(err)
+	asserts.Len(done, len(All()), "up should apply every migration")
+	asserts.True(db.HasTable("user_models"), "baseline should create user_models")
+	asserts.True(db.HasTable("article_tags"), "baseline should create the article_tags join table")
+
+	pending, err := Pending(db)
+	asserts.NoError(err)
+	asserts.Empty(pending, "nothing should be pending after up")
+	asserts.NoError(Check(db))
+	done, err = Up(db)
+	asserts.NoError(err)
+	asserts.Empty(done, "a second up should be a no-op")
+
+	status, err = Status(db)
+	asserts.NoError(err)
+	for _, s := range status {
+		asserts.NotNil(s.AppliedAt, "migration %d should be applied", s.Version)
+	}
+
+	done, err = Down(db, 1)
+	asserts.NoError(err)
+	asserts.Len(done, 1, "down should roll back one step")
+	asserts.Equal(All()[len(All())-1].Version, done[0].Version, "down should roll back the newest migration")
+	asserts.ErrorContains(Check(db), "1 pending migrations")
+
+	done, err = Down(db, len(All()))
+	asserts.NoError(err)
+	asserts.Len(done, len(All())-1, "down should stop at the first migration")
+	asserts.False(db.HasTable("user_models"), "baseline down should drop user_models")
+	pending, err = Pending(db)
+	asserts.NoError(err)
+	asserts.Len(pending, len(All()), "everything should be pending after a full rollback")
+}
+
+// The migrated schema should hold every column and index the models rely on.
+func TestMigrationsMatchModels(t *testing.T) {
+	asserts := assert.New(t)
+	db := openTestDB(t)
+
+	_, err := Up(db)
+	asserts.NoError(err)
+
+	models := []interface{}{
+		&users.UserModel{},
+		&users.FollowModel{},
+		&users.RefreshTokenModel{},
+		&users.RevokedTokenModel{},
+		&users.PasswordResetModel{},
+		&users.EmailVerificationModel{},
+		&users.LoginAttemptModel{},
+		&articles.ArticleModel{},
+		&articles.TagModel{},
+		&articles.FavoriteModel{},
+		&articles.ArticleUserModel{},
+		&articles.CommentModel{},
+	}
+	for _, model := range models {
+		scope := db.NewScope(model)
+		table := scope.TableName()
+		asserts.True(db.HasTable(table), "table %s should exist", table)
+		for _, field := range scope.GetModelStruct().StructFields {
+			if !field.IsNormal || field.IsIgnored {
+				continue
+			}
+			asserts.True(db.Dialect().HasColumn(table, field.DBName), "column %s.%s should exist", table, field.DBName)
+		}
+	}
+	asserts.True(db.Dialect().HasColumn("article_tags", "article_model_id"))
+	asserts.True(db.Dialect().HasColumn("article_tags", "tag_model_id"))
+	asserts.True(db.Dialect().HasIndex("article_models", "idx_article_created_at"))
+	asserts.True(db.Dialect().HasIndex("article_models", "idx_article_slug"))
+	asserts.True(db.Dialect().HasIndex("comment_models", "idx_comment_article_id"))
+	asserts.True(db.Dialect().HasIndex("user_models", "uix_user_models_email"))
+	asserts.True(db.Dialect().HasIndex("refresh_tokens", "uix_refresh_tokens_token_hash"))
+	asserts.True(db.Dialect().HasIndex("password_resets", "uix_password_resets_token_hash"))
+}
+
+// A database built by the old AutoMigrate on boot should adopt the baseline without error.
+func TestBaselineOnAutoMigratedDatabase(t *testing.T) {
+	asserts := assert.New(t)
+	db := openTestDB(t)
+
+	db.AutoMigrate(&users.UserModel{}, &users.FollowModel{}, &articles.ArticleModel{}, &articles.TagModel{},
+		&articles.FavoriteModel{}, &articles.ArticleUserModel{}, &articles.CommentModel{})
+	db.Create(&users.UserModel{Username: "legacy", Email: "legacy@g.cn", PasswordHash: "x"})
+
+	_, err := Up(db)
+	asserts.NoError(err)
+	var count int
+	db.Model(&users.UserModel{}).Count(&count)
+	asserts.Equal(1, count, "existing rows should be kept")
+}
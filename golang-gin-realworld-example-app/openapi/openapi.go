@@ -0,0 +1,187 @@
+/*
+The openapi module holds the OpenAPI 3 contract of the API, openapi.yaml, served as JSON at /api/openapi.json.
+
+The schemas of the responses mirror the serializers of the users and articles modules, and every route they
+register has an operation, the tests of this module fail when they drift apart. Validator optionally rejects
+the requests the document does not allow before they reach the handlers, with the usual error body:
+
+	v1 := r.Group("/api")
+	v1.GET("/openapi.json", openapi.Handler(doc))
+	v1.Use(validator)
+*/
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/gin-gonic/gin"
+
+	"realworld-backend/common"
+)
+
+//go:embed openapi.yaml
+var spec []byte
+
+var defineFormats sync.Once
+
+// Parse and check the bundled document.
+func Load() (*openapi3.T, error) {
+	// kin-openapi only checks the formats it is told about.
+	defineFormats.Do(func() {
+		openapi3.DefineStringFormatValidator("email", openapi3.NewRegexpFormatValidator(openapi3.FormatOfStringForEmail))
+	})
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	return doc, nil
+}
+
+// Serve doc as JSON.
+func Handler(doc *openapi3.T) gin.HandlerFunc {
+	body, err := json.Marshal(doc)
+	return func(c *gin.Context) {
+		if err != nil {
+			common.AbortWithError(c, err)
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	}
+}
+
+// The route of doc matching req, gin's trailing slashes like /api/articles/ are ignored.
+type Router struct {
+	router routers.Router
+}
+
+func NewRouter(doc *openapi3.T) (*Router, error) {
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Router{router: router}, nil
+}
+
+func (r *Router) FindRoute(req *http.Request) (*routers.Route, map[string]string, error) {
+	if path := req.URL.Path; len(path) > 1 && strings.HasSuffix(path, "/") {
+		req = req.Clone(req.Context())
+		req.URL.Path = strings.TrimSuffix(path, "/")
+	}
+	return r.router.FindRoute(req)
+}
+
+// A middleware answering 404 to the requests with no operation in doc, and 400 or 422 to the ones whose
+// parameters or body do not match it. The authentication is left to users.AuthMiddleware.
+func Validator(doc *openapi3.T) (gin.HandlerFunc, error) {
+	router, err := NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	options := &openapi3filter.Options{
+		MultiError:         true,
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		// The defaults are applied by the handlers, the request is left as sent.
+		SkipSettingDefaults: true,
+	}
+	return func(c *gin.Context) {
+		route, pathParams, err := router.FindRoute(c.Request)
+		if err != nil {
+			common.AbortWithError(c, common.NewAPIError(common.CodeNotFound, "No such operation").WithCause(err))
+			return
+		}
+		err = openapi3filter.ValidateRequest(c.Request.Context(), &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    options,
+		})
+		if err != nil {
+			common.AbortWithError(c, requestError(err))
+			return
+		}
+		c.Next()
+	}, nil
+}
+
+// Translate the errors of openapi3filter into an APIError, one FieldError per failed rule.
+func requestError(err error) *common.APIError {
+	var errs openapi3.MultiError
+	if !errors.As(err, &errs) {
+		errs = openapi3.MultiError{err}
+	}
+	res := common.NewAPIError(common.CodeValidation, "The request does not match the API specification").WithCause(err)
+	for _, err := range errs {
+		var requestErr *openapi3filter.RequestError
+		if !errors.As(err, &requestErr) {
+			return common.NewAPIError(common.CodeMalformed, "The request could not be validated").WithCause(err)
+		}
+		var schemaErrs openapi3.MultiError
+		if !errors.As(requestErr.Err, &schemaErrs) {
+			schemaErrs = openapi3.MultiError{requestErr.Err}
+		}
+		for _, schemaErr := range schemaErrs {
+			field, ok := fieldError(requestErr, schemaErr)
+			if !ok {
+				return common.NewAPIError(common.CodeMalformed, "The request body could not be decoded").WithCause(err)
+			}
+			res.WithField(field)
+		}
+	}
+	return res
+}
+
+// The messages are localized by common.AbortWithError from the code, Param carries the value of the rule.
+func fieldError(requestErr *openapi3filter.RequestError, err error) (common.FieldError, bool) {
+	var path string
+	if requestErr.Parameter != nil {
+		path = requestErr.Parameter.Name
+	}
+	var schemaErr *openapi3.SchemaError
+	switch {
+	case errors.As(err, &schemaErr):
+		if pointer := schemaErr.JSONPointer(); len(pointer) > 0 && requestErr.Parameter == nil {
+			path = strings.Join(pointer, ".")
+		}
+		field := common.FieldError{Path: path, Code: schemaErr.SchemaField, Message: schemaErr.Reason}
+		if field.Code == "" {
+			field.Code = "invalid"
+		}
+		switch schema := schemaErr.Schema; field.Code {
+		case "minLength":
+			field.Param = strconv.FormatUint(schema.MinLength, 10)
+		case "maxLength":
+			if schema.MaxLength != nil {
+				field.Param = strconv.FormatUint(*schema.MaxLength, 10)
+			}
+		case "minimum":
+			if schema.Min != nil {
+				field.Param = strconv.FormatFloat(*schema.Min, 'f', -1, 64)
+			}
+		case "pattern":
+			field.Param = schema.Pattern
+		case "format":
+			field.Param = schema.Format
+		}
+		return field, true
+	case errors.Is(err, openapi3filter.ErrInvalidRequired):
+		return common.FieldError{Path: path, Code: "required", Message: "required"}, true
+	case requestErr.Parameter != nil:
+		return common.FieldError{Path: path, Code: "invalid", Message: requestErr.Error()}, true
+	}
+	return common.FieldError{}, false
+}
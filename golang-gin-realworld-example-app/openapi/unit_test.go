@@ -0,0 +1,181 @@
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+func loadTestDoc(t *testing.T) *openapi3.T {
+	doc, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// The JSON names of the fields of a serializer response.
+func jsonFields(typ reflect.Type) []string {
+	var names []string
+	for i := 0; i < typ.NumField(); i++ {
+		if typ.Field(i).Anonymous {
+			names = append(names, jsonFields(typ.Field(i).Type)...)
+			continue
+		}
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSchemasMatchSerializers(t *testing.T) {
+	asserts := assert.New(t)
+	doc := loadTestDoc(t)
+
+	for name, response := range map[string]interface{}{
+		"User":         users.UserResponse{},
+		"Session":      users.SessionResponse{},
+		"Profile":      users.ProfileResponse{},
+		"Article":      articles.ArticleResponse{},
+		"Comment":      articles.CommentResponse{},
+		"LoginAttempt": users.LoginAttemptResponse{},
+	} {
+		schema := doc.Components.Schemas[name].Value
+		if !asserts.NotNil(schema, name) {
+			continue
+		}
+		var properties []string
+		for property := range schema.Properties {
+			properties = append(properties, property)
+		}
+		sort.Strings(properties)
+		required := append([]string(nil), schema.Required...)
+		sort.Strings(required)
+
+		fields := jsonFields(reflect.TypeOf(response))
+		asserts.Equal(fields, properties, "the properties of %s should be the fields of its serializer", name)
+		asserts.Equal(fields, required, "the serializer always writes every field of %s", name)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/openapi.json", Handler(loadTestDoc(t)))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Header().Get("Content-Type"), "application/json")
+	var served map[string]interface{}
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &served))
+	asserts.Equal("3.0.3", served["openapi"])
+	asserts.Contains(served["paths"], "/articles/{slug}/favorite")
+}
+
+func TestValidator(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	validator, err := Validator(loadTestDoc(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(validator)
+	var received string
+	handler := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		received = string(body)
+		c.Status(http.StatusOK)
+	}
+	api.POST("/users", handler)
+	// Registered like articles.ArticlesAnonymousRegister does.
+	api.GET("/articles/", handler)
+	api.GET("/articles/:slug", handler)
+	api.GET("/undocumented", handler)
+
+	send := func(method, path, body string) (int, common.ErrorResponse) {
+		var reader io.Reader
+		if body != "" {
+			reader = strings.NewReader(body)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var res common.ErrorResponse
+		if w.Code != http.StatusOK {
+			asserts.NoError(json.Unmarshal(w.Body.Bytes(), &res), w.Body.String())
+		}
+		return w.Code, res
+	}
+	paths := func(res common.ErrorResponse) map[string]string {
+		codes := map[string]string{}
+		if res.Error != nil {
+			for _, field := range res.Error.Fields {
+				codes[field.Path] = field.Code
+			}
+		}
+		return codes
+	}
+
+	valid := `{"user":{"username":"jake1234","email":"jake@jake.jake","password":"jakejake"}}`
+	code, _ := send("POST", "/api/users", valid)
+	asserts.Equal(http.StatusOK, code)
+	asserts.Equal(valid, received, "the handler should still read the body")
+
+	code, res := send("POST", "/api/users", `{"user":{"username":"jake","email":"not an email","password":"short"}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, code)
+	asserts.Equal(common.CodeValidation, res.Error.Code)
+	asserts.Equal(map[string]string{"user.email": "format", "user.password": "minLength"}, paths(res))
+	for _, field := range res.Error.Fields {
+		if field.Path == "user.password" {
+			asserts.Equal("8", field.Param, "the param should be the length required")
+		}
+	}
+
+	code, res = send("POST", "/api/users", `{"user":{"username":"jake1234","password":"jakejake"}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, code)
+	asserts.Equal(map[string]string{"user.email": "required"}, paths(res))
+	asserts.Equal("email is required", res.Error.Fields[0].Message)
+
+	code, res = send("POST", "/api/users", `{"user":`)
+	asserts.Equal(http.StatusBadRequest, code, "a body that is not JSON should be malformed")
+	asserts.Equal(common.CodeMalformed, res.Error.Code)
+
+	code, res = send("GET", "/api/articles/?limit=zero", "")
+	asserts.Equal(http.StatusUnprocessableEntity, code)
+	asserts.Contains(paths(res), "limit")
+	code, res = send("GET", "/api/articles/?limit=0", "")
+	asserts.Equal(http.StatusUnprocessableEntity, code)
+	asserts.Equal(map[string]string{"limit": "minimum"}, paths(res))
+	code, _ = send("GET", "/api/articles/?limit=5&tag=dragons", "")
+	asserts.Equal(http.StatusOK, code, "the trailing slash of gin should find the operation")
+	code, _ = send("GET", "/api/articles/how-to-train-your-dragon", "")
+	asserts.Equal(http.StatusOK, code)
+
+	code, res = send("GET", "/api/undocumented", "")
+	asserts.Equal(http.StatusNotFound, code)
+	asserts.Equal(common.CodeNotFound, res.Error.Code)
+}
@@ -0,0 +1,144 @@
+/*
+The ratelimit module limits how often a client may call the routes worth abusing, like the login.
+
+Every client has a token bucket per limit: it holds up to Rate.Requests tokens, a request takes one and they come
+back evenly over Rate.Period. "10/1m" allows a burst of 10 logins, then one every 6 seconds. The limited routes
+answer with the RateLimit headers of the IETF draft, and a request finding the bucket empty gets a 429:
+
+	HTTP/1.1 429 Too Many Requests
+	Retry-After: 6
+	RateLimit-Policy: 10;w=60
+	RateLimit-Limit: 10
+	RateLimit-Remaining: 0
+	RateLimit-Reset: 60
+
+RateLimit-Reset is the number of seconds until the bucket is full again. The buckets live in a Store,
+MemoryStore for a single instance and RedisStore to share them between the instances.
+
+ratelimit.go: the middleware and the bucket arithmetic
+
+store.go: the stores
+*/
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"realworld-backend/common"
+	"realworld-backend/config"
+	"realworld-backend/metrics"
+)
+
+// The outcome of taking a token from a bucket.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Until the bucket is full again.
+	Reset time.Duration
+	// Until the next token, when the request was not allowed.
+	RetryAfter time.Duration
+}
+
+// Who a limit applies to, the requests with an empty key are not limited.
+type KeyFunc func(c *gin.Context) string
+
+// The client IP, see config.ServerConfig.TrustedProxies.
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// The user authenticated by users.AuthMiddleware, or the client IP for the anonymous requests.
+func ByUser(c *gin.Context) string {
+	if userID := c.GetUint("my_user_id"); userID != 0 {
+		return "user:" + strconv.FormatUint(uint64(userID), 10)
+	}
+	return ByIP(c)
+}
+
+// Limit the requests matching one of routes to rate, keeping the buckets in store under name. A route is written
+// like gin matched it, "POST /api/users/login", or is a bare method like "PUT" for every route of the method.
+// Every request is limited when no route is given, and none when rate is off.
+//
+//	v1.Use(ratelimit.Limit(store, "login", cfg.RateLimit.Login, ratelimit.ByIP, "POST /api/users/login"))
+func Limit(store Store, name string, rate config.Rate, key KeyFunc, routes ...string) gin.HandlerFunc {
+	if rate.Off() {
+		return func(c *gin.Context) { c.Next() }
+	}
+	policy := fmt.Sprintf("%d;w=%d", rate.Requests, seconds(time.Duration(rate.Period)))
+	return func(c *gin.Context) {
+		if !common.MatchRoute(c, routes...) {
+			c.Next()
+			return
+		}
+		k := key(c)
+		if k == "" {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+		res, err := store.Take(ctx, "ratelimit:"+name+":"+k, rate)
+		if err != nil {
+			// A broken store should not take the API down with it, the requests go through unlimited meanwhile.
+			common.Logger(ctx).Warn("rate limit store failed", "limit", name, "error", err.Error())
+			c.Next()
+			return
+		}
+		header := c.Writer.Header()
+		header.Set("RateLimit-Policy", policy)
+		header.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+		if !res.Allowed {
+			header.Set("Retry-After", strconv.Itoa(seconds(res.RetryAfter)))
+			metrics.RateLimited.WithLabelValues(name).Inc()
+			common.AbortWithError(c, common.NewAPIError(common.CodeRateLimited, "Too many requests, retry later"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// Whole seconds, rounded up so that a client waiting that long finds a token.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// Refill a bucket holding tokens, last counted elapsed ago, then take one token from it.
+// A new bucket is full. The stores keep the tokens left and run this under their lock.
+func take(tokens float64, elapsed time.Duration, isNew bool, rate config.Rate) (float64, Result) {
+	capacity := float64(rate.Requests)
+	perToken := float64(rate.Period) / capacity
+	if isNew {
+		tokens = capacity
+	} else if elapsed > 0 {
+		tokens = math.Min(capacity, tokens+float64(elapsed)/perToken)
+	}
+	res := Result{Limit: rate.Requests}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - tokens) * perToken)
+	}
+	res.Remaining = int(tokens)
+	res.Reset = time.Duration((capacity - tokens) * perToken)
+	return tokens, res
+}
+
+// The store the server configuration asks for.
+func NewStore(cfg config.RateLimitConfig) (Store, error) {
+	if cfg.Store != "redis" {
+		return NewMemoryStore(), nil
+	}
+	store, err := NewRedisStore(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("rate_limit.redis_url: %w", err)
+	}
+	return store, nil
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"realworld-backend/config"
+)
+
+// Keeps the buckets, Take has to be atomic for a key.
+type Store interface {
+	// Take a token from the bucket of key, which holds rate.Requests tokens refilled over rate.Period.
+	Take(ctx context.Context, key string, rate config.Rate) (Result, error)
+}
+
+type memoryBucket struct {
+	tokens float64
+	at     time.Time
+	full   time.Time
+}
+
+// Buckets in a map of the process, for a single instance. The full buckets are dropped once a minute.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+	// The clock, replaced by the tests.
+	now func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]memoryBucket{}, now: time.Now}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, rate config.Rate) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, b := range s.buckets {
+			if !now.Before(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	b, ok := s.buckets[key]
+	tokens, res := take(b.tokens, now.Sub(b.at), !ok, rate)
+	s.buckets[key] = memoryBucket{tokens: tokens, at: now, full: now.Add(res.Reset)}
+	return res, nil
+}
+
+// How many times Take retries when another instance changed the bucket meanwhile.
+const redisRetries = 10
+
+// Buckets in a Redis server shared by the instances, or anything speaking its protocol, as hashes of the
+// tokens left and when they were counted. The clocks of the instances should agree, NTP is enough.
+type RedisStore struct {
+	client *redis.Client
+	now    func() time.Time
+}
+
+// Connect to the server at a redis:// or rediss:// URL, the connection is only made by the first command.
+func NewRedisStore(url string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisStore{client: redis.NewClient(opts), now: time.Now}, nil
+}
+
+// The bucket is read, refilled and written in a WATCH transaction, retried when another instance got in between.
+func (s *RedisStore) Take(ctx context.Context, key string, rate config.Rate) (Result, error) {
+	var res Result
+	update := func(tx *redis.Tx) error {
+		state, err := tx.HMGet(ctx, key, "tokens", "at").Result()
+		if err != nil {
+			return err
+		}
+		now := s.now()
+		tokens, errTokens := parseFloat(state[0])
+		at, errAt := parseFloat(state[1])
+		isNew := errTokens != nil || errAt != nil
+		elapsed := now.Sub(time.UnixMicro(int64(at)))
+		tokens, res = take(tokens, elapsed, isNew, rate)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, "tokens", strconv.FormatFloat(tokens, 'f', -1, 64), "at", now.UnixMicro())
+			// Once full the bucket is the same as no bucket.
+			pipe.PExpire(ctx, key, res.Reset+time.Second)
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < redisRetries; i++ {
+		err := s.client.Watch(ctx, update, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return res, err
+		}
+	}
+	return Result{}, redis.TxFailedErr
+}
+
+// The readiness check of the server, see the health module.
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+func parseFloat(value interface{}) (float64, error) {
+	raw, ok := value.(string)
+	if !ok {
+		return 0, errors.New("missing")
+	}
+	return strconv.ParseFloat(raw, 64)
+}
@@ -0,0 +1,202 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"realworld-backend/config"
+	"realworld-backend/metrics"
+)
+
+var perMinute = config.Rate{Requests: 3, Period: config.Duration(time.Minute)}
+
+// A clock the tests move by hand.
+type testClock struct{ now time.Time }
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func TestTake(t *testing.T) {
+	asserts := assert.New(t)
+
+	tokens, res := take(0, 0, true, perMinute)
+	asserts.True(res.Allowed, "a new bucket should be full")
+	asserts.Equal(2, res.Remaining)
+	asserts.Equal(20*time.Second, res.Reset)
+
+	tokens, _ = take(tokens, 0, false, perMinute)
+	tokens, _ = take(tokens, 0, false, perMinute)
+	tokens, res = take(tokens, 0, false, perMinute)
+	asserts.False(res.Allowed)
+	asserts.Equal(0, res.Remaining)
+	asserts.Equal(20*time.Second, res.RetryAfter, "a token comes back every 20s")
+	asserts.Equal(time.Minute, res.Reset)
+
+	_, res = take(tokens, 5*time.Second, false, perMinute)
+	asserts.False(res.Allowed)
+	asserts.Equal(15*time.Second, res.RetryAfter)
+	_, res = take(tokens, time.Hour, false, perMinute)
+	asserts.True(res.Allowed)
+	asserts.Equal(2, res.Remaining, "the bucket should not hold more than its size")
+}
+
+// The conformance suite of the stores, clock moves the clock of store.
+func testStore(t *testing.T, store Store, clock *testClock) {
+	asserts := assert.New(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		res, err := store.Take(ctx, "jake", perMinute)
+		asserts.NoError(err)
+		asserts.True(res.Allowed, "request %d should fit the burst", i+1)
+	}
+	res, err := store.Take(ctx, "jake", perMinute)
+	asserts.NoError(err)
+	asserts.False(res.Allowed)
+	asserts.Equal(20*time.Second, res.RetryAfter)
+
+	res, err = store.Take(ctx, "anna", perMinute)
+	asserts.NoError(err)
+	asserts.True(res.Allowed, "the buckets should be per key")
+
+	clock.now = clock.now.Add(20 * time.Second)
+	res, err = store.Take(ctx, "jake", perMinute)
+	asserts.NoError(err)
+	asserts.True(res.Allowed, "a token should be back after 20s")
+	res, err = store.Take(ctx, "jake", perMinute)
+	asserts.NoError(err)
+	asserts.False(res.Allowed)
+}
+
+func TestMemoryStore(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	store := NewMemoryStore()
+	store.now = clock.Now
+	testStore(t, store, clock)
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	store.Take(context.Background(), "anna", perMinute)
+	assert.Len(t, store.buckets, 1, "the full buckets should be dropped")
+}
+
+func TestRedisStore(t *testing.T) {
+	asserts := assert.New(t)
+	server := miniredis.RunT(t)
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	newStore := func() *RedisStore {
+		store, err := NewRedisStore("redis://" + server.Addr() + "/0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.now = clock.Now
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+	store := newStore()
+	asserts.NoError(store.Ping(context.Background()))
+	testStore(t, store, clock)
+
+	res, err := newStore().Take(context.Background(), "jake", perMinute)
+	asserts.NoError(err)
+	asserts.False(res.Allowed, "the instances should share the buckets")
+	asserts.True(server.Exists("jake"))
+	server.FastForward(2 * time.Minute)
+	asserts.False(server.Exists("jake"), "the buckets should expire once full")
+
+	server.Close()
+	_, err = store.Take(context.Background(), "jake", perMinute)
+	asserts.Error(err)
+	asserts.Error(store.Ping(context.Background()))
+
+	_, err = NewRedisStore("http://localhost")
+	asserts.Error(err)
+}
+
+func TestLimit(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	store := NewMemoryStore()
+	store.now = clock.Now
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if c.GetHeader("X-Test-User") == "jake" {
+			c.Set("my_user_id", uint(1))
+		}
+	})
+	r.Use(Limit(store, "login", perMinute, ByIP, "POST /users/login"))
+	r.Use(Limit(store, "write", config.Rate{Requests: 1, Period: config.Duration(time.Minute)}, ByUser, "PUT"))
+	r.Use(Limit(store, "off", config.Rate{}, ByIP))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.POST("/users/login", ok)
+	r.POST("/users/", ok)
+	r.PUT("/user", ok)
+
+	send := func(method, path, ip, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		asserts.Equal(http.StatusOK, send("POST", "/users/login", "10.0.0.1", "").Code)
+	}
+	limited := testutil.ToFloat64(metrics.RateLimited.WithLabelValues("login"))
+	w := send("POST", "/users/login", "10.0.0.1", "")
+	asserts.Equal(http.StatusTooManyRequests, w.Code)
+	asserts.Contains(w.Body.String(), `"code":"rate_limited"`)
+	asserts.Equal("20", w.Header().Get("Retry-After"))
+	asserts.Equal("3;w=60", w.Header().Get("RateLimit-Policy"))
+	asserts.Equal("3", w.Header().Get("RateLimit-Limit"))
+	asserts.Equal("0", w.Header().Get("RateLimit-Remaining"))
+	asserts.Equal("60", w.Header().Get("RateLimit-Reset"))
+	asserts.Equal(limited+1, testutil.ToFloat64(metrics.RateLimited.WithLabelValues("login")))
+
+	asserts.Equal(http.StatusOK, send("POST", "/users/login", "10.0.0.2", "").Code, "the limit should be per IP")
+	w = send("POST", "/users/", "10.0.0.1", "")
+	asserts.Equal(http.StatusOK, w.Code, "the other routes should not be limited")
+	asserts.Empty(w.Header().Get("RateLimit-Limit"))
+
+	asserts.Equal(http.StatusOK, send("PUT", "/user", "10.0.0.1", "jake").Code)
+	asserts.Equal(http.StatusTooManyRequests, send("PUT", "/user", "10.0.0.2", "jake").Code,
+		"the limit of a user should follow them across IPs")
+	asserts.Equal(http.StatusOK, send("PUT", "/user", "10.0.0.1", "").Code, "anonymous requests should be limited by IP")
+
+	clock.now = clock.now.Add(20 * time.Second)
+	w = send("POST", "/users/login", "10.0.0.1", "")
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal("0", w.Header().Get("RateLimit-Remaining"))
+}
+
+func TestLimitStoreDown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := miniredis.RunT(t)
+	store, err := NewRedisStore("redis://" + server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	server.Close()
+
+	r := gin.New()
+	r.Use(Limit(store, "login", config.Rate{Requests: 1, Period: config.Duration(time.Minute)}, ByIP))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code, "a broken store should let the requests through")
+	}
+}
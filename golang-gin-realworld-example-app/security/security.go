@@ -0,0 +1,81 @@
+/*
+The security module sets the security headers of the responses, see config.HeadersConfig for their defaults:
+
+	Content-Security-Policy: default-src 'none'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'
+	Strict-Transport-Security: max-age=31536000
+	X-Content-Type-Options: nosniff
+	X-Frame-Options: DENY
+	Referrer-Policy: no-referrer
+	Permissions-Policy: accelerometer=(), camera=(), ...
+
+Headers is registered once next to the CORS middleware, a route needing other values changes them with Override:
+
+	r.Use(security.Headers(cfg.Headers))
+	r.GET("/docs", security.Override(func(h *config.HeadersConfig) {
+		h.ContentSecurityPolicy = "default-src 'self'"
+	}), docs)
+*/
+package security
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"realworld-backend/config"
+)
+
+const contextKey = "security_headers"
+
+// Set the headers of cfg on every response, before the handlers run so that they are there on the errors too.
+func Headers(cfg config.HeadersConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(contextKey, cfg)
+		write(c, cfg)
+		c.Next()
+	}
+}
+
+// Change the headers set by Headers for the routes it is registered on, fn gets a copy of their values.
+func Override(fn func(h *config.HeadersConfig)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get(contextKey)
+		cfg, _ := value.(config.HeadersConfig)
+		fn(&cfg)
+		c.Set(contextKey, cfg)
+		write(c, cfg)
+		c.Next()
+	}
+}
+
+func write(c *gin.Context, cfg config.HeadersConfig) {
+	header := c.Writer.Header()
+	set := func(name, value string) {
+		if value == "" {
+			header.Del(name)
+			return
+		}
+		header.Set(name, value)
+	}
+	csp, reportOnly := "Content-Security-Policy", "Content-Security-Policy-Report-Only"
+	if cfg.CSPReportOnly {
+		csp, reportOnly = reportOnly, csp
+	}
+	set(csp, cfg.ContentSecurityPolicy)
+	header.Del(reportOnly)
+
+	hsts := ""
+	if cfg.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(time.Duration(cfg.HSTSMaxAge)/time.Second))
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+	set("Strict-Transport-Security", hsts)
+	// The responses are JSON whatever their extension, the browsers should not guess otherwise.
+	header.Set("X-Content-Type-Options", "nosniff")
+	set("X-Frame-Options", cfg.FrameOptions)
+	set("Referrer-Policy", cfg.ReferrerPolicy)
+	set("Permissions-Policy", cfg.PermissionsPolicy)
+}
@@ -0,0 +1,67 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"realworld-backend/config"
+)
+
+func TestHeaders(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Headers(config.Default().Headers))
+	r.GET("/articles", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+	r.GET("/docs", Override(func(h *config.HeadersConfig) {
+		h.ContentSecurityPolicy = "default-src 'self'"
+		h.CSPReportOnly = true
+		h.HSTSIncludeSubdomains = true
+		h.FrameOptions = ""
+	}), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/articles", nil))
+	header := w.Header()
+	asserts.Equal("default-src 'none'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'", header.Get("Content-Security-Policy"))
+	asserts.Empty(header.Get("Content-Security-Policy-Report-Only"))
+	asserts.Equal("max-age=31536000", header.Get("Strict-Transport-Security"))
+	asserts.Equal("nosniff", header.Get("X-Content-Type-Options"))
+	asserts.Equal("DENY", header.Get("X-Frame-Options"))
+	asserts.Equal("no-referrer", header.Get("Referrer-Policy"))
+	asserts.Contains(header.Get("Permissions-Policy"), "geolocation=()")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/docs", nil))
+	header = w.Header()
+	asserts.Empty(header.Get("Content-Security-Policy"), "a report-only policy should not be enforced")
+	asserts.Equal("default-src 'self'", header.Get("Content-Security-Policy-Report-Only"))
+	asserts.Equal("max-age=31536000; includeSubDomains", header.Get("Strict-Transport-Security"))
+	asserts.Empty(header.Get("X-Frame-Options"), "an empty value should leave the header out")
+	asserts.Equal("no-referrer", header.Get("Referrer-Policy"), "the other headers should be kept")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/nowhere", nil))
+	asserts.Equal(http.StatusNotFound, w.Code)
+	asserts.Equal("nosniff", w.Header().Get("X-Content-Type-Options"), "the errors should carry the headers too")
+}
+
+func TestHeadersOff(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Headers(config.HeadersConfig{HSTSMaxAge: config.Duration(-time.Second)}))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	for _, name := range []string{"Content-Security-Policy", "Strict-Transport-Security", "X-Frame-Options", "Referrer-Policy", "Permissions-Policy"} {
+		asserts.Empty(w.Header().Get(name), name)
+	}
+	asserts.Equal("nosniff", w.Header().Get("X-Content-Type-Options"), "nosniff is always right for an API")
+}
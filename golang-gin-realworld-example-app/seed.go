@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/fixtures"
+	"realworld-backend/users"
+)
+
+// Handle `seed [FILE]`: load the fixture file, or the bundled demo data, through the stores.
+// Seeding a database that already has one of the fixture users does nothing.
+func runSeed(c *cli, args []string) error {
+	var f *fixtures.Fixture
+	var err error
+	switch len(args) {
+	case 0:
+		f, err = fixtures.Demo()
+	case 1:
+		f, err = fixtures.ReadFile(args[0])
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	userStore := users.NewGormUserStore(c.db)
+
+	for _, user := range f.Users {
+		if _, err := userStore.FindOne(ctx, users.UserModel{Email: user.Email}); err == nil {
+			fmt.Fprintf(c.out, "the seed data is already there, %s exists\n", user.Email)
+			return nil
+		} else if !errors.Is(err, common.ErrNotFound) {
+			return err
+		}
+	}
+
+	loadedUsers, err := users.LoadFixture(ctx, userStore, f)
+	if err != nil {
+		return validationError(err)
+	}
+	loadedArticles, err := articles.LoadFixture(ctx, articles.NewGormArticleStore(c.db), articles.NewGormCommentStore(c.db),
+		articles.NewGormTagStore(c.db), f, loadedUsers)
+	if err != nil {
+		return validationError(err)
+	}
+
+	var emails, slugs []string
+	for _, user := range f.Users {
+		emails = append(emails, loadedUsers[user.Key()].Email)
+	}
+	for _, article := range f.Articles {
+		slugs = append(slugs, loadedArticles[article.Key()].Slug)
+	}
+	fmt.Fprintf(c.out, "seeded the users %s\n", strings.Join(emails, ", "))
+	fmt.Fprintf(c.out, "seeded the articles %s\n", strings.Join(slugs, ", "))
+	return nil
+}
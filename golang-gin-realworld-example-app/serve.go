@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/health"
+	"realworld-backend/mail"
+	"realworld-backend/metrics"
+	"realworld-backend/migrations"
+	"realworld-backend/openapi"
+	"realworld-backend/ratelimit"
+	"realworld-backend/security"
+	"realworld-backend/server"
+	"realworld-backend/users"
+)
+
+// Handle `serve`: apply the pending migrations when configured to, then serve the API until SIGTERM.
+// The orchestrator probes /healthz and /readyz, see the health module.
+func runServe(c *cli, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	// sql.Open does not connect, an unreachable database should stop the start instead of the first requests.
+	if err := c.db.DB().Ping(); err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	if c.cfg.Database.AutoMigrate {
+		if _, err := migrations.Up(c.db); err != nil {
+			return err
+		}
+	}
+
+	// SIGTERM is what the orchestrators send on a rolling deploy, the requests in flight are drained first.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := metrics.RegisterDB(c.db.DB(), "realworld"); err != nil {
+		return err
+	}
+	limits, err := ratelimit.NewStore(c.cfg.RateLimit)
+	if err != nil {
+		return err
+	}
+	c.limits = limits
+	if c.mailer, err = mail.NewMailer(c.cfg.Mail); err != nil {
+		return err
+	}
+	// The background workers of the server, a shutdown waits for them after the requests in flight
+	var srv *server.Server
+	c.background = func(fn func()) {
+		if !srv.Go(func(context.Context) { fn() }) {
+			slog.Warn("background work dropped, the server is shutting down")
+		}
+	}
+	srv = server.New(c.cfg.Server, newRouter(c))
+	srv.OnShutdown(c.db.Close)
+	if c.cfg.JWT.KeysFile != "" {
+		srv.Go(func(ctx context.Context) { watchKeys(ctx, c.cfg.JWT, keysReloadInterval) })
+	}
+	srv.Go(func(ctx context.Context) { pruneTokens(ctx, users.NewGormTokenStore(c.db), tokensPruneInterval) })
+	if redis, ok := limits.(*ratelimit.RedisStore); ok {
+		srv.OnShutdown(redis.Close)
+	}
+	return srv.Run(ctx)
+}
+
+func newRouter(c *cli) *gin.Engine {
+	r := gin.New()
+	// Validated with the configuration.
+	if err := r.SetTrustedProxies(c.cfg.Server.TrustedProxies); err != nil {
+		panic(err)
+	}
+	// Security headers before any route, gin only runs a middleware for the routes registered after it. See the
+	// security module to change them for a route.
+	r.Use(common.RequestLogger(slog.Default()), common.Recovery(), metrics.Middleware(), security.Headers(c.cfg.Headers))
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	checker := health.New(health.DefaultTimeout)
+	checker.Add("database", health.Ping(c.db.DB()))
+	checker.Add("migrations", func(ctx context.Context) error {
+		return migrations.Check(common.WithContext(ctx, c.db))
+	})
+	limits := c.limits
+	if limits == nil {
+		limits = ratelimit.NewMemoryStore()
+	}
+	if redis, ok := limits.(*ratelimit.RedisStore); ok {
+		checker.Add("rate_limit", redis.Ping)
+	}
+	checker.Register(r)
+
+	// Configure CORS, after the probes and the metrics which the browsers do not call
+	r.Use(cors.New(cors.Config{
+		AllowOrigins:     c.cfg.CORS.AllowOrigins,
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowCredentials: true,
+	}))
+
+	// The public keys of the tokens, for the services checking them on their own
+	r.GET("/.well-known/jwks.json", common.JWKSHandler)
+
+	userStore := users.NewGormUserStore(c.db)
+	userHandler := users.NewHandler(userStore, users.NewGormTokenStore(c.db))
+	userHandler.RefreshTokenTTL = time.Duration(c.cfg.JWT.RefreshTokenTTL)
+	userHandler.AllowQueryToken = c.cfg.JWT.AllowQueryToken
+	if c.mailer != nil {
+		userHandler.Mailer = c.mailer
+	}
+	if c.background != nil {
+		userHandler.Background = c.background
+	}
+	userHandler.PasswordResetURL = c.cfg.Password.ResetURL
+	userHandler.PasswordResetTTL = time.Duration(c.cfg.Password.ResetTokenTTL)
+	userHandler.EmailVerifyURL = c.cfg.Email.VerifyURL
+	userHandler.EmailVerifyTTL = time.Duration(c.cfg.Email.VerifyTokenTTL)
+	userHandler.Throttle = users.NewLoginThrottle(c.cfg.Login)
+	articleHandler := articles.NewHandler(userStore, articles.NewGormArticleStore(c.db),
+		articles.NewGormCommentStore(c.db), articles.NewGormTagStore(c.db))
+
+	// The document is embedded and checked by the tests, it cannot fail at runtime.
+	doc, err := openapi.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	v1 := r.Group("/api")
+	v1.Use(common.RequestTimeout(time.Duration(c.cfg.Server.RequestTimeout)))
+	v1.GET("/openapi.json", openapi.Handler(doc))
+	rates := c.cfg.RateLimit
+	v1.Use(ratelimit.Limit(limits, "login", rates.Login, ratelimit.ByIP, "POST /api/users/login"),
+		ratelimit.Limit(limits, "registration", rates.Registration, ratelimit.ByIP, "POST /api/users/"),
+		ratelimit.Limit(limits, "password_reset", rates.PasswordReset, ratelimit.ByIP,
+			"POST /api/users/password/forgot", "POST /api/users/password/reset"))
+	if c.cfg.Server.ValidateRequests {
+		validator, err := openapi.Validator(doc)
+		if err != nil {
+			panic(err)
+		}
+		v1.Use(validator)
+	}
+	userHandler.UsersRegister(v1.Group("/users"))
+	v1.Use(userHandler.AuthMiddleware(false))
+	articleHandler.ArticlesAnonymousRegister(v1.Group("/articles"))
+	articleHandler.TagsAnonymousRegister(v1.Group("/tags"))
+
+	v1.Use(userHandler.AuthMiddleware(true))
+	v1.Use(ratelimit.Limit(limits, "write", rates.Write, ratelimit.ByUser, "POST", "PUT", "DELETE"))
+	if c.cfg.Email.RequireVerified {
+		// What others read, the rest of the account works before the email is confirmed
+		v1.Use(userHandler.RequireVerifiedEmail("POST /api/articles/", "PUT /api/articles/:slug", "POST /api/articles/:slug/comments"))
+	}
+	userHandler.SessionRegister(v1.Group("/users"))
+	userHandler.UserRegister(v1.Group("/user"))
+	userHandler.ProfileRegister(v1.Group("/profiles"))
+
+	articleHandler.ArticlesRegister(v1.Group("/articles"))
+
+	testAuth := r.Group("/api/ping")
+
+	testAuth.GET("/", func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"message": "pong",
+		})
+	})
+	return r
+}
@@ -0,0 +1,155 @@
+/*
+The server module runs the HTTP server of the API and stops it without dropping requests.
+
+On shutdown it stops accepting connections, waits for the requests in flight up to ServerConfig.ShutdownTimeout,
+cancels the background workers started with Go and waits for them, then runs the OnShutdown hooks, like closing
+the database pool, in the reverse order of their registration.
+*/
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"realworld-backend/config"
+)
+
+type Server struct {
+	http            *http.Server
+	shutdownTimeout time.Duration
+
+	// Cancelled when the server shuts down, the context of the background workers.
+	workerCtx    context.Context
+	stopWorkers  context.CancelFunc
+	workers      sync.WaitGroup
+	mu           sync.Mutex
+	onShutdown   []func() error
+	shutdownOnce sync.Once
+	shutdownErr  error
+
+	// Set under mu by Shutdown before it waits for the workers, Go starts none after it.
+	stopped bool
+}
+
+func New(cfg config.ServerConfig, handler http.Handler) *Server {
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	return &Server{
+		http: &http.Server{
+			Addr:              cfg.Addr(),
+			Handler:           handler,
+			ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+			ReadTimeout:       time.Duration(cfg.ReadTimeout),
+			WriteTimeout:      time.Duration(cfg.WriteTimeout),
+			IdleTimeout:       time.Duration(cfg.IdleTimeout),
+			MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		},
+		shutdownTimeout: time.Duration(cfg.ShutdownTimeout),
+		workerCtx:       workerCtx,
+		stopWorkers:     stopWorkers,
+	}
+}
+
+// Run fn in the background until the server shuts down, ctx is cancelled then and the shutdown waits for fn to return.
+// Once the shutdown waits for the workers fn is not run and Go returns false: a request still busy past the drain
+// could start one using the database after its hook closed it otherwise.
+func (s *Server) Go(fn func(ctx context.Context)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return false
+	}
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		fn(s.workerCtx)
+	}()
+	return true
+}
+
+// Run fn once the requests and the workers are done, like db.Close.
+func (s *Server) OnShutdown(fn func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onShutdown = append(s.onShutdown, fn)
+}
+
+// Listen on the configured address and serve until ctx is done, then shut down.
+//
+//	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+//	defer stop()
+//	err := srv.Run(ctx)
+func (s *Server) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Like Run on an existing listener.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	served := make(chan error, 1)
+	go func() {
+		log.Printf("server: listening on %s", ln.Addr())
+		served <- s.http.Serve(ln)
+	}()
+
+	select {
+	case err := <-served:
+		// The listener failed before any shutdown was asked for.
+		return errors.Join(err, s.Shutdown(context.Background()))
+	case <-ctx.Done():
+	}
+	log.Printf("server: shutting down, waiting up to %s for the requests in flight", s.shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	err := s.Shutdown(shutdownCtx)
+	if serveErr := <-served; !errors.Is(serveErr, http.ErrServerClosed) {
+		err = errors.Join(serveErr, err)
+	}
+	return err
+}
+
+// Stop accepting connections, drain the requests in flight until ctx is done, stop the workers and run the
+// OnShutdown hooks. The connections still busy when ctx is done are closed. Only the first call does the work.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		var errs []error
+		if err := s.http.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("drain: %w", err))
+			s.http.Close()
+		}
+
+		s.mu.Lock()
+		s.stopped = true
+		s.mu.Unlock()
+		s.stopWorkers()
+		workersDone := make(chan struct{})
+		go func() {
+			s.workers.Wait()
+			close(workersDone)
+		}()
+		select {
+		case <-workersDone:
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("workers: %w", ctx.Err()))
+		}
+
+		s.mu.Lock()
+		hooks := s.onShutdown
+		s.mu.Unlock()
+		for i := len(hooks) - 1; i >= 0; i-- {
+			if err := hooks[i](); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		s.shutdownErr = errors.Join(errs...)
+	})
+	return s.shutdownErr
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/config"
+)
+
+func testConfig() config.ServerConfig {
+	cfg := config.Default().Server
+	cfg.Host = "127.0.0.1"
+	cfg.Port = 0
+	cfg.ShutdownTimeout = config.Duration(2 * time.Second)
+	return cfg
+}
+
+func listen(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}
+
+func TestNewAppliesConfig(t *testing.T) {
+	asserts := assert.New(t)
+
+	cfg := testConfig()
+	cfg.MaxHeaderBytes = 4096
+	s := New(cfg, http.NotFoundHandler())
+	asserts.Equal(time.Duration(cfg.ReadHeaderTimeout), s.http.ReadHeaderTimeout)
+	asserts.Equal(time.Duration(cfg.ReadTimeout), s.http.ReadTimeout)
+	asserts.Equal(time.Duration(cfg.WriteTimeout), s.http.WriteTimeout)
+	asserts.Equal(time.Duration(cfg.IdleTimeout), s.http.IdleTimeout)
+	asserts.Equal(4096, s.http.MaxHeaderBytes)
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	asserts := assert.New(t)
+
+	started := make(chan struct{})
+	s := New(testConfig(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "done")
+	}))
+
+	var order []string
+	s.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		order = append(order, "worker")
+	})
+	s.OnShutdown(func() error { order = append(order, "first hook"); return nil })
+	s.OnShutdown(func() error { order = append(order, "second hook"); return nil })
+
+	ln := listen(t)
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ctx, ln) }()
+
+	responded := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			responded <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		responded <- string(body)
+	}()
+
+	<-started
+	stop()
+	asserts.Equal("done", <-responded, "the request in flight should be answered")
+	asserts.NoError(<-served)
+	asserts.Equal([]string{"worker", "second hook", "first hook"}, order,
+		"the workers should stop before the hooks, which run last registered first")
+
+	_, err := http.Get("http://" + ln.Addr().String())
+	asserts.Error(err, "new connections should be refused")
+}
+
+func TestShutdownGivesUp(t *testing.T) {
+	asserts := assert.New(t)
+
+	cfg := testConfig()
+	cfg.ShutdownTimeout = config.Duration(50 * time.Millisecond)
+	started := make(chan struct{})
+	s := New(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	var closed atomic.Bool
+	s.OnShutdown(func() error { closed.Store(true); return nil })
+
+	ln := listen(t)
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ctx, ln) }()
+	go http.Get("http://" + ln.Addr().String())
+
+	<-started
+	begin := time.Now()
+	stop()
+	asserts.ErrorIs(<-served, context.DeadlineExceeded, "a stuck request should not hold the shutdown")
+	asserts.Less(time.Since(begin), 2*time.Second)
+	asserts.True(closed.Load(), "the hooks should run anyway")
+	asserts.Equal(s.Shutdown(context.Background()), s.Shutdown(context.Background()), "only the first shutdown should do the work")
+}
+
+func TestGoAfterShutdown(t *testing.T) {
+	asserts := assert.New(t)
+
+	s := New(testConfig(), http.NotFoundHandler())
+	done := make(chan struct{})
+	asserts.True(s.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		close(done)
+	}))
+	asserts.NoError(s.Shutdown(context.Background()))
+	<-done
+
+	var ran atomic.Bool
+	asserts.False(s.Go(func(ctx context.Context) { ran.Store(true) }), "no worker should start once the shutdown waited for them")
+	time.Sleep(10 * time.Millisecond)
+	asserts.False(ran.Load())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"realworld-backend/common"
+	"realworld-backend/config"
+	"realworld-backend/users"
+)
+
+const tokenUsage = "usage: token issue -email EMAIL"
+
+// Handle `token issue`, which prints a token the API accepts for the user, like the one login answers with.
+func runToken(c *cli, args []string) error {
+	if len(args) == 0 || args[0] != "issue" {
+		return errors.New(tokenUsage)
+	}
+	flags := flag.NewFlagSet("token issue", flag.ContinueOnError)
+	flags.SetOutput(c.out)
+	email := flags.String("email", "", "email of the user")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	userModel, err := findUser(context.Background(), users.NewGormUserStore(c.db), *email)
+	if err != nil {
+		return err
+	}
+	if userModel.Disabled() {
+		return fmt.Errorf("user %s is disabled", userModel.Email)
+	}
+	access, err := common.GenAccessToken(userModel.ID, "")
+	if err != nil {
+		return fmt.Errorf("token: %w", err)
+	}
+	fmt.Fprintln(c.out, access.Token)
+	return nil
+}
+
+// How often `serve` looks for a change of jwt.keys_file.
+const keysReloadInterval = 10 * time.Second
+
+// Load the keys of cfg into common.Keys, which sign and verify the tokens.
+func loadKeys(cfg config.JWTConfig) error {
+	set, err := cfg.KeySet()
+	if err != nil {
+		return err
+	}
+	keys := make([]common.SigningKey, len(set.Keys))
+	for i, key := range set.Keys {
+		if keys[i], err = signingKey(key); err != nil {
+			return fmt.Errorf("jwt key %q: %w", key.ID, err)
+		}
+	}
+	return common.Keys.Replace(keys, set.ActiveKey)
+}
+
+// Read the PEM files of key, an HS256 key only has its secret.
+func signingKey(key config.JWTKey) (common.SigningKey, error) {
+	signing := common.SigningKey{ID: key.ID, Algorithm: key.Algorithm}
+	if key.Secret != "" {
+		signing.Secret = []byte(key.Secret)
+	}
+	if key.PrivateKeyFile != "" {
+		data, err := os.ReadFile(key.PrivateKeyFile)
+		if err != nil {
+			return common.SigningKey{}, err
+		}
+		if signing.Private, err = common.ParsePrivateKey(data); err != nil {
+			return common.SigningKey{}, fmt.Errorf("%s: %w", key.PrivateKeyFile, err)
+		}
+	}
+	if key.PublicKeyFile != "" {
+		data, err := os.ReadFile(key.PublicKeyFile)
+		if err != nil {
+			return common.SigningKey{}, err
+		}
+		if signing.Public, err = common.ParsePublicKey(data); err != nil {
+			return common.SigningKey{}, fmt.Errorf("%s: %w", key.PublicKeyFile, err)
+		}
+	}
+	return signing, nil
+}
+
+// Load the keys again whenever jwt.keys_file changes, until ctx ends.
+// A bad file is logged once and the keys in use are kept until it is fixed.
+func watchKeys(ctx context.Context, cfg config.JWTConfig, interval time.Duration) {
+	last, _ := os.Stat(cfg.KeysFile)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(cfg.KeysFile)
+		if err != nil || (last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size()) {
+			continue
+		}
+		last = info
+		if err := loadKeys(cfg); err != nil {
+			slog.Error("jwt keys not reloaded", "error", err.Error())
+			continue
+		}
+		slog.Info("jwt keys reloaded", "active_key", common.Keys.Active())
+	}
+}
+
+// How often `serve` forgets the expired refresh tokens and revocations.
+const tokensPruneInterval = time.Hour
+
+// Delete the expired tokens of store every interval until ctx ends, the tables would only grow otherwise.
+func pruneTokens(ctx context.Context, store users.TokenStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := store.DeleteExpired(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			slog.Error("expired tokens not deleted", "error", err.Error())
+		}
+	}
+}
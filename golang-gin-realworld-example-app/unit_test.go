@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/config"
+	"realworld-backend/mail"
+	"realworld-backend/metrics"
+	"realworld-backend/openapi"
+	"realworld-backend/users"
+)
+
+// A configuration on a SQLite file of its own, migrated like `migrate up` does.
+func testCLIConfig(t *testing.T) *config.Config {
+	cfg := config.Default()
+	cfg.JWT.Secret = common.NBSecretPassword
+	cfg.Database.DSN = filepath.Join(t.TempDir(), "cli.db")
+	if _, err := runCommand(cfg, "migrate", "up"); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func runCommand(cfg *config.Config, args ...string) (string, error) {
+	var out bytes.Buffer
+	err := run(cfg, args, &out)
+	return out.String(), err
+}
+
+func findTestUser(t *testing.T, cfg *config.Config, email string) users.UserModel {
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	userModel, err := users.NewGormUserStore(db).FindOne(context.Background(), users.UserModel{Email: email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userModel
+}
+
+// Log in through the API of the configured database.
+func loginStatus(t *testing.T, cfg *config.Config, email, password string) int {
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	body := `{"user":{"email": "` + email + `","password": "` + password + `"}}`
+	req := httptest.NewRequest("POST", "/api/users/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	newRouter(&cli{cfg: cfg, db: db}).ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := testCLIConfig(t)
+
+	out, err := runCommand(cfg, "help")
+	asserts.NoError(err)
+	asserts.Contains(out, "token issue -email EMAIL")
+
+	_, err = runCommand(cfg, "deploy")
+	asserts.ErrorIs(err, errUsage)
+	_, err = runCommand(cfg, "user", "rename", "-email", "jake@jake.jake")
+	asserts.ErrorContains(err, "usage: user")
+
+	out, err = runCommand(cfg, "migrate", "status")
+	asserts.NoError(err)
+	asserts.NotContains(out, "pending", "migrate up should have applied everything")
+}
+
+func TestUserCommands(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := testCLIConfig(t)
+
+	out, err := runCommand(cfg, "user", "create", "-username", "jake", "-email", "jake@jake.jake", "-password", "correcthorse")
+	asserts.NoError(err)
+	asserts.Contains(out, "created user 1 jake <jake@jake.jake>")
+	asserts.NotContains(out, "generated password", "a given password should not be printed")
+
+	_, err = runCommand(cfg, "user", "create", "-username", "jake2", "-email", "jake@jake.jake", "-password", "correcthorse")
+	asserts.ErrorIs(err, common.ErrDuplicate, "emails should stay unique")
+	_, err = runCommand(cfg, "user", "create", "-username", "j", "-email", "not-an-email", "-password", "correcthorse")
+	asserts.ErrorContains(err, "username must be at least 4 characters in length")
+	asserts.ErrorContains(err, "email must be a valid email address")
+
+	out, err = runCommand(cfg, "user", "reset-password", "-email", "jake@jake.jake")
+	asserts.NoError(err)
+	generated := strings.TrimSpace(strings.TrimPrefix(strings.SplitN(out, "\n", 2)[0], "generated password:"))
+	asserts.Len(generated, 16, "a random password should be printed")
+	asserts.Equal(http.StatusOK, loginStatus(t, cfg, "jake@jake.jake", generated), "the new password should be saved")
+	asserts.Equal(http.StatusUnauthorized, loginStatus(t, cfg, "jake@jake.jake", "correcthorse"))
+
+	_, err = runCommand(cfg, "user", "reset-password", "-email", "jake@jake.jake", "-password", "short")
+	asserts.ErrorContains(err, "password must be at least 8 characters in length")
+	_, err = runCommand(cfg, "user", "disable", "-email", "nobody@jake.jake")
+	asserts.ErrorContains(err, "no user with email nobody@jake.jake")
+	_, err = runCommand(cfg, "user", "disable")
+	asserts.ErrorContains(err, "-email is required")
+
+	out, err = runCommand(cfg, "token", "issue", "-email", "jake@jake.jake")
+	asserts.NoError(err)
+	token, err := jwt.Parse(strings.TrimSpace(out), func(*jwt.Token) (interface{}, error) {
+		return []byte(common.NBSecretPassword), nil
+	})
+	if asserts.NoError(err) {
+		asserts.Equal(float64(1), token.Claims.(jwt.MapClaims)["id"], "the token should be for the user")
+	}
+
+	out, err = runCommand(cfg, "user", "disable", "-email", "jake@jake.jake")
+	asserts.NoError(err)
+	asserts.Contains(out, "disabled user jake@jake.jake")
+	asserts.True(findTestUser(t, cfg, "jake@jake.jake").Disabled())
+	_, err = runCommand(cfg, "token", "issue", "-email", "jake@jake.jake")
+	asserts.ErrorContains(err, "is disabled", "disabled users should not get tokens")
+}
+
+func TestSeedCommand(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := testCLIConfig(t)
+
+	out, err := runCommand(cfg, "seed")
+	asserts.NoError(err)
+	asserts.Contains(out, "how-to-train-your-dragon")
+	out, err = runCommand(cfg, "seed")
+	asserts.NoError(err)
+	asserts.Contains(out, "already there", "seeding twice should do nothing")
+
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c := &cli{cfg: cfg, db: db}
+	r := newRouter(c)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/articles/how-to-train-your-dragon", nil))
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"favoritesCount":1`)
+	asserts.Contains(w.Body.String(), `"tagList":["dragons","training"]`)
+
+	ctx := context.Background()
+	article, err := articles.NewGormArticleStore(db).FindOne(ctx, articles.ArticleModel{Slug: "how-to-train-your-dragon"})
+	asserts.NoError(err)
+	comments, err := articles.NewGormCommentStore(db).FindByArticle(ctx, article)
+	asserts.NoError(err)
+	asserts.Len(comments, 1)
+	asserts.Equal(http.StatusOK, loginStatus(t, cfg, "anna@anna.anna", "password123"), "the demo users should be able to log in")
+}
+
+func TestSeedCommandFile(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := testCLIConfig(t)
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	_, err := runCommand(cfg, "seed", write("bad.json", `{"users": [{"username": "jake", "email": "jake@jake.jake", "password": "password123"}],
+		"follows": [{"follower": "jake", "followed": "anna"}]}`))
+	asserts.ErrorContains(err, `unknown followed "anna"`)
+	_, err = runCommand(cfg, "seed", write("invalid.yaml", "users:\n  - username: jake\n    email: jake\n    password: password123\n"))
+	asserts.ErrorContains(err, "user jake: email must be a valid email address", "the failing entry should be named")
+	_, err = runCommand(cfg, "seed", write("fixture.txt", ""))
+	asserts.ErrorContains(err, "unsupported format")
+
+	out, err := runCommand(cfg, "seed", write("fixture.json", `{
+		"users": [{"ref": "j", "username": "jake", "email": "jake@jake.jake", "password": "password123"}],
+		"tags": ["unused"],
+		"articles": [{"author": "j", "title": "Hello fixtures", "body": "From JSON"}]
+	}`))
+	asserts.NoError(err)
+	asserts.Contains(out, "seeded the articles hello-fixtures")
+
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tags, err := articles.NewGormTagStore(db).All(context.Background())
+	asserts.NoError(err)
+	if asserts.Len(tags, 1) {
+		asserts.Equal("unused", tags[0].Tag)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := testCLIConfig(t)
+	if _, err := runCommand(cfg, "user", "create", "-username", "jake", "-email", "jake@jake.jake", "-password", "correcthorse"); err != nil {
+		t.Fatal(err)
+	}
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	r := newRouter(&cli{cfg: cfg, db: db})
+
+	successes := testutil.ToFloat64(metrics.Logins.WithLabelValues("success"))
+	failures := testutil.ToFloat64(metrics.Logins.WithLabelValues("failure"))
+	queries := testutil.ToFloat64(metrics.DBQueries.WithLabelValues("query", "ok"))
+	asserts.Equal(http.StatusOK, loginStatus(t, cfg, "jake@jake.jake", "correcthorse"))
+	asserts.Equal(http.StatusUnauthorized, loginStatus(t, cfg, "jake@jake.jake", "wrong password"))
+	asserts.Equal(successes+1, testutil.ToFloat64(metrics.Logins.WithLabelValues("success")))
+	asserts.Equal(failures+1, testutil.ToFloat64(metrics.Logins.WithLabelValues("failure")))
+	asserts.Greater(testutil.ToFloat64(metrics.DBQueries.WithLabelValues("query", "ok")), queries, "the SQL should be counted")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `http_requests_total{method="POST",route="/api/users/login",status="401"}`)
+	asserts.Contains(w.Body.String(), "db_query_duration_seconds_bucket")
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := testCLIConfig(t)
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	r := newRouter(&cli{cfg: cfg, db: db})
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/readyz")
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"migrations":{"status":"ok"`)
+
+	if _, err := runCommand(cfg, "migrate", "down", "1"); err != nil {
+		t.Fatal(err)
+	}
+	w = get("/readyz")
+	asserts.Equal(http.StatusServiceUnavailable, w.Code, "a pending migration should make the instance not ready")
+	asserts.Contains(w.Body.String(), "1 pending migrations")
+
+	db.Close()
+	w = get("/readyz")
+	asserts.Equal(http.StatusServiceUnavailable, w.Code)
+	asserts.Contains(w.Body.String(), `"database":{"status":"fail"`)
+	asserts.Equal(http.StatusOK, get("/healthz").Code, "liveness should not depend on the database")
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := testCLIConfig(t)
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := openapi.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newRouter(&cli{cfg: cfg, db: db})
+	for _, route := range r.Routes() {
+		if !strings.HasPrefix(route.Path, "/api/") || strings.HasPrefix(route.Path, "/api/ping") {
+			continue
+		}
+		path := regexp.MustCompile(`:[a-zA-Z]+`).ReplaceAllString(route.Path, "1")
+		_, _, err := router.FindRoute(httptest.NewRequest(route.Method, path, nil))
+		asserts.NoError(err, "%s %s should be in openapi/openapi.yaml", route.Method, route.Path)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	asserts.Equal(http.StatusOK, w.Code)
+
+	cfg.Server.ValidateRequests = true
+	r = newRouter(&cli{cfg: cfg, db: db})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/articles/?limit=-1", nil))
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+	asserts.Contains(w.Body.String(), `"path":"limit"`)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/articles/?limit=1", nil))
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(http.StatusUnauthorized, loginStatus(t, cfg, "jake@jake.jake", "correcthorse"),
+		"a valid request should reach the handler")
+}
+
+func TestRateLimit(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := testCLIConfig(t)
+	cfg.RateLimit.Login = config.Rate{Requests: 2, Period: config.Duration(time.Minute)}
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	r := newRouter(&cli{cfg: cfg, db: db})
+
+	login := func(forwardedFor string) *httptest.ResponseRecorder {
+		body := `{"user":{"email": "jake@jake.jake","password": "correcthorse"}}`
+		req := httptest.NewRequest("POST", "/api/users/login", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	asserts.Equal(http.StatusUnauthorized, login("10.0.0.1").Code)
+	asserts.Equal("0", login("10.0.0.2").Header().Get("RateLimit-Remaining"))
+	w := login("10.0.0.3")
+	asserts.Equal(http.StatusTooManyRequests, w.Code, "X-Forwarded-For should not be believed from an untrusted peer")
+	asserts.Equal("30", w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/tags/", nil))
+	asserts.Equal(http.StatusOK, w.Code, "the reads should not be limited")
+	asserts.Empty(w.Header().Get("RateLimit-Limit"))
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := testCLIConfig(t)
+	cfg.Headers.CSPReportOnly = true
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	r := newRouter(&cli{cfg: cfg, db: db})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/articles/nope", nil))
+	asserts.Equal(http.StatusNotFound, w.Code)
+	asserts.Equal("nosniff", w.Header().Get("X-Content-Type-Options"))
+	asserts.Equal("DENY", w.Header().Get("X-Frame-Options"))
+	asserts.Equal(cfg.Headers.ContentSecurityPolicy, w.Header().Get("Content-Security-Policy-Report-Only"))
+	asserts.Empty(w.Header().Get("Content-Security-Policy"))
+
+	for _, path := range []string{"/healthz", "/readyz", "/metrics"} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		asserts.Equal("nosniff", w.Header().Get("X-Content-Type-Options"), "%s should get the headers too", path)
+		asserts.Equal(cfg.Headers.ReferrerPolicy, w.Header().Get("Referrer-Policy"), path)
+		asserts.Equal(cfg.Headers.ContentSecurityPolicy, w.Header().Get("Content-Security-Policy-Report-Only"), path)
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := testCLIConfig(t)
+	if _, err := runCommand(cfg, "user", "create", "-username", "jake", "-email", "jake@jake.jake", "-password", "correcthorse"); err != nil {
+		t.Fatal(err)
+	}
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	r := newRouter(&cli{cfg: cfg, db: db})
+	status := func(token string) int {
+		req := httptest.NewRequest("GET", "/api/user/", nil)
+		req.Header.Set("Authorization", "Token "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	secret1, secret2 := strings.Repeat("1", 32), strings.Repeat("2", 32)
+	cfg.JWT.KeysFile = filepath.Join(t.TempDir(), "keys.yaml")
+	writeKeys := func(content string) {
+		if err := os.WriteFile(cfg.JWT.KeysFile, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeKeys("active_key: k1\nkeys:\n  - {kid: k1, secret: " + secret1 + "}\n")
+	asserts.NoError(loadKeys(cfg.JWT))
+	t.Cleanup(func() { loadKeys(config.JWTConfig{Secret: common.NBSecretPassword}) })
+	waitActive := func(kid string) {
+		for i := 0; i < 200 && common.Keys.Active() != kid; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		asserts.Equal(kid, common.Keys.Active())
+	}
+
+	out, err := runCommand(cfg, "token", "issue", "-email", "jake@jake.jake")
+	asserts.NoError(err)
+	oldToken := strings.TrimSpace(out)
+	legacyToken := common.GenToken(1)
+	asserts.Equal(http.StatusOK, status(oldToken))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchKeys(ctx, cfg.JWT, time.Millisecond)
+	// Let the watcher see the first file before changing it.
+	time.Sleep(20 * time.Millisecond)
+
+	writeKeys("active_key: k2\nkeys:\n  - {kid: k2, secret: " + secret2 + "}\n  - {kid: k1, secret: " + secret1 + "}\n")
+	waitActive("k2")
+	asserts.Equal(http.StatusOK, status(oldToken), "the tokens of the retired key should stay valid")
+	asserts.Equal(http.StatusOK, status(common.GenToken(1)))
+	asserts.Equal(http.StatusOK, status(legacyToken))
+
+	writeKeys("keys: [")
+	time.Sleep(20 * time.Millisecond)
+	asserts.Equal("k2", common.Keys.Active(), "a broken file should keep the keys in use")
+
+	writeKeys("active_key: k2\nkeys:\n  - {kid: k2, secret: " + secret2 + "}\n  - {kid: k3, secret: " + secret1 + "}\n")
+	for i := 0; i < 200 && status(oldToken) != http.StatusUnauthorized; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	asserts.Equal(http.StatusUnauthorized, status(oldToken), "the tokens of a removed key should be refused")
+}
+
+func TestJWKS(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := testCLIConfig(t)
+	if _, err := runCommand(cfg, "user", "create", "-username", "jake", "-email", "jake@jake.jake", "-password", "correcthorse"); err != nil {
+		t.Fatal(err)
+	}
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	r := newRouter(&cli{cfg: cfg, db: db})
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	privateFile := filepath.Join(t.TempDir(), "rsa.pem")
+	if err := os.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.JWT.Keys = []config.JWTKey{{ID: "rsa-1", Algorithm: config.AlgRS256, PrivateKeyFile: privateFile}}
+	cfg.JWT.ActiveKey = "rsa-1"
+	asserts.NoError(loadKeys(cfg.JWT))
+	t.Cleanup(func() { loadKeys(config.JWTConfig{Secret: common.NBSecretPassword}) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal("public, max-age=300", w.Header().Get("Cache-Control"))
+	var set common.JWKSet
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &set))
+	if asserts.Len(set.Keys, 1, "only the public keys should be published") {
+		asserts.Equal("rsa-1", set.Keys[0].ID)
+		asserts.Equal("RS256", set.Keys[0].Algorithm)
+	}
+	asserts.NotContains(w.Body.String(), common.NBSecretPassword)
+
+	status := func(token string) int {
+		req := httptest.NewRequest("GET", "/api/user/", nil)
+		req.Header.Set("Authorization", "Token "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	token := common.GenToken(1)
+	parsed, _, _ := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	asserts.Equal("RS256", parsed.Header["alg"])
+	asserts.Equal(http.StatusOK, status(token))
+
+	// Issued for another API sharing the keys
+	now := time.Now()
+	other, _ := common.Keys.Sign(jwt.MapClaims{"id": 1, "sub": "1", "jti": "x", "iss": common.TokenIssuer,
+		"aud": "billing", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()})
+	asserts.Equal(http.StatusUnauthorized, status(other))
+	// Without the registered claims, like the tokens of older versions
+	old, _ := common.Keys.Sign(jwt.MapClaims{"id": 1, "exp": now.Add(time.Hour).Unix()})
+	asserts.Equal(http.StatusUnauthorized, status(old))
+
+	cfg.JWT.Keys[0].PrivateKeyFile = filepath.Join(t.TempDir(), "missing.pem")
+	asserts.ErrorContains(loadKeys(cfg.JWT), `jwt key "rsa-1"`)
+	asserts.Equal("rsa-1", common.Keys.Active())
+}
+
+func TestPasswordResetRoutes(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := testCLIConfig(t)
+	cfg.RateLimit.PasswordReset = config.Rate{Requests: 2, Period: config.Duration(time.Hour)}
+	cfg.Password.ResetURL = "https://conduit.example/reset-password"
+	if _, err := runCommand(cfg, "user", "create", "-username", "jake", "-email", "jake@jake.jake", "-password", "correcthorse"); err != nil {
+		t.Fatal(err)
+	}
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mailer := &mail.MemoryMailer{}
+	// The mails are sent before the request answers, to read them right after
+	r := newRouter(&cli{cfg: cfg, db: db, mailer: mailer, background: func(fn func()) { fn() }})
+	post := func(path, body string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	asserts.Equal(http.StatusAccepted, post("/api/users/password/forgot", `{"user":{"email":"jake@jake.jake"}}`))
+	messages := mailer.Messages()
+	if !asserts.Len(messages, 1) {
+		return
+	}
+	token := regexp.MustCompile(`https://conduit\.example/reset-password\?token=([a-zA-Z0-9-_]+)`).FindStringSubmatch(messages[0].Body)
+	if !asserts.NotNil(token, messages[0].Body) {
+		return
+	}
+	asserts.Equal(http.StatusNoContent, post("/api/users/password/reset", `{"user":{"token":"`+token[1]+`","password":"newpassword"}}`))
+	asserts.Equal(http.StatusOK, loginStatus(t, cfg, "jake@jake.jake", "newpassword"))
+	asserts.Equal(http.StatusTooManyRequests, post("/api/users/password/forgot", `{"user":{"email":"jake@jake.jake"}}`),
+		"the forgot and reset requests should share a limit")
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	asserts := assert.New(t)
+	cfg := testCLIConfig(t)
+	cfg.Email.RequireVerified = true
+	cfg.Email.VerifyURL = "https://conduit.example/verify-email"
+	db, err := common.Open(cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mailer := &mail.MemoryMailer{}
+	r := newRouter(&cli{cfg: cfg, db: db, mailer: mailer})
+	send := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	article := `{"article":{"title":"How to train your dragon","description":"Ever wonder how?","body":"You have to believe","tagList":[]}}`
+
+	w := send("POST", "/api/users/", "", `{"user":{"username":"jake","email":"jake@jake.jake","password":"correcthorse"}}`)
+	if !asserts.Equal(http.StatusCreated, w.Code, w.Body.String()) {
+		return
+	}
+	var res struct {
+		User struct {
+			Token string `json:"token"`
+		} `json:"user"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	asserts.Equal(http.StatusForbidden, send("POST", "/api/articles/", res.User.Token, article).Code)
+	asserts.Equal(http.StatusOK, send("GET", "/api/user/", res.User.Token, "").Code)
+
+	messages := mailer.Messages()
+	if !asserts.Len(messages, 1) {
+		return
+	}
+	token := regexp.MustCompile(`https://conduit\.example/verify-email\?token=([a-zA-Z0-9-_]+)`).FindStringSubmatch(messages[0].Body)
+	if !asserts.NotNil(token, messages[0].Body) {
+		return
+	}
+	asserts.Equal(http.StatusNoContent, send("POST", "/api/users/email/verify", "", `{"user":{"token":"`+token[1]+`"}}`).Code)
+	asserts.Equal(http.StatusCreated, send("POST", "/api/articles/", res.User.Token, article).Code)
+
+	if _, err := runCommand(cfg, "user", "create", "-username", "admin", "-email", "admin@conduit.example", "-password", "batterystaple"); err != nil {
+		t.Fatal(err)
+	}
+	asserts.True(findTestUser(t, cfg, "admin@conduit.example").EmailVerified(), "the users of the command should be verified")
+}
@@ -0,0 +1,121 @@
+package users
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// A set of leaked passwords kept as a bloom filter of their SHA-1: a few bytes per password whatever their length,
+// and the list itself is never kept in memory. A password of the list is always found, one out of it is taken for
+// a leaked one once in breachedFalsePositives, the price of a password picked again.
+type BreachedList struct {
+	bits   []uint64
+	size   uint64
+	hashes int
+	// How many passwords were added
+	Count int
+}
+
+// The rate of the passwords wrongly refused.
+const breachedFalsePositives = 1e-6
+
+// The bits of the smallest filter, the short lists would spread their bits over too few of them otherwise.
+const breachedMinBits = 1 << 12
+
+// A filter sized for n passwords.
+func newBreachedList(n int) *BreachedList {
+	size := uint64(math.Ceil(-float64(n) * math.Log(breachedFalsePositives) / (math.Ln2 * math.Ln2)))
+	size = max(size, breachedMinBits)
+	hashes := int(math.Ceil(-math.Log2(breachedFalsePositives)))
+	return &BreachedList{bits: make([]uint64, (size+63)/64), size: size, hashes: hashes}
+}
+
+// Load the file at path, one password per line. A line of 40 hexadecimal digits is the SHA-1 of a password, with
+// or without the ":count" of the Have I Been Pwned downloads, the other lines are the passwords themselves.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("password.breached_list: %w", err)
+	}
+	defer f.Close()
+	// Counted first to size the filter, the file is read twice instead of held in memory
+	n, err := countLines(f)
+	if err != nil {
+		return nil, fmt.Errorf("password.breached_list: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("password.breached_list: %w", err)
+	}
+	list := newBreachedList(n)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if sum, ok := parseSHA1Line(line); ok {
+			list.add(sum)
+		} else {
+			list.add(sha1.Sum([]byte(line)))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("password.breached_list: %w", err)
+	}
+	return list, nil
+}
+
+func countLines(r io.Reader) (int, error) {
+	n := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		n++
+	}
+	return n, scanner.Err()
+}
+
+// The SHA-1 of a line like "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493".
+func parseSHA1Line(line string) (sum [sha1.Size]byte, ok bool) {
+	digest, _, _ := strings.Cut(line, ":")
+	if len(digest) != 2*sha1.Size {
+		return sum, false
+	}
+	if _, err := hex.Decode(sum[:], []byte(digest)); err != nil {
+		return sum, false
+	}
+	return sum, true
+}
+
+// The bits of a SHA-1, derived from two of its words by enhanced double hashing.
+func (l *BreachedList) positions(sum [sha1.Size]byte, each func(uint64)) {
+	h1 := binary.BigEndian.Uint64(sum[0:8]) % l.size
+	h2 := binary.BigEndian.Uint64(sum[8:16]) % l.size
+	for i := uint64(0); i < uint64(l.hashes); i++ {
+		each(h1)
+		h1 = (h1 + h2) % l.size
+		h2 = (h2 + i + 1) % l.size
+	}
+}
+
+func (l *BreachedList) add(sum [sha1.Size]byte) {
+	l.positions(sum, func(bit uint64) { l.bits[bit/64] |= 1 << (bit % 64) })
+	l.Count++
+}
+
+// Whether password is on the list.
+func (l *BreachedList) Contains(password string) bool {
+	found := true
+	l.positions(sha1.Sum([]byte(password)), func(bit uint64) {
+		if l.bits[bit/64]&(1<<(bit%64)) == 0 {
+			found = false
+		}
+	})
+	return found
+}
@@ -3,6 +3,12 @@ The user module containing the user CRU operation.
 
 model.go: definition of orm based data model
 
+hasher.go: the password hashers, Argon2id and bcrypt
+
+policy.go, breached.go: the rules of the new passwords and the list of the leaked ones
+
+throttle.go: the delays and lockouts of the failed logins
+
 routers.go: router binding and core logic
 
 serializers.go: definition the schema of return data
@@ -0,0 +1,46 @@
+package users
+
+import (
+	"context"
+	"fmt"
+
+	"realworld-backend/fixtures"
+)
+
+// Create the users of f through UserModelValidator, so the passwords are hashed like on sign up, then their follows.
+// The users are returned by the name the fixture refers to them with, their emails taken as verified.
+func LoadFixture(ctx context.Context, store UserStore, f *fixtures.Fixture) (map[string]UserModel, error) {
+	loaded := map[string]UserModel{}
+	for _, user := range f.Users {
+		validator := NewUserModelValidator()
+		validator.User.Username = user.Username
+		validator.User.Email = user.Email
+		validator.User.Password = user.Password
+		validator.User.Bio = user.Bio
+		validator.User.Image = user.Image
+		userModel, err := validator.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", user.Key(), err)
+		}
+		userModel.VerifiedEmail = userModel.Email
+		if err := store.Create(ctx, &userModel); err != nil {
+			return nil, fmt.Errorf("user %s: %w", user.Key(), err)
+		}
+		loaded[user.Key()] = userModel
+	}
+
+	for _, follow := range f.Follows {
+		follower, ok := loaded[follow.Follower]
+		if !ok {
+			return nil, fmt.Errorf("follow: unknown follower %q", follow.Follower)
+		}
+		followed, ok := loaded[follow.Followed]
+		if !ok {
+			return nil, fmt.Errorf("follow: unknown followed %q", follow.Followed)
+		}
+		if err := store.Follow(ctx, follower, followed); err != nil {
+			return nil, err
+		}
+	}
+	return loaded, nil
+}
@@ -0,0 +1,184 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"realworld-backend/common"
+	"realworld-backend/config"
+)
+
+// Hashes the new passwords. The hashes describe how they were made, like
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+//	$2a$12$<salt and key>
+//
+// so checkPassword verifies any of them whatever the current hasher is, and replaces the ones NeedsRehash
+// reports: raising the parameters only takes effect as the users log in, nobody has to reset a password.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Whether hash was made by another algorithm or with other parameters than the ones of the hasher.
+	NeedsRehash(hash string) bool
+}
+
+// The hasher of SetPassword, main replaces it with the one of the password section of the configuration through
+// SetPasswordHasher.
+var Passwords PasswordHasher = NewPasswordHasher(config.Default().Password)
+
+// The hasher of cfg.Algorithm, validated with the configuration.
+func NewPasswordHasher(cfg config.PasswordConfig) PasswordHasher {
+	if cfg.Algorithm == config.AlgBcrypt {
+		return BcryptHasher{Cost: cfg.BcryptCost}
+	}
+	return Argon2idHasher{
+		Memory:      uint32(cfg.Argon2Memory),
+		Iterations:  uint32(cfg.Argon2Iterations),
+		Parallelism: uint8(cfg.Argon2Parallelism),
+	}
+}
+
+var (
+	// Returned by checkPassword for a wrong password.
+	ErrPasswordMismatch = errors.New("password does not match")
+	// Returned by BcryptHasher for a password bcrypt would cut.
+	ErrPasswordTooLong = errors.New("password is too long")
+	errUnknownHash     = errors.New("unknown password hash format")
+)
+
+// Argon2id, the winner of the Password Hashing Competition, with a 16 bytes salt and a 32 bytes key.
+type Argon2idHasher struct {
+	// In KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+const (
+	argon2idPrefix    = "$argon2id$"
+	argon2idSaltBytes = 16
+	argon2idKeyBytes  = 32
+)
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2idSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, argon2idKeyBytes)
+	return h.encode(salt, key), nil
+}
+
+func (h Argon2idHasher) encode(salt, key []byte) string {
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	stored, _, key, err := decodeArgon2id(hash)
+	return err != nil || stored != h || len(key) != argon2idKeyBytes
+}
+
+// The parameters, salt and key of an encoded Argon2id hash.
+func decodeArgon2id(hash string) (h Argon2idHasher, salt, key []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(hash, argon2idPrefix), "$")
+	if !strings.HasPrefix(hash, argon2idPrefix) || len(parts) != 4 {
+		return h, nil, nil, errUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return h, nil, nil, fmt.Errorf("argon2id version %q: %w", parts[0], errUnknownHash)
+	}
+	// argon2 panics on no pass or no thread
+	_, err = fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &h.Memory, &h.Iterations, &h.Parallelism)
+	if err != nil || h.Iterations < 1 || h.Parallelism < 1 {
+		return h, nil, nil, fmt.Errorf("argon2id parameters %q: %w", parts[1], errUnknownHash)
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return h, nil, nil, err
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return h, nil, nil, err
+	}
+	return h, salt, key, nil
+}
+
+// bcrypt, kept for the existing hashes and the deployments that have to stick to it.
+type BcryptHasher struct {
+	Cost int
+}
+
+// bcrypt ignores what comes after.
+const bcryptMaxBytes = 72
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	if len(password) > bcryptMaxBytes {
+		return "", ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+// Check password against a hash of any of the hashers.
+func verifyPassword(hash, password string) error {
+	switch {
+	case strings.HasPrefix(hash, argon2idPrefix):
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return err
+		}
+		computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return ErrPasswordMismatch
+		}
+		return nil
+	case strings.HasPrefix(hash, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return err
+	default:
+		return errUnknownHash
+	}
+}
+
+// Replace Passwords with hasher, and forget the dummy hash made by the one before.
+func SetPasswordHasher(hasher PasswordHasher) {
+	dummyHash.Lock()
+	defer dummyHash.Unlock()
+	Passwords, dummyHash.hash = hasher, ""
+}
+
+// A hash of a random password, checked when the login email is unknown so that it takes as long as a wrong
+// password of a registered one. Made once by Passwords, again after SetPasswordHasher.
+var dummyHash struct {
+	sync.Mutex
+	hash string
+}
+
+// The dummy hash, or the error of Passwords making it: nothing is kept then, the next unknown email tries again.
+func dummyPasswordHash() (string, error) {
+	dummyHash.Lock()
+	defer dummyHash.Unlock()
+	if dummyHash.hash == "" {
+		hash, err := Passwords.Hash(common.RandToken(16))
+		if err != nil {
+			return "", err
+		}
+		dummyHash.hash = hash
+	}
+	return dummyHash.hash, nil
+}
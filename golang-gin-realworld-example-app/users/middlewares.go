@@ -1,7 +1,7 @@
 package users
 
 import (
-	"github.com/golang-jwt/jwt/v5"
+	"errors"
 	"github.com/golang-jwt/jwt/v5/request"
 	"realworld-backend/common"
 	"github.com/gin-gonic/gin"
@@ -9,59 +9,124 @@ import (
 	"strings"
 )
 
-// Strips 'TOKEN ' prefix from token string
+// Strips the scheme from the Authorization header: "Bearer", the standard one, or "Token" of the RealWorld spec,
+// whatever their case. A header without either of them is refused.
 func stripBearerPrefixFromTokenString(tok string) (string, error) {
-	// Should be a bearer token
-	if len(tok) > 5 && strings.ToUpper(tok[0:6]) == "TOKEN " {
-		return tok[6:], nil
+	scheme, token, ok := strings.Cut(tok, " ")
+	if !ok || (!strings.EqualFold(scheme, "Bearer") && !strings.EqualFold(scheme, "Token")) {
+		return "", errors.New("the Authorization header should be Bearer <token> or Token <token>")
 	}
-	return tok, nil
+	return strings.TrimSpace(token), nil
 }
 
 // Extract  token from Authorization header
-// Uses PostExtractionFilter to strip "TOKEN " prefix from header
+// Uses PostExtractionFilter to strip the "Bearer " or "Token " prefix from header
 var AuthorizationHeaderExtractor = &request.PostExtractionFilter{
-	request.HeaderExtractor{"Authorization"},
-	stripBearerPrefixFromTokenString,
+	Extractor: request.HeaderExtractor{"Authorization"},
+	Filter:    stripBearerPrefixFromTokenString,
+}
+
+// Extract the token of the access_token query parameter, for the clients that cannot set a header.
+// The URLs end up in the logs of every proxy on the way, so AuthMiddleware only looks there with AllowQueryToken.
+type queryExtractor struct{}
+
+func (queryExtractor) ExtractToken(req *http.Request) (string, error) {
+	if token := req.URL.Query().Get("access_token"); token != "" {
+		return token, nil
+	}
+	return "", request.ErrNoTokenInRequest
 }
 
 // Extractor for OAuth2 access tokens.  Looks in 'Authorization'
-// header then 'access_token' argument for a token.
+// header then 'access_token' query parameter for a token.
 var MyAuth2Extractor = &request.MultiExtractor{
 	AuthorizationHeaderExtractor,
-	request.ArgumentExtractor{"access_token"},
+	queryExtractor{},
 }
 
+// Returned by UpdateContextUserModel for a user disabled since its token was issued.
+var ErrUserDisabled = errors.New("user is disabled")
+
 // A helper to write user_id and user_model to the context
-func UpdateContextUserModel(c *gin.Context, my_user_id uint) {
+// An unknown or disabled id leaves the anonymous user in place, the error is returned for the callers that care.
+func (h *Handler) UpdateContextUserModel(c *gin.Context, my_user_id uint) error {
 	var myUserModel UserModel
+	var err error
 	if my_user_id != 0 {
-		db := common.GetDB()
-		db.First(&myUserModel, my_user_id)
+		myUserModel, err = h.Users.FindOne(c.Request.Context(), UserModel{ID: my_user_id})
+	}
+	if err == nil && myUserModel.Disabled() {
+		myUserModel, my_user_id, err = UserModel{}, 0, ErrUserDisabled
 	}
 	c.Set("my_user_id", my_user_id)
 	c.Set("my_user_model", myUserModel)
+	return err
 }
 
 // You can custom middlewares yourself as the doc: https://github.com/gin-gonic/gin#custom-middleware
-//  r.Use(AuthMiddleware(true))
-func AuthMiddleware(auto401 bool) gin.HandlerFunc {
+//  r.Use(userHandler.AuthMiddleware(true))
+func (h *Handler) AuthMiddleware(auto401 bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		UpdateContextUserModel(c, 0)
-		token, err := request.ParseFromRequest(c.Request, MyAuth2Extractor, func(token *jwt.Token) (interface{}, error) {
-			b := ([]byte(common.NBSecretPassword))
-			return b, nil
-		})
+		h.UpdateContextUserModel(c, 0)
+		// The signature, expiry, issuer and audience are checked by the keyring
+		var claims *common.Claims
+		var extractor request.Extractor = AuthorizationHeaderExtractor
+		if h.AllowQueryToken {
+			extractor = MyAuth2Extractor
+		}
+		token, err := extractor.ExtractToken(c.Request)
+		if err == nil {
+			claims, err = common.Keys.Parse(token)
+		}
+		if err != nil {
+			if auto401 {
+				common.AbortWithError(c, common.NewAPIError(common.CodeUnauthorized, "Authentication required").WithCause(err))
+			}
+			return
+		}
+		// A token logged out or of a revoked session, see TokenStore
+		revoked, err := h.Tokens.IsRevoked(c.Request.Context(), claims.ID)
 		if err != nil {
+			common.AbortWithError(c, err)
+			return
+		}
+		if revoked {
 			if auto401 {
-				c.AbortWithError(http.StatusUnauthorized, err)
+				common.AbortWithError(c, common.NewAPIError(common.CodeUnauthorized, "The token was revoked"))
 			}
 			return
 		}
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			my_user_id := uint(claims["id"].(float64))
-			//fmt.Println(my_user_id,claims["id"])
-			UpdateContextUserModel(c, my_user_id)
+		c.Set("my_user_token", token)
+		c.Set("my_token_claims", claims)
+		err = h.UpdateContextUserModel(c, claims.UserID)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrUserDisabled):
+			if auto401 {
+				common.AbortWithError(c, common.NewAPIError(common.CodeUnauthorized, "The account is disabled").WithCause(err))
+			}
+		case errors.Is(err, common.ErrNotFound):
+			// Deleted since the token was issued
+			if auto401 {
+				common.AbortWithError(c, common.NewAPIError(common.CodeUnauthorized, "The user of the token no longer exists").WithCause(err))
+			}
+		default:
+			common.AbortWithError(c, err)
+		}
+	}
+}
+
+// Keep the users who have not confirmed their current email from the routes, like MatchRoute takes them.
+// Registered after AuthMiddleware(true) when email.require_verified is set:
+//  v1.Use(userHandler.RequireVerifiedEmail("POST /api/articles/"))
+func (h *Handler) RequireVerifiedEmail(routes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !common.MatchRoute(c, routes...) {
+			return
+		}
+		myUserModel, _ := c.MustGet("my_user_model").(UserModel)
+		if !myUserModel.EmailVerified() {
+			common.AbortWithError(c, common.NewAPIError(common.CodeEmailUnverified, "Confirm your email first"))
 		}
 	}
 }
@@ -2,9 +2,9 @@ package users
 
 import (
 	"errors"
+	"time"
+
 	"github.com/jinzhu/gorm"
-	"realworld-backend/common"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Models should only be concerned with database schema, more strict checking should be put in validator.
@@ -19,6 +19,20 @@ type UserModel struct {
 	Bio          string  `gorm:"column:bio;size:1024"`
 	Image        *string `gorm:"column:image"`
 	PasswordHash string  `gorm:"column:password;not null"`
+	// Set by `user disable`, a disabled user can neither log in nor use the tokens issued before.
+	DisabledAt *time.Time `gorm:"column:disabled_at"`
+	// The email the user confirmed owning, see EmailVerified. Changing Email leaves it behind, so the new
+	// address is unverified until confirmed without anything to reset.
+	VerifiedEmail string `gorm:"column:verified_email"`
+}
+
+func (u UserModel) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+// Whether the user confirmed owning the current email, through the link mailed to it.
+func (u UserModel) EmailVerified() bool {
+	return u.VerifiedEmail != "" && u.VerifiedEmail == u.Email
 }
 
 // A hack way to save ManyToMany relationship,
@@ -39,112 +53,128 @@ type FollowModel struct {
 	FollowedByID uint
 }
 
-// Migrate the schema of database if needed
-func AutoMigrate() {
-	db := common.GetDB()
+// A refresh token handed out with an access token, only its SHA-256 is stored.
+// Each refresh trades it for a new one of the same Family, so the family is one login of one device.
+// A token used twice means it was stolen: the whole family is revoked, see TokenStore.Use.
+type RefreshTokenModel struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"column:user_id;index"`
+	Family    string `gorm:"column:family;index"`
+	TokenHash string `gorm:"column:token_hash;unique_index"`
+	// The access token issued with it, revoked with the family.
+	AccessTokenID   string    `gorm:"column:access_token_id"`
+	AccessExpiresAt time.Time `gorm:"column:access_expires_at"`
+	CreatedAt       time.Time
+	ExpiresAt       time.Time  `gorm:"column:expires_at;index"`
+	UsedAt          *time.Time `gorm:"column:used_at"`
+	RevokedAt       *time.Time `gorm:"column:revoked_at"`
+}
+
+func (RefreshTokenModel) TableName() string {
+	return "refresh_tokens"
+}
 
+// The revocation list: the jti of the access tokens refused before they expire, kept until they do.
+type RevokedTokenModel struct {
+	TokenID   string    `gorm:"column:jti;primary_key"`
+	ExpiresAt time.Time `gorm:"column:expires_at;index"`
+}
+
+func (RevokedTokenModel) TableName() string {
+	return "revoked_tokens"
+}
+
+// A password reset link sent by email, only the SHA-256 of its token is stored.
+// It works once and before ExpiresAt, using it spends the other links of the user too.
+type PasswordResetModel struct {
+	ID        uint       `gorm:"primary_key"`
+	UserID    uint       `gorm:"column:user_id;index"`
+	TokenHash string     `gorm:"column:token_hash;unique_index"`
+	CreatedAt time.Time
+	ExpiresAt time.Time  `gorm:"column:expires_at;index"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+}
+
+func (PasswordResetModel) TableName() string {
+	return "password_resets"
+}
+
+// An email verification link, sent on sign up and on an email change, only the SHA-256 of its token is stored.
+// It confirms Email, and only while Email is still the one of the user.
+type EmailVerificationModel struct {
+	ID        uint       `gorm:"primary_key"`
+	UserID    uint       `gorm:"column:user_id;index"`
+	Email     string     `gorm:"column:email"`
+	TokenHash string     `gorm:"column:token_hash;unique_index"`
+	CreatedAt time.Time
+	ExpiresAt time.Time  `gorm:"column:expires_at;index"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+}
+
+func (EmailVerificationModel) TableName() string {
+	return "email_verifications"
+}
+
+// The outcomes of a LoginAttemptModel.
+const (
+	LoginSuccess  = "success"
+	LoginFailure  = "failure"
+	LoginDisabled = "disabled"
+	// Refused without checking the password, the account or the IP had too many failures.
+	LoginThrottled = "throttled"
+)
+
+// A login, kept for the throttling of UsersLogin and the history of the user. UserID is 0 for an unknown Email,
+// which counts the same.
+type LoginAttemptModel struct {
+	ID        uint      `gorm:"primary_key"`
+	UserID    uint      `gorm:"column:user_id;index"`
+	Email     string    `gorm:"column:email;index"`
+	IP        string    `gorm:"column:ip;index"`
+	UserAgent string    `gorm:"column:user_agent"`
+	Outcome   string    `gorm:"column:outcome"`
+	CreatedAt time.Time `gorm:"column:created_at;index"`
+}
+
+func (LoginAttemptModel) TableName() string {
+	return "login_attempts"
+}
+
+// Migrate the schema of database if needed, the server itself goes through the migrations module.
+func AutoMigrate(db *gorm.DB) {
 	db.AutoMigrate(&UserModel{})
 	db.AutoMigrate(&FollowModel{})
+	db.AutoMigrate(&RefreshTokenModel{}, &RevokedTokenModel{})
+	db.AutoMigrate(&PasswordResetModel{}, &EmailVerificationModel{})
+	db.AutoMigrate(&LoginAttemptModel{})
 }
 
-// What's bcrypt? https://en.wikipedia.org/wiki/Bcrypt
-// Golang bcrypt doc: https://godoc.org/golang.org/x/crypto/bcrypt
-// You can change the value in bcrypt.DefaultCost to adjust the security index.
-// 	err := userModel.setPassword("password0")
-func (u *UserModel) setPassword(password string) error {
+// The hash comes from Passwords, see PasswordHasher.
+// 	err := userModel.SetPassword("password0")
+func (u *UserModel) SetPassword(password string) error {
 	if len(password) == 0 {
 		return errors.New("password should not be empty!")
 	}
-	bytePassword := []byte(password)
-	// Make sure the second param `bcrypt generator cost` between [4, 32)
-	passwordHash, _ := bcrypt.GenerateFromPassword(bytePassword, bcrypt.DefaultCost)
-	u.PasswordHash = string(passwordHash)
+	passwordHash, err := Passwords.Hash(password)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = passwordHash
 	return nil
 }
 
 // Database will only save the hashed string, you should check it by util function.
-// 	if err := serModel.checkPassword("password0"); err != nil { password error }
+// A right password of a hash Passwords would not make anymore gets a new hash in PasswordHash, the caller saves it.
+// 	if err := userModel.checkPassword("password0"); err != nil { password error }
 func (u *UserModel) checkPassword(password string) error {
-	bytePassword := []byte(password)
-	byteHashedPassword := []byte(u.PasswordHash)
-	return bcrypt.CompareHashAndPassword(byteHashedPassword, bytePassword)
-}
-
-// You could input the conditions and it will return an UserModel in database with error info.
-// 	userModel, err := FindOneUser(&UserModel{Username: "username0"})
-func FindOneUser(condition interface{}) (UserModel, error) {
-	db := common.GetDB()
-	var model UserModel
-	err := db.Where(condition).First(&model).Error
-	return model, err
-}
-
-// You could input an UserModel which will be saved in database returning with error info
-// 	if err := SaveOne(&userModel); err != nil { ... }
-func SaveOne(data interface{}) error {
-	db := common.GetDB()
-	err := db.Save(data).Error
-	return err
-}
-
-// You could update properties of an UserModel to database returning with error info.
-//  err := db.Model(userModel).Update(UserModel{Username: "wangzitian0"}).Error
-func (model *UserModel) Update(data interface{}) error {
-	db := common.GetDB()
-	err := db.Model(model).Update(data).Error
-	return err
-}
-
-// You could add a following relationship as userModel1 following userModel2
-// 	err = userModel1.following(userModel2)
-func (u UserModel) following(v UserModel) error {
-	db := common.GetDB()
-	var follow FollowModel
-	err := db.FirstOrCreate(&follow, &FollowModel{
-		FollowingID:  v.ID,
-		FollowedByID: u.ID,
-	}).Error
-	return err
-}
-
-// You could check whether  userModel1 following userModel2
-// 	followingBool = myUserModel.isFollowing(self.UserModel)
-func (u UserModel) isFollowing(v UserModel) bool {
-	db := common.GetDB()
-	var follow FollowModel
-	db.Where(FollowModel{
-		FollowingID:  v.ID,
-		FollowedByID: u.ID,
-	}).First(&follow)
-	return follow.ID != 0
-}
-
-// You could delete a following relationship as userModel1 following userModel2
-// 	err = userModel1.unFollowing(userModel2)
-func (u UserModel) unFollowing(v UserModel) error {
-	db := common.GetDB()
-	err := db.Where(FollowModel{
-		FollowingID:  v.ID,
-		FollowedByID: u.ID,
-	}).Delete(FollowModel{}).Error
-	return err
-}
-
-// You could get a following list of userModel
-// 	followings := userModel.GetFollowings()
-func (u UserModel) GetFollowings() []UserModel {
-	db := common.GetDB()
-	tx := db.Begin()
-	var follows []FollowModel
-	var followings []UserModel
-	tx.Where(FollowModel{
-		FollowedByID: u.ID,
-	}).Find(&follows)
-	for _, follow := range follows {
-		var userModel UserModel
-		tx.Model(&follow).Related(&userModel, "Following")
-		followings = append(followings, userModel)
+	if err := verifyPassword(u.PasswordHash, password); err != nil {
+		return err
+	}
+	if Passwords.NeedsRehash(u.PasswordHash) {
+		// The old hash still works, a failure only postpones the upgrade to the next login
+		if passwordHash, err := Passwords.Hash(password); err == nil {
+			u.PasswordHash = passwordHash
+		}
 	}
-	tx.Commit()
-	return followings
+	return nil
 }
@@ -0,0 +1,114 @@
+package users
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"realworld-backend/config"
+)
+
+// The rules a new password has to follow on top of the 8 to 255 characters of the validators, checked on sign up,
+// on a password change and on a reset.
+type PasswordPolicy struct {
+	// In characters, not bytes
+	MinLength int
+	// How many of lower case letters, upper case letters, digits and the rest it should mix.
+	MinClasses int
+	// Refuse the passwords containing the username or the email, or contained in them.
+	RejectSimilar bool
+	// The leaked passwords to refuse, nil when there is no list.
+	Breached *BreachedList
+}
+
+// The policy of UserModelValidator and of the reset, main replaces it with the one of the configuration.
+var Policy, _ = NewPasswordPolicy(config.Default().Password)
+
+// The policy of cfg, with its breached list loaded.
+func NewPasswordPolicy(cfg config.PasswordConfig) (PasswordPolicy, error) {
+	policy := PasswordPolicy{
+		MinLength:     cfg.MinLength,
+		MinClasses:    cfg.MinClasses,
+		RejectSimilar: cfg.RejectSimilar,
+	}
+	if cfg.BreachedList != "" {
+		breached, err := LoadBreachedList(cfg.BreachedList)
+		if err != nil {
+			return policy, err
+		}
+		policy.Breached = breached
+	}
+	return policy, nil
+}
+
+// A password the policy refuses, Code and Param are the ones of the field error the client gets.
+type PolicyError struct {
+	Code  string
+	Param string
+}
+
+func (e *PolicyError) Error() string {
+	return "password refused by the policy: " + e.Code
+}
+
+// Check password against the rules, username and email are the ones it will belong to.
+func (p PasswordPolicy) Check(password, username, email string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return &PolicyError{Code: "too_short", Param: strconv.Itoa(p.MinLength)}
+	}
+	if passwordClasses(password) < p.MinClasses {
+		return &PolicyError{Code: "too_simple", Param: strconv.Itoa(p.MinClasses)}
+	}
+	if p.RejectSimilar && similarToAccount(password, username, email) {
+		return &PolicyError{Code: "similar"}
+	}
+	if p.Breached != nil && p.Breached.Contains(password) {
+		return &PolicyError{Code: "breached"}
+	}
+	return nil
+}
+
+// How many of lower case letters, upper case letters, digits and the rest password has.
+func passwordClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// The parts of an account shorter than this are too common to refuse the passwords containing them.
+const minSimilarLength = 3
+
+// Whether password contains the username, the email or one of its parts, or is contained in them,
+// whatever the case: "Jake2024" for jake, "statefarm!" for jake@statefarm.com.
+func similarToAccount(password, username, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+	parts := []string{strings.ToLower(username), email}
+	if local, domain, ok := strings.Cut(email, "@"); ok {
+		labels := strings.Split(domain, ".")
+		// Not the top level domain, a "com" in a password says nothing about the account
+		parts = append(parts, local)
+		parts = append(parts, labels[:len(labels)-1]...)
+	}
+	for _, part := range parts {
+		if len(part) < minSimilarLength {
+			continue
+		}
+		if strings.Contains(password, part) || strings.Contains(part, password) {
+			return true
+		}
+	}
+	return false
+}
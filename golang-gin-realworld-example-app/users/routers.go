@@ -1,131 +1,624 @@
 package users
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"math"
 	"realworld-backend/common"
+	"realworld-backend/config"
+	"realworld-backend/mail"
+	"realworld-backend/metrics"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
-func UsersRegister(router *gin.RouterGroup) {
-	router.POST("/", UsersRegistration)
-	router.POST("/login", UsersLogin)
+// Handler carries the dependencies of the user routes.
+// main builds one around the stores it opened and registers its routes, nothing is read from globals.
+// 	userHandler := users.NewHandler(users.NewGormUserStore(db), users.NewGormTokenStore(db))
+// 	userHandler.UsersRegister(v1.Group("/users"))
+type Handler struct {
+	Users  UserStore
+	Tokens TokenStore
+	// How long a refresh token can be traded for new tokens.
+	RefreshTokenTTL time.Duration
+	// Whether AuthMiddleware also reads the token of the access_token query parameter.
+	AllowQueryToken bool
+	// Sends the password reset links, which point to PasswordResetURL and work for PasswordResetTTL.
+	Mailer           mail.Mailer
+	PasswordResetURL string
+	PasswordResetTTL time.Duration
+	// The email confirmation links point to EmailVerifyURL and work for EmailVerifyTTL.
+	EmailVerifyURL string
+	EmailVerifyTTL time.Duration
+	// Slows down and locks out the logins after too many failures.
+	Throttle LoginThrottle
+	// Runs fn after the request answered, for the work whose duration would tell the client something: only the
+	// registered emails get a password reset mail. A goroutine of its own unless main hands it to the server.
+	Background func(fn func())
 }
 
-func UserRegister(router *gin.RouterGroup) {
-	router.GET("/", UserRetrieve)
-	router.PUT("/", UserUpdate)
+// The life of the refresh tokens and of the password reset links unless main sets others.
+const (
+	DefaultRefreshTokenTTL  = 30 * 24 * time.Hour
+	DefaultPasswordResetTTL = time.Hour
+	DefaultEmailVerifyTTL   = 24 * time.Hour
+)
+
+// The pages of the frontend the emailed links open unless main sets others.
+const (
+	DefaultPasswordResetURL = "http://localhost:4100/reset-password"
+	DefaultEmailVerifyURL   = "http://localhost:4100/verify-email"
+)
+
+// The emails are only logged until main sets a Mailer.
+func NewHandler(userStore UserStore, tokenStore TokenStore) *Handler {
+	return &Handler{
+		Users:            userStore,
+		Tokens:           tokenStore,
+		RefreshTokenTTL:  DefaultRefreshTokenTTL,
+		Mailer:           mail.LogMailer{},
+		PasswordResetURL: DefaultPasswordResetURL,
+		PasswordResetTTL: DefaultPasswordResetTTL,
+		EmailVerifyURL:   DefaultEmailVerifyURL,
+		EmailVerifyTTL:   DefaultEmailVerifyTTL,
+		Throttle:         NewLoginThrottle(config.Default().Login),
+		Background:       func(fn func()) { go fn() },
+	}
+}
+
+func (h *Handler) UsersRegister(router *gin.RouterGroup) {
+	router.POST("/", h.UsersRegistration)
+	router.POST("/login", h.UsersLogin)
+	router.POST("/refresh", h.UsersRefresh)
+	router.POST("/password/forgot", h.PasswordForgot)
+	router.POST("/password/reset", h.PasswordReset)
+	router.POST("/email/verify", h.EmailVerify)
+}
+
+// The routes of /users needing the token of the user, registered after AuthMiddleware(true).
+func (h *Handler) SessionRegister(router *gin.RouterGroup) {
+	router.POST("/logout", h.UsersLogout)
 }
 
-func ProfileRegister(router *gin.RouterGroup) {
-	router.GET("/:username", ProfileRetrieve)
-	router.POST("/:username/follow", ProfileFollow)
-	router.DELETE("/:username/follow", ProfileUnfollow)
+func (h *Handler) UserRegister(router *gin.RouterGroup) {
+	router.GET("/", h.UserRetrieve)
+	router.PUT("/", h.UserUpdate)
+	router.POST("/email/verification", h.EmailVerificationResend)
+	router.GET("/logins", h.UserLogins)
 }
 
-func ProfileRetrieve(c *gin.Context) {
+func (h *Handler) ProfileRegister(router *gin.RouterGroup) {
+	router.GET("/:username", h.ProfileRetrieve)
+	router.POST("/:username/follow", h.ProfileFollow)
+	router.DELETE("/:username/follow", h.ProfileUnfollow)
+}
+
+func (h *Handler) ProfileRetrieve(c *gin.Context) {
 	username := c.Param("username")
-	userModel, err := FindOneUser(&UserModel{Username: username})
+	userModel, err := h.Users.FindOne(c.Request.Context(), UserModel{Username: username})
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
+		common.AbortWithError(c, common.StoreAPIError(err, "profile"))
 		return
 	}
-	profileSerializer := ProfileSerializer{c, userModel}
+	profileSerializer := ProfileSerializer{c, h.Users, userModel}
 	c.JSON(http.StatusOK, gin.H{"profile": profileSerializer.Response()})
 }
 
-func ProfileFollow(c *gin.Context) {
+func (h *Handler) ProfileFollow(c *gin.Context) {
 	username := c.Param("username")
-	userModel, err := FindOneUser(&UserModel{Username: username})
+	userModel, err := h.Users.FindOne(c.Request.Context(), UserModel{Username: username})
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
+		common.AbortWithError(c, common.StoreAPIError(err, "profile"))
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(UserModel)
-	err = myUserModel.following(userModel)
+	err = h.Users.Follow(c.Request.Context(), myUserModel, userModel)
 	if err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		common.AbortWithError(c, common.StoreAPIError(err, "profile"))
 		return
 	}
-	serializer := ProfileSerializer{c, userModel}
+	serializer := ProfileSerializer{c, h.Users, userModel}
 	c.JSON(http.StatusOK, gin.H{"profile": serializer.Response()})
 }
 
-func ProfileUnfollow(c *gin.Context) {
+func (h *Handler) ProfileUnfollow(c *gin.Context) {
 	username := c.Param("username")
-	userModel, err := FindOneUser(&UserModel{Username: username})
+	userModel, err := h.Users.FindOne(c.Request.Context(), UserModel{Username: username})
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
+		common.AbortWithError(c, common.StoreAPIError(err, "profile"))
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(UserModel)
 
-	err = myUserModel.unFollowing(userModel)
+	err = h.Users.Unfollow(c.Request.Context(), myUserModel, userModel)
 	if err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		common.AbortWithError(c, common.StoreAPIError(err, "profile"))
 		return
 	}
-	serializer := ProfileSerializer{c, userModel}
+	serializer := ProfileSerializer{c, h.Users, userModel}
 	c.JSON(http.StatusOK, gin.H{"profile": serializer.Response()})
 }
 
-func UsersRegistration(c *gin.Context) {
+func (h *Handler) UsersRegistration(c *gin.Context) {
 	userModelValidator := NewUserModelValidator()
 	if err := userModelValidator.Bind(c); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		common.AbortWithError(c, common.ValidationError(err))
 		return
 	}
 
-	if err := SaveOne(&userModelValidator.userModel); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+	if err := h.Users.Create(c.Request.Context(), &userModelValidator.userModel); err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "user"))
 		return
 	}
+	metrics.Registrations.Inc()
+	// The account exists whatever happens to the link, the user can ask for another one.
+	if err := h.sendVerification(c, userModelValidator.userModel); err != nil {
+		common.Logger(c.Request.Context()).Error("email verification not sent", "user_id", userModelValidator.userModel.ID, "error", err.Error())
+	}
 	c.Set("my_user_model", userModelValidator.userModel)
+	if err := h.issueTokens(c, userModelValidator.userModel.ID, ""); err != nil {
+		common.AbortWithError(c, err)
+		return
+	}
 	serializer := UserSerializer{c}
-	c.JSON(http.StatusCreated, gin.H{"user": serializer.Response()})
+	c.JSON(http.StatusCreated, gin.H{"user": serializer.SessionResponse()})
 }
 
-func UsersLogin(c *gin.Context) {
+func (h *Handler) UsersLogin(c *gin.Context) {
 	loginValidator := NewLoginValidator()
 	if err := loginValidator.Bind(c); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		common.AbortWithError(c, common.ValidationError(err))
 		return
 	}
-	userModel, err := FindOneUser(&UserModel{Email: loginValidator.userModel.Email})
+	ctx := c.Request.Context()
+	userModel, err := h.Users.FindOne(ctx, UserModel{Email: loginValidator.userModel.Email})
+	if err != nil && !errors.Is(err, common.ErrNotFound) {
+		common.AbortWithError(c, err)
+		return
+	}
+	attempt := LoginAttemptModel{
+		UserID:    userModel.ID,
+		Email:     strings.ToLower(loginValidator.userModel.Email),
+		IP:        c.ClientIP(),
+		UserAgent: truncate(c.Request.UserAgent(), maxUserAgentLength),
+		CreatedAt: time.Now(),
+	}
+	wait, throttleErr := h.Throttle.Wait(ctx, h.Tokens, attempt.Email, attempt.IP, attempt.CreatedAt)
+	if throttleErr != nil {
+		common.AbortWithError(c, throttleErr)
+		return
+	}
+	// Before the password is checked, the right one waits like the wrong ones.
+	if wait > 0 {
+		h.recordLogin(c, attempt, LoginThrottled)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		common.AbortWithError(c, common.NewAPIError(common.CodeRateLimited, "Too many failed logins, retry later"))
+		return
+	}
+
+	passwordHash := userModel.PasswordHash
+	// An unknown email and a wrong password answer the same, not to tell which emails are registered. The
+	// unknown one pays for a hash check all the same, or the time of the answer would tell.
+	if err != nil {
+		if hash, hashErr := dummyPasswordHash(); hashErr != nil {
+			common.Logger(ctx).Error("dummy password hash not made", "error", hashErr.Error())
+		} else {
+			verifyPassword(hash, loginValidator.User.Password)
+		}
+	}
+	if err != nil || userModel.checkPassword(loginValidator.User.Password) != nil {
+		h.recordLogin(c, attempt, LoginFailure)
+		common.AbortWithError(c, common.NewAPIError(common.CodeInvalidCredentials, "Not Registered email or invalid password"))
+		return
+	}
+	if userModel.Disabled() {
+		h.recordLogin(c, attempt, LoginDisabled)
+		common.AbortWithError(c, common.NewAPIError(common.CodeForbidden, "The account is disabled"))
+		return
+	}
+	if userModel.PasswordHash != passwordHash {
+		// checkPassword rehashed it with the current hasher, the login works on with the old hash if the save fails
+		if err := h.Users.Update(ctx, &userModel, UserModel{PasswordHash: userModel.PasswordHash}); err != nil {
+			common.Logger(ctx).Error("password rehash not saved", "user_id", userModel.ID, "error", err.Error())
+		}
+	}
+	if err := h.UpdateContextUserModel(c, userModel.ID); err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "user"))
+		return
+	}
+	if err := h.issueTokens(c, userModel.ID, ""); err != nil {
+		common.AbortWithError(c, err)
+		return
+	}
+	h.recordLogin(c, attempt, LoginSuccess)
+	serializer := UserSerializer{c}
+	c.JSON(http.StatusOK, gin.H{"user": serializer.SessionResponse()})
+}
+
+// The user agents are cut to fit their column.
+const maxUserAgentLength = 255
+
+// The first n bytes of s at most, without cutting a character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Save attempt with its outcome and count it. A login answers the same whether it was saved or not, only the
+// throttling misses it.
+func (h *Handler) recordLogin(c *gin.Context, attempt LoginAttemptModel, outcome string) {
+	attempt.Outcome = outcome
+	metrics.Logins.WithLabelValues(outcome).Inc()
+	if err := h.Tokens.CreateLoginAttempt(c.Request.Context(), &attempt); err != nil {
+		common.Logger(c.Request.Context()).Error("login attempt not saved", "outcome", outcome, "error", err.Error())
+	}
+}
+
+// The login history of the user shows this many logins unless ?limit= asks for others, up to maxLoginsLimit.
+const (
+	defaultLoginsLimit = 20
+	maxLoginsLimit     = 100
+)
 
+// The logins of the user, newest first, the failed ones and the refused ones included.
+func (h *Handler) UserLogins(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultLoginsLimit
+	}
+	limit = min(limit, maxLoginsLimit)
+	attempts, err := h.Tokens.LoginAttempts(c.Request.Context(), LoginAttemptModel{UserID: myUserModel.ID}, time.Time{}, limit)
 	if err != nil {
-		c.JSON(http.StatusForbidden, common.NewError("login", errors.New("Not Registered email or invalid password")))
+		common.AbortWithError(c, err)
 		return
 	}
+	serializer := LoginAttemptsSerializer{attempts}
+	c.JSON(http.StatusOK, gin.H{"logins": serializer.Response()})
+}
 
-	if userModel.checkPassword(loginValidator.User.Password) != nil {
-		c.JSON(http.StatusForbidden, common.NewError("login", errors.New("Not Registered email or invalid password")))
+// The SHA-256 of a refresh token, what the store keeps of it.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue an access token and a refresh token of family to the user, a new family starts a session.
+// They are put in the context for UserSerializer.SessionResponse.
+func (h *Handler) issueTokens(c *gin.Context, userID uint, family string) error {
+	if family == "" {
+		family = common.RandToken(16)
+	}
+	access, err := common.GenAccessToken(userID, family)
+	if err != nil {
+		return err
+	}
+	refresh := common.RandToken(32)
+	err = h.Tokens.Create(c.Request.Context(), &RefreshTokenModel{
+		UserID:          userID,
+		Family:          family,
+		TokenHash:       hashToken(refresh),
+		AccessTokenID:   access.ID,
+		AccessExpiresAt: access.ExpiresAt,
+		ExpiresAt:       time.Now().Add(h.RefreshTokenTTL),
+	})
+	if err != nil {
+		return err
+	}
+	c.Set("my_user_token", access.Token)
+	c.Set("my_refresh_token", refresh)
+	return nil
+}
+
+// Trade a refresh token for a new access token and a new refresh token, the old one cannot be used again.
+func (h *Handler) UsersRefresh(c *gin.Context) {
+	refreshValidator := NewRefreshValidator()
+	if err := refreshValidator.Bind(c); err != nil {
+		common.AbortWithError(c, common.ValidationError(err))
+		return
+	}
+	ctx := c.Request.Context()
+	invalid := common.NewAPIError(common.CodeUnauthorized, "The refresh token is invalid or expired")
+	token, err := h.Tokens.FindByHash(ctx, hashToken(refreshValidator.RefreshToken))
+	if err != nil && !errors.Is(err, common.ErrNotFound) {
+		common.AbortWithError(c, err)
+		return
+	}
+	now := time.Now()
+	if err != nil || !token.ExpiresAt.After(now) {
+		metrics.Refreshes.WithLabelValues("invalid").Inc()
+		common.AbortWithError(c, invalid)
+		return
+	}
+	if err := h.Tokens.Use(ctx, &token, now); err != nil {
+		if !errors.Is(err, ErrRefreshTokenReused) {
+			common.AbortWithError(c, err)
+			return
+		}
+		// The client and a thief both had it, and the server cannot tell which one came second:
+		// the session ends for both, its last access token included.
+		if err := h.Tokens.RevokeFamily(ctx, token.Family, now); err != nil {
+			common.AbortWithError(c, err)
+			return
+		}
+		metrics.Refreshes.WithLabelValues("reused").Inc()
+		common.Logger(ctx).Warn("refresh token reused, session revoked", "user_id", token.UserID)
+		common.AbortWithError(c, common.NewAPIError(common.CodeUnauthorized, "The refresh token was already used, the session is revoked"))
+		return
+	}
+	err = h.UpdateContextUserModel(c, token.UserID)
+	if errors.Is(err, ErrUserDisabled) {
+		common.AbortWithError(c, common.NewAPIError(common.CodeUnauthorized, "The account is disabled").WithCause(err))
+		return
+	}
+	if err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "user"))
+		return
+	}
+	if err := h.issueTokens(c, token.UserID, token.Family); err != nil {
+		common.AbortWithError(c, err)
 		return
 	}
-	UpdateContextUserModel(c, userModel.ID)
+	metrics.Refreshes.WithLabelValues("success").Inc()
 	serializer := UserSerializer{c}
-	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
+	c.JSON(http.StatusOK, gin.H{"user": serializer.SessionResponse()})
 }
 
-func UserRetrieve(c *gin.Context) {
+// Revoke the access token of the request and the session it belongs to, its refresh tokens stop working.
+func (h *Handler) UsersLogout(c *gin.Context) {
+	claims := c.MustGet("my_token_claims").(*common.Claims)
+	ctx := c.Request.Context()
+	if err := h.Tokens.Revoke(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+		common.AbortWithError(c, err)
+		return
+	}
+	if claims.SessionID != "" {
+		if err := h.Tokens.RevokeFamily(ctx, claims.SessionID, time.Now()); err != nil {
+			common.AbortWithError(c, err)
+			return
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) UserRetrieve(c *gin.Context) {
 	serializer := UserSerializer{c}
 	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
 }
 
-func UserUpdate(c *gin.Context) {
+func (h *Handler) UserUpdate(c *gin.Context) {
 	myUserModel := c.MustGet("my_user_model").(UserModel)
 	userModelValidator := NewUserModelValidatorFillWith(myUserModel)
 	if err := userModelValidator.Bind(c); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		common.AbortWithError(c, common.ValidationError(err))
 		return
 	}
 
 	userModelValidator.userModel.ID = myUserModel.ID
-	if err := myUserModel.Update(userModelValidator.userModel); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+	oldEmail := myUserModel.Email
+	if err := h.Users.Update(c.Request.Context(), &myUserModel, userModelValidator.userModel); err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "user"))
+		return
+	}
+	if err := h.UpdateContextUserModel(c, myUserModel.ID); err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "user"))
 		return
 	}
-	UpdateContextUserModel(c, myUserModel.ID)
+	// The new email is unverified until its owner follows the link, the old one may not be theirs anymore.
+	updated := c.MustGet("my_user_model").(UserModel)
+	if updated.Email != oldEmail {
+		if err := h.sendVerification(c, updated); err != nil {
+			common.Logger(c.Request.Context()).Error("email verification not sent", "user_id", updated.ID, "error", err.Error())
+		}
+	}
 	serializer := UserSerializer{c}
 	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
 }
+
+// Email a password reset link to the user of the email. The answer is the same whether the email is registered
+// or not, not to tell which ones are, and the mail failures are only logged for the same reason.
+func (h *Handler) PasswordForgot(c *gin.Context) {
+	forgotValidator := NewPasswordForgotValidator()
+	if err := forgotValidator.Bind(c); err != nil {
+		common.AbortWithError(c, common.ValidationError(err))
+		return
+	}
+	ctx := c.Request.Context()
+	userModel, err := h.Users.FindOne(ctx, UserModel{Email: forgotValidator.User.Email})
+	if err != nil && !errors.Is(err, common.ErrNotFound) {
+		common.AbortWithError(c, err)
+		return
+	}
+	if err == nil && !userModel.Disabled() {
+		// Off the request, an unknown email would answer sooner than a registered one otherwise. The context keeps
+		// the values of the request for the logs, not its end.
+		detached := context.WithoutCancel(ctx)
+		h.Background(func() { h.sendPasswordReset(detached, userModel) })
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// Save a reset for the user and mail its link, the failures are only logged: the client got its answer already.
+func (h *Handler) sendPasswordReset(ctx context.Context, userModel UserModel) {
+	token := common.RandToken(32)
+	reset := PasswordResetModel{
+		UserID:    userModel.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(h.PasswordResetTTL),
+	}
+	if err := h.Tokens.CreateReset(ctx, &reset); err != nil {
+		common.Logger(ctx).Error("password reset not saved", "user_id", userModel.ID, "error", err.Error())
+		return
+	}
+	mail.SendLogged(ctx, h.Mailer, h.passwordResetMail(userModel, token))
+	metrics.PasswordResets.WithLabelValues("requested").Inc()
+}
+
+func (h *Handler) passwordResetMail(userModel UserModel, token string) mail.Message {
+	link, _ := url.Parse(h.PasswordResetURL)
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return mail.Message{
+		To:      userModel.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password of your account. Follow this link within %d minutes to choose a new one:\n\n"+
+			"%s\n\n"+
+			"If it was not you, ignore this email and your password stays the same.\n",
+			userModel.Username, int(h.PasswordResetTTL.Minutes()), link),
+	}
+}
+
+// Set the password of the user of a reset token, once. Every session of the user ends with it: whoever had
+// the old password is logged out.
+func (h *Handler) PasswordReset(c *gin.Context) {
+	resetValidator := NewPasswordResetValidator()
+	if err := resetValidator.Bind(c); err != nil {
+		common.AbortWithError(c, common.ValidationError(err))
+		return
+	}
+	ctx := c.Request.Context()
+	now := time.Now()
+	invalid := common.NewAPIError(common.CodeUnauthorized, "The reset token is invalid or expired")
+	tokenHash := hashToken(resetValidator.User.Token)
+	// Only found first, a password the policy or the hasher refuses should not spend the token
+	reset, err := h.Tokens.FindReset(ctx, tokenHash, now)
+	if errors.Is(err, common.ErrNotFound) {
+		metrics.PasswordResets.WithLabelValues("invalid").Inc()
+		common.AbortWithError(c, invalid)
+		return
+	}
+	if err != nil {
+		common.AbortWithError(c, err)
+		return
+	}
+	userModel, err := h.Users.FindOne(ctx, UserModel{ID: reset.UserID})
+	if err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "user"))
+		return
+	}
+	if userModel.Disabled() {
+		common.AbortWithError(c, common.NewAPIError(common.CodeForbidden, "The account is disabled"))
+		return
+	}
+	if err := Policy.Check(resetValidator.User.Password, userModel.Username, userModel.Email); err != nil {
+		common.AbortWithError(c, passwordError("user.password", err))
+		return
+	}
+	var data UserModel
+	if err := data.SetPassword(resetValidator.User.Password); err != nil {
+		common.AbortWithError(c, passwordError("user.password", err))
+		return
+	}
+	// A reset racing this one may have used it since; a failure changes nothing and leaves the token usable
+	if err := h.Tokens.CompleteReset(ctx, tokenHash, userModel.ID, data.PasswordHash, now); err != nil {
+		if errors.Is(err, common.ErrNotFound) {
+			metrics.PasswordResets.WithLabelValues("invalid").Inc()
+			err = invalid
+		}
+		common.AbortWithError(c, err)
+		return
+	}
+	metrics.PasswordResets.WithLabelValues("completed").Inc()
+	common.Logger(ctx).Info("password reset, sessions revoked", "user_id", userModel.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// Email a confirmation link for the current email of the user. The mail failures are only logged, the user
+// can ask for another link, the store failures are returned.
+func (h *Handler) sendVerification(c *gin.Context, userModel UserModel) error {
+	ctx := c.Request.Context()
+	token := common.RandToken(32)
+	verification := EmailVerificationModel{
+		UserID:    userModel.ID,
+		Email:     userModel.Email,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(h.EmailVerifyTTL),
+	}
+	if err := h.Tokens.CreateVerification(ctx, &verification); err != nil {
+		return err
+	}
+	mail.SendLogged(ctx, h.Mailer, h.emailVerificationMail(userModel, token))
+	metrics.EmailVerifications.WithLabelValues("sent").Inc()
+	return nil
+}
+
+func (h *Handler) emailVerificationMail(userModel UserModel, token string) mail.Message {
+	link, _ := url.Parse(h.EmailVerifyURL)
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return mail.Message{
+		To:      userModel.Email,
+		Subject: "Confirm your email",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Follow this link within %d hours to confirm that %s is your email:\n\n"+
+			"%s\n\n"+
+			"If you did not sign up, ignore this email.\n",
+			userModel.Username, int(h.EmailVerifyTTL.Hours()), userModel.Email, link),
+	}
+}
+
+// Mark the email of a verification token as verified, once. A token sent for an email the user changed since
+// is refused, it would confirm the new one.
+func (h *Handler) EmailVerify(c *gin.Context) {
+	verifyValidator := NewEmailVerifyValidator()
+	if err := verifyValidator.Bind(c); err != nil {
+		common.AbortWithError(c, common.ValidationError(err))
+		return
+	}
+	ctx := c.Request.Context()
+	invalid := common.NewAPIError(common.CodeUnauthorized, "The verification token is invalid or expired")
+	verification, err := h.Tokens.UseVerification(ctx, hashToken(verifyValidator.User.Token), time.Now())
+	if err != nil && !errors.Is(err, common.ErrNotFound) {
+		common.AbortWithError(c, err)
+		return
+	}
+	var userModel UserModel
+	if err == nil {
+		userModel, err = h.Users.FindOne(ctx, UserModel{ID: verification.UserID})
+		if err != nil && !errors.Is(err, common.ErrNotFound) {
+			common.AbortWithError(c, err)
+			return
+		}
+	}
+	if err != nil || userModel.Email != verification.Email {
+		metrics.EmailVerifications.WithLabelValues("invalid").Inc()
+		common.AbortWithError(c, invalid)
+		return
+	}
+	if err := h.Users.Update(ctx, &userModel, UserModel{VerifiedEmail: verification.Email}); err != nil {
+		common.AbortWithError(c, common.StoreAPIError(err, "user"))
+		return
+	}
+	metrics.EmailVerifications.WithLabelValues("confirmed").Inc()
+	c.Status(http.StatusNoContent)
+}
+
+// Email another confirmation link to the user of the token, when the first one expired or got lost.
+func (h *Handler) EmailVerificationResend(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	if myUserModel.EmailVerified() {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if err := h.sendVerification(c, myUserModel); err != nil {
+		common.AbortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
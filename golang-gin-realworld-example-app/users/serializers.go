@@ -2,12 +2,11 @@ package users
 
 import (
 	"github.com/gin-gonic/gin"
-
-	"realworld-backend/common"
 )
 
 type ProfileSerializer struct {
-	C *gin.Context
+	C     *gin.Context
+	Users UserStore
 	UserModel
 }
 
@@ -23,12 +22,13 @@ type ProfileResponse struct {
 // Put your response logic including wrap the userModel here.
 func (self *ProfileSerializer) Response() ProfileResponse {
 	myUserModel := self.C.MustGet("my_user_model").(UserModel)
+	following, _ := self.Users.IsFollowing(self.C.Request.Context(), myUserModel, self.UserModel)
 	profile := ProfileResponse{
 		ID:        self.ID,
 		Username:  self.Username,
 		Bio:       self.Bio,
 		Image:     self.Image,
-		Following: myUserModel.isFollowing(self.UserModel),
+		Following: following,
 	}
 	return profile
 }
@@ -42,17 +42,59 @@ type UserResponse struct {
 	Email    string  `json:"email"`
 	Bio      string  `json:"bio"`
 	Image    *string `json:"image"`
-	Token    string  `json:"token"`
+	// Whether the user confirmed the email above, see EmailVerify.
+	EmailVerified bool   `json:"emailVerified"`
+	Token         string `json:"token"`
 }
 
+// The token is the one the request came with, or the one just issued by a login, never a new one.
 func (self *UserSerializer) Response() UserResponse {
 	myUserModel := self.c.MustGet("my_user_model").(UserModel)
 	user := UserResponse{
-		Username: myUserModel.Username,
-		Email:    myUserModel.Email,
-		Bio:      myUserModel.Bio,
-		Image:    myUserModel.Image,
-		Token:    common.GenToken(myUserModel.ID),
+		Username:      myUserModel.Username,
+		Email:         myUserModel.Email,
+		Bio:           myUserModel.Bio,
+		Image:         myUserModel.Image,
+		EmailVerified: myUserModel.EmailVerified(),
+		Token:         self.c.GetString("my_user_token"),
 	}
 	return user
 }
+
+// The user of a registration, login or refresh, with the refresh token trading for the next access token.
+type SessionResponse struct {
+	UserResponse
+	RefreshToken string `json:"refreshToken"`
+}
+
+func (self *UserSerializer) SessionResponse() SessionResponse {
+	return SessionResponse{
+		UserResponse: self.Response(),
+		RefreshToken: self.c.GetString("my_refresh_token"),
+	}
+}
+
+type LoginAttemptsSerializer struct {
+	Attempts []LoginAttemptModel
+}
+
+// A login of the user, Outcome being success, failure, disabled or throttled.
+type LoginAttemptResponse struct {
+	CreatedAt string `json:"createdAt"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	Outcome   string `json:"outcome"`
+}
+
+func (s *LoginAttemptsSerializer) Response() []LoginAttemptResponse {
+	response := []LoginAttemptResponse{}
+	for _, attempt := range s.Attempts {
+		response = append(response, LoginAttemptResponse{
+			CreatedAt: attempt.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+			IP:        attempt.IP,
+			UserAgent: attempt.UserAgent,
+			Outcome:   attempt.Outcome,
+		})
+	}
+	return response
+}
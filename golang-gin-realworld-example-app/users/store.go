@@ -0,0 +1,340 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"realworld-backend/common"
+)
+
+// Everything the user handlers need from the database.
+// Routers receive an implementation instead of reaching for common.GetDB(), main wires the gorm one
+// and the tests can use the in-memory one. Lookups that match nothing return common.ErrNotFound.
+// Every method takes the request context, the SQL it runs is cancelled with it.
+type UserStore interface {
+	// Find the user matching the non zero ID, Username and Email of condition.
+	// 	userModel, err := store.FindOne(c.Request.Context(), UserModel{Username: "username0"})
+	FindOne(ctx context.Context, condition UserModel) (UserModel, error)
+	// Insert a new user and fill its ID, a taken email gives a common.DuplicateError.
+	Create(ctx context.Context, user *UserModel) error
+	// Save the non zero fields of data into user, both in the database and in the struct.
+	Update(ctx context.Context, user *UserModel, data UserModel) error
+	// Record that follower follows followed, following twice is not an error.
+	Follow(ctx context.Context, follower UserModel, followed UserModel) error
+	Unfollow(ctx context.Context, follower UserModel, followed UserModel) error
+	// Whether follower follows followed, always false for the anonymous user.
+	IsFollowing(ctx context.Context, follower UserModel, followed UserModel) (bool, error)
+	// The users followed by user, in the order they were followed.
+	Followings(ctx context.Context, user UserModel) ([]UserModel, error)
+}
+
+type gormUserStore struct {
+	db *gorm.DB
+}
+
+func NewGormUserStore(db *gorm.DB) UserStore {
+	return &gormUserStore{db: db}
+}
+
+// Only the ID, Username and Email are used, an empty condition would otherwise match the first user.
+func userCondition(condition UserModel) (UserModel, bool) {
+	condition = UserModel{ID: condition.ID, Username: condition.Username, Email: condition.Email}
+	return condition, condition != UserModel{}
+}
+
+func (s *gormUserStore) FindOne(ctx context.Context, condition UserModel) (UserModel, error) {
+	var model UserModel
+	condition, ok := userCondition(condition)
+	if !ok {
+		return model, common.ErrNotFound
+	}
+	err := common.WithContext(ctx, s.db).Where(&condition).First(&model).Error
+	return model, common.StoreError(err)
+}
+
+func (s *gormUserStore) Create(ctx context.Context, user *UserModel) error {
+	return common.StoreError(common.WithContext(ctx, s.db).Create(user).Error)
+}
+
+func (s *gormUserStore) Update(ctx context.Context, user *UserModel, data UserModel) error {
+	return common.StoreError(common.WithContext(ctx, s.db).Model(user).Update(data).Error)
+}
+
+// A hack way to save ManyToMany relationship, see FollowModel.
+func (s *gormUserStore) Follow(ctx context.Context, follower UserModel, followed UserModel) error {
+	var follow FollowModel
+	return common.WithContext(ctx, s.db).FirstOrCreate(&follow, &FollowModel{
+		FollowingID:  followed.ID,
+		FollowedByID: follower.ID,
+	}).Error
+}
+
+func (s *gormUserStore) Unfollow(ctx context.Context, follower UserModel, followed UserModel) error {
+	return common.WithContext(ctx, s.db).Where(FollowModel{
+		FollowingID:  followed.ID,
+		FollowedByID: follower.ID,
+	}).Delete(FollowModel{}).Error
+}
+
+func (s *gormUserStore) IsFollowing(ctx context.Context, follower UserModel, followed UserModel) (bool, error) {
+	if follower.ID == 0 || followed.ID == 0 {
+		return false, nil
+	}
+	var count int
+	err := common.WithContext(ctx, s.db).Model(&FollowModel{}).Where(FollowModel{
+		FollowingID:  followed.ID,
+		FollowedByID: follower.ID,
+	}).Count(&count).Error
+	return count > 0, err
+}
+
+// Unfollow soft deletes the FollowModel, so the deleted rows are skipped in the join.
+func (s *gormUserStore) Followings(ctx context.Context, user UserModel) ([]UserModel, error) {
+	var followings []UserModel
+	err := common.WithContext(ctx, s.db).
+		Joins("JOIN follow_models ON follow_models.following_id = user_models.id AND follow_models.deleted_at IS NULL").
+		Where("follow_models.followed_by_id = ?", user.ID).
+		Order("follow_models.id").
+		Find(&followings).Error
+	return followings, err
+}
+
+// Returned by TokenStore.Use for a refresh token that was used or revoked before.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// The refresh tokens and the revocation list of the access tokens, see RefreshTokenModel, with the emailed tokens
+// and the logins.
+type TokenStore interface {
+	// Save a new refresh token and fill its ID.
+	Create(ctx context.Context, token *RefreshTokenModel) error
+	// Find the refresh token whose SHA-256 is hash, whatever its state.
+	FindByHash(ctx context.Context, hash string) (RefreshTokenModel, error)
+	// Mark token used at now, once: ErrRefreshTokenReused if it was used or revoked already.
+	// Two refreshes racing with the same token cannot both succeed.
+	Use(ctx context.Context, token *RefreshTokenModel, now time.Time) error
+	// Revoke the refresh tokens of family, and put their access tokens on the revocation list.
+	RevokeFamily(ctx context.Context, family string, now time.Time) error
+	// Revoke every session of the user, like RevokeFamily does for one.
+	RevokeUser(ctx context.Context, userID uint, now time.Time) error
+	// Put the access token jti on the revocation list until it expires, revoking it twice is not an error.
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	// Save a new password reset and fill its ID.
+	CreateReset(ctx context.Context, reset *PasswordResetModel) error
+	// Spend the password reset whose SHA-256 is hash, and the other ones of userID with it, set the password
+	// of userID to passwordHash and revoke every session of the user, all of it or nothing. common.ErrNotFound
+	// when the reset is unknown, used, expired at now or of another user, or the user is gone; two resets
+	// racing cannot both succeed.
+	CompleteReset(ctx context.Context, hash string, userID uint, passwordHash string, now time.Time) error
+	// The reset whose SHA-256 is hash without using it: common.ErrNotFound when it is unknown, used or expired at now.
+	FindReset(ctx context.Context, hash string, now time.Time) (PasswordResetModel, error)
+	// Save a new email verification and fill its ID.
+	CreateVerification(ctx context.Context, verification *EmailVerificationModel) error
+	// Spend the email verification whose SHA-256 is hash, once: common.ErrNotFound when it is unknown, used
+	// or expired at now.
+	UseVerification(ctx context.Context, hash string, now time.Time) (EmailVerificationModel, error)
+	// Save a login and fill its ID, and its CreatedAt when zero.
+	CreateLoginAttempt(ctx context.Context, attempt *LoginAttemptModel) error
+	// The logins made after since matching the non zero UserID, Email, IP and Outcome of where, newest first,
+	// limit of them at most.
+	LoginAttempts(ctx context.Context, where LoginAttemptModel, since time.Time, limit int) ([]LoginAttemptModel, error)
+	// Forget the refresh tokens, the revocations and the emailed tokens expired at now, and the logins older
+	// than LoginAttemptRetention.
+	DeleteExpired(ctx context.Context, now time.Time) error
+}
+
+// How long the logins are kept.
+const LoginAttemptRetention = 90 * 24 * time.Hour
+
+type gormTokenStore struct {
+	db *gorm.DB
+}
+
+func NewGormTokenStore(db *gorm.DB) TokenStore {
+	return &gormTokenStore{db: db}
+}
+
+func (s *gormTokenStore) Create(ctx context.Context, token *RefreshTokenModel) error {
+	return common.StoreError(common.WithContext(ctx, s.db).Create(token).Error)
+}
+
+func (s *gormTokenStore) FindByHash(ctx context.Context, hash string) (RefreshTokenModel, error) {
+	var model RefreshTokenModel
+	if hash == "" {
+		return model, common.ErrNotFound
+	}
+	err := common.WithContext(ctx, s.db).Where("token_hash = ?", hash).First(&model).Error
+	return model, common.StoreError(err)
+}
+
+func (s *gormTokenStore) Use(ctx context.Context, token *RefreshTokenModel, now time.Time) error {
+	res := common.WithContext(ctx, s.db).Model(&RefreshTokenModel{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", token.ID).
+		Update("used_at", now)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrRefreshTokenReused
+	}
+	token.UsedAt = &now
+	return nil
+}
+
+func (s *gormTokenStore) RevokeFamily(ctx context.Context, family string, now time.Time) error {
+	return s.revokeSessions(ctx, "family = ?", family, now)
+}
+
+func (s *gormTokenStore) RevokeUser(ctx context.Context, userID uint, now time.Time) error {
+	return s.revokeSessions(ctx, "user_id = ?", userID, now)
+}
+
+// Revoke the refresh tokens matching where and their live access tokens, in one transaction.
+func (s *gormTokenStore) revokeSessions(ctx context.Context, where string, arg interface{}, now time.Time) error {
+	tx := common.WithContext(ctx, s.db).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := revokeSessionsIn(tx, where, arg, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// The work of revokeSessions in the transaction tx, which the caller rolls back on an error.
+func revokeSessionsIn(tx *gorm.DB, where string, arg interface{}, now time.Time) error {
+	var tokens []RefreshTokenModel
+	if err := tx.Where(where, arg).Where("access_expires_at > ?", now).Find(&tokens).Error; err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := revoke(tx, token.AccessTokenID, token.AccessExpiresAt); err != nil {
+			return err
+		}
+	}
+	return tx.Model(&RefreshTokenModel{}).Where(where, arg).Where("revoked_at IS NULL").Update("revoked_at", now).Error
+}
+
+func revoke(db *gorm.DB, jti string, expiresAt time.Time) error {
+	var model RevokedTokenModel
+	return db.Where(RevokedTokenModel{TokenID: jti}).Attrs(RevokedTokenModel{ExpiresAt: expiresAt}).FirstOrCreate(&model).Error
+}
+
+func (s *gormTokenStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	return revoke(common.WithContext(ctx, s.db), jti, expiresAt)
+}
+
+func (s *gormTokenStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int
+	err := common.WithContext(ctx, s.db).Model(&RevokedTokenModel{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *gormTokenStore) DeleteExpired(ctx context.Context, now time.Time) error {
+	db := common.WithContext(ctx, s.db)
+	if err := db.Where("expires_at <= ?", now).Delete(&RefreshTokenModel{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("expires_at <= ?", now).Delete(&RevokedTokenModel{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("expires_at <= ?", now).Delete(&PasswordResetModel{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("expires_at <= ?", now).Delete(&EmailVerificationModel{}).Error; err != nil {
+		return err
+	}
+	return db.Where("created_at <= ?", now.Add(-LoginAttemptRetention)).Delete(&LoginAttemptModel{}).Error
+}
+
+func (s *gormTokenStore) CreateReset(ctx context.Context, reset *PasswordResetModel) error {
+	return common.StoreError(common.WithContext(ctx, s.db).Create(reset).Error)
+}
+
+func (s *gormTokenStore) CompleteReset(ctx context.Context, hash string, userID uint, passwordHash string, now time.Time) error {
+	if hash == "" {
+		return common.ErrNotFound
+	}
+	tx := common.WithContext(ctx, s.db).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	// Conditional, so that a reset racing this one finds nothing left to update.
+	res := tx.Model(&PasswordResetModel{}).
+		Where("token_hash = ? AND user_id = ? AND used_at IS NULL AND expires_at > ?", hash, userID, now).
+		Update("used_at", now)
+	if res.Error != nil || res.RowsAffected == 0 {
+		tx.Rollback()
+		if res.Error != nil {
+			return res.Error
+		}
+		return common.ErrNotFound
+	}
+	err := tx.Model(&PasswordResetModel{}).Where("user_id = ? AND used_at IS NULL", userID).Update("used_at", now).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	res = tx.Model(&UserModel{}).Where("id = ?", userID).Update("password", passwordHash)
+	if res.Error != nil || res.RowsAffected == 0 {
+		tx.Rollback()
+		if res.Error != nil {
+			return res.Error
+		}
+		return common.ErrNotFound
+	}
+	if err := revokeSessionsIn(tx, "user_id = ?", userID, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *gormTokenStore) FindReset(ctx context.Context, hash string, now time.Time) (PasswordResetModel, error) {
+	var reset PasswordResetModel
+	if hash == "" {
+		return reset, common.ErrNotFound
+	}
+	err := common.WithContext(ctx, s.db).Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).First(&reset).Error
+	return reset, common.StoreError(err)
+}
+
+func (s *gormTokenStore) CreateVerification(ctx context.Context, verification *EmailVerificationModel) error {
+	return common.StoreError(common.WithContext(ctx, s.db).Create(verification).Error)
+}
+
+func (s *gormTokenStore) UseVerification(ctx context.Context, hash string, now time.Time) (EmailVerificationModel, error) {
+	var verification EmailVerificationModel
+	if hash == "" {
+		return verification, common.ErrNotFound
+	}
+	db := common.WithContext(ctx, s.db)
+	err := db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).First(&verification).Error
+	if err != nil {
+		return verification, common.StoreError(err)
+	}
+	// Conditional, so that a confirmation racing this one finds nothing left to update.
+	res := db.Model(&EmailVerificationModel{}).Where("id = ? AND used_at IS NULL", verification.ID).Update("used_at", now)
+	if res.Error != nil {
+		return verification, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return verification, common.ErrNotFound
+	}
+	verification.UsedAt = &now
+	return verification, nil
+}
+
+func (s *gormTokenStore) CreateLoginAttempt(ctx context.Context, attempt *LoginAttemptModel) error {
+	return common.StoreError(common.WithContext(ctx, s.db).Create(attempt).Error)
+}
+
+func (s *gormTokenStore) LoginAttempts(ctx context.Context, where LoginAttemptModel, since time.Time, limit int) ([]LoginAttemptModel, error) {
+	var attempts []LoginAttemptModel
+	err := common.WithContext(ctx, s.db).Where(&where).Where("created_at > ?", since).
+		Order("created_at desc, id desc").Limit(limit).Find(&attempts).Error
+	return attempts, err
+}
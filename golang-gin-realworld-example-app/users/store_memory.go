@@ -0,0 +1,495 @@
+package users
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"realworld-backend/common"
+)
+
+// The email is the only unique column of user_models.
+var errMemoryDuplicateEmail = &common.DuplicateError{Column: "email"}
+
+type follow struct {
+	followerID uint
+	followedID uint
+}
+
+// A UserStore keeping everything in memory, for tests and for running handlers without a database.
+// Like the gorm one it refuses to work for a context that already ended.
+type memoryUserStore struct {
+	mu      sync.RWMutex
+	users   []UserModel
+	follows []follow
+	nextID  uint
+}
+
+func NewMemoryUserStore() UserStore {
+	return &memoryUserStore{nextID: 1}
+}
+
+func (s *memoryUserStore) find(condition UserModel) (int, bool) {
+	condition, ok := userCondition(condition)
+	if !ok {
+		return 0, false
+	}
+	for i, user := range s.users {
+		if condition.ID != 0 && user.ID != condition.ID {
+			continue
+		}
+		if condition.Username != "" && user.Username != condition.Username {
+			continue
+		}
+		if condition.Email != "" && user.Email != condition.Email {
+			continue
+		}
+		return i, true
+	}
+	return 0, false
+}
+
+func (s *memoryUserStore) emailTaken(email string, exceptID uint) bool {
+	for _, user := range s.users {
+		if user.Email == email && user.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryUserStore) FindOne(ctx context.Context, condition UserModel) (UserModel, error) {
+	if err := ctx.Err(); err != nil {
+		return UserModel{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.find(condition)
+	if !ok {
+		return UserModel{}, common.ErrNotFound
+	}
+	return s.users[i], nil
+}
+
+func (s *memoryUserStore) Create(ctx context.Context, user *UserModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.emailTaken(user.Email, 0) {
+		return errMemoryDuplicateEmail
+	}
+	user.ID = s.nextID
+	s.nextID++
+	s.users = append(s.users, *user)
+	return nil
+}
+
+func (s *memoryUserStore) Update(ctx context.Context, user *UserModel, data UserModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.find(UserModel{ID: user.ID})
+	if !ok {
+		return common.ErrNotFound
+	}
+	if data.Email != "" && s.emailTaken(data.Email, user.ID) {
+		return errMemoryDuplicateEmail
+	}
+	stored := &s.users[i]
+	if data.Username != "" {
+		stored.Username = data.Username
+	}
+	if data.Email != "" {
+		stored.Email = data.Email
+	}
+	if data.Bio != "" {
+		stored.Bio = data.Bio
+	}
+	if data.Image != nil {
+		stored.Image = data.Image
+	}
+	if data.PasswordHash != "" {
+		stored.PasswordHash = data.PasswordHash
+	}
+	if data.VerifiedEmail != "" {
+		stored.VerifiedEmail = data.VerifiedEmail
+	}
+	if data.DisabledAt != nil {
+		stored.DisabledAt = data.DisabledAt
+	}
+	*user = *stored
+	return nil
+}
+
+func (s *memoryUserStore) Follow(ctx context.Context, follower UserModel, followed UserModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.follows {
+		if f.followerID == follower.ID && f.followedID == followed.ID {
+			return nil
+		}
+	}
+	s.follows = append(s.follows, follow{followerID: follower.ID, followedID: followed.ID})
+	return nil
+}
+
+func (s *memoryUserStore) Unfollow(ctx context.Context, follower UserModel, followed UserModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.follows[:0]
+	for _, f := range s.follows {
+		if f.followerID != follower.ID || f.followedID != followed.ID {
+			kept = append(kept, f)
+		}
+	}
+	s.follows = kept
+	return nil
+}
+
+func (s *memoryUserStore) IsFollowing(ctx context.Context, follower UserModel, followed UserModel) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if follower.ID == 0 || followed.ID == 0 {
+		return false, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, f := range s.follows {
+		if f.followerID == follower.ID && f.followedID == followed.ID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryUserStore) Followings(ctx context.Context, user UserModel) ([]UserModel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var followings []UserModel
+	for _, f := range s.follows {
+		if f.followerID != user.ID {
+			continue
+		}
+		if i, ok := s.find(UserModel{ID: f.followedID}); ok {
+			followings = append(followings, s.users[i])
+		}
+	}
+	return followings, nil
+}
+
+// A TokenStore keeping everything in memory, the tokens are lost with the process.
+type memoryTokenStore struct {
+	// Where CompleteReset sets the passwords.
+	users         UserStore
+	mu            sync.RWMutex
+	tokens        []RefreshTokenModel
+	revoked       map[string]time.Time
+	resets        []PasswordResetModel
+	verifications []EmailVerificationModel
+	logins        []LoginAttemptModel
+	nextID        uint
+}
+
+// The tokens of the users in users.
+func NewMemoryTokenStore(users UserStore) TokenStore {
+	return &memoryTokenStore{users: users, revoked: map[string]time.Time{}, nextID: 1}
+}
+
+func (s *memoryTokenStore) Create(ctx context.Context, token *RefreshTokenModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.tokens {
+		if other.TokenHash == token.TokenHash {
+			return &common.DuplicateError{Column: "token_hash"}
+		}
+	}
+	token.ID = s.nextID
+	s.nextID++
+	s.tokens = append(s.tokens, *token)
+	return nil
+}
+
+func (s *memoryTokenStore) FindByHash(ctx context.Context, hash string) (RefreshTokenModel, error) {
+	if err := ctx.Err(); err != nil {
+		return RefreshTokenModel{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, token := range s.tokens {
+		if hash != "" && token.TokenHash == hash {
+			return token, nil
+		}
+	}
+	return RefreshTokenModel{}, common.ErrNotFound
+}
+
+func (s *memoryTokenStore) Use(ctx context.Context, token *RefreshTokenModel, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.tokens {
+		stored := &s.tokens[i]
+		if stored.ID != token.ID {
+			continue
+		}
+		if stored.UsedAt != nil || stored.RevokedAt != nil {
+			return ErrRefreshTokenReused
+		}
+		stored.UsedAt = &now
+		token.UsedAt = &now
+		return nil
+	}
+	return ErrRefreshTokenReused
+}
+
+func (s *memoryTokenStore) RevokeFamily(ctx context.Context, family string, now time.Time) error {
+	return s.revokeSessions(ctx, func(token RefreshTokenModel) bool { return token.Family == family }, now)
+}
+
+func (s *memoryTokenStore) RevokeUser(ctx context.Context, userID uint, now time.Time) error {
+	return s.revokeSessions(ctx, func(token RefreshTokenModel) bool { return token.UserID == userID }, now)
+}
+
+func (s *memoryTokenStore) revokeSessions(ctx context.Context, match func(RefreshTokenModel) bool, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokeSessionsLocked(match, now)
+	return nil
+}
+
+// The work of revokeSessions, with s.mu held.
+func (s *memoryTokenStore) revokeSessionsLocked(match func(RefreshTokenModel) bool, now time.Time) {
+	for i := range s.tokens {
+		stored := &s.tokens[i]
+		if !match(*stored) {
+			continue
+		}
+		if stored.AccessExpiresAt.After(now) {
+			if _, ok := s.revoked[stored.AccessTokenID]; !ok {
+				s.revoked[stored.AccessTokenID] = stored.AccessExpiresAt
+			}
+		}
+		if stored.RevokedAt == nil {
+			stored.RevokedAt = &now
+		}
+	}
+}
+
+func (s *memoryTokenStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.revoked[jti]; !ok {
+		s.revoked[jti] = expiresAt
+	}
+	return nil
+}
+
+func (s *memoryTokenStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.revoked[jti]
+	return ok, nil
+}
+
+func (s *memoryTokenStore) DeleteExpired(ctx context.Context, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.tokens[:0]
+	for _, token := range s.tokens {
+		if token.ExpiresAt.After(now) {
+			kept = append(kept, token)
+		}
+	}
+	s.tokens = kept
+	for jti, expiresAt := range s.revoked {
+		if !expiresAt.After(now) {
+			delete(s.revoked, jti)
+		}
+	}
+	keptResets := s.resets[:0]
+	for _, reset := range s.resets {
+		if reset.ExpiresAt.After(now) {
+			keptResets = append(keptResets, reset)
+		}
+	}
+	s.resets = keptResets
+	keptVerifications := s.verifications[:0]
+	for _, verification := range s.verifications {
+		if verification.ExpiresAt.After(now) {
+			keptVerifications = append(keptVerifications, verification)
+		}
+	}
+	s.verifications = keptVerifications
+	keptLogins := s.logins[:0]
+	for _, attempt := range s.logins {
+		if attempt.CreatedAt.After(now.Add(-LoginAttemptRetention)) {
+			keptLogins = append(keptLogins, attempt)
+		}
+	}
+	s.logins = keptLogins
+	return nil
+}
+
+func (s *memoryTokenStore) CreateReset(ctx context.Context, reset *PasswordResetModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.resets {
+		if other.TokenHash == reset.TokenHash {
+			return &common.DuplicateError{Column: "token_hash"}
+		}
+	}
+	reset.ID = s.nextID
+	s.nextID++
+	s.resets = append(s.resets, *reset)
+	return nil
+}
+
+func (s *memoryTokenStore) CompleteReset(ctx context.Context, hash string, userID uint, passwordHash string, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, reset := range s.resets {
+		if hash == "" || reset.TokenHash != hash || reset.UserID != userID || reset.UsedAt != nil || !reset.ExpiresAt.After(now) {
+			continue
+		}
+		// First, nothing is changed yet when it fails; the rest cannot fail.
+		if err := s.users.Update(ctx, &UserModel{ID: userID}, UserModel{PasswordHash: passwordHash}); err != nil {
+			return err
+		}
+		for i := range s.resets {
+			if s.resets[i].UserID == userID && s.resets[i].UsedAt == nil {
+				s.resets[i].UsedAt = &now
+			}
+		}
+		s.revokeSessionsLocked(func(token RefreshTokenModel) bool { return token.UserID == userID }, now)
+		return nil
+	}
+	return common.ErrNotFound
+}
+
+func (s *memoryTokenStore) FindReset(ctx context.Context, hash string, now time.Time) (PasswordResetModel, error) {
+	if err := ctx.Err(); err != nil {
+		return PasswordResetModel{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, reset := range s.resets {
+		if hash != "" && reset.TokenHash == hash && reset.UsedAt == nil && reset.ExpiresAt.After(now) {
+			return reset, nil
+		}
+	}
+	return PasswordResetModel{}, common.ErrNotFound
+}
+
+func (s *memoryTokenStore) CreateVerification(ctx context.Context, verification *EmailVerificationModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.verifications {
+		if other.TokenHash == verification.TokenHash {
+			return &common.DuplicateError{Column: "token_hash"}
+		}
+	}
+	verification.ID = s.nextID
+	s.nextID++
+	s.verifications = append(s.verifications, *verification)
+	return nil
+}
+
+func (s *memoryTokenStore) UseVerification(ctx context.Context, hash string, now time.Time) (EmailVerificationModel, error) {
+	if err := ctx.Err(); err != nil {
+		return EmailVerificationModel{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, verification := range s.verifications {
+		if hash == "" || verification.TokenHash != hash || verification.UsedAt != nil || !verification.ExpiresAt.After(now) {
+			continue
+		}
+		s.verifications[i].UsedAt = &now
+		verification.UsedAt = &now
+		return verification, nil
+	}
+	return EmailVerificationModel{}, common.ErrNotFound
+}
+
+func (s *memoryTokenStore) CreateLoginAttempt(ctx context.Context, attempt *LoginAttemptModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if attempt.CreatedAt.IsZero() {
+		attempt.CreatedAt = time.Now()
+	}
+	attempt.ID = s.nextID
+	s.nextID++
+	s.logins = append(s.logins, *attempt)
+	return nil
+}
+
+func (s *memoryTokenStore) LoginAttempts(ctx context.Context, where LoginAttemptModel, since time.Time, limit int) ([]LoginAttemptModel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var attempts []LoginAttemptModel
+	for _, attempt := range s.logins {
+		if (where.UserID != 0 && attempt.UserID != where.UserID) || (where.Email != "" && attempt.Email != where.Email) ||
+			(where.IP != "" && attempt.IP != where.IP) || (where.Outcome != "" && attempt.Outcome != where.Outcome) ||
+			!attempt.CreatedAt.After(since) {
+			continue
+		}
+		attempts = append(attempts, attempt)
+	}
+	sort.SliceStable(attempts, func(i, j int) bool {
+		if !attempts[i].CreatedAt.Equal(attempts[j].CreatedAt) {
+			return attempts[i].CreatedAt.After(attempts[j].CreatedAt)
+		}
+		return attempts[i].ID > attempts[j].ID
+	})
+	if len(attempts) > limit {
+		attempts = attempts[:limit]
+	}
+	return attempts, nil
+}
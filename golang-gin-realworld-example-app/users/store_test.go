@@ -0,0 +1,431 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"realworld-backend/common"
+)
+
+// The behaviour every UserStore implementation should share, run against each of them below.
+func testUserStoreConformance(t *testing.T, newStore func() UserStore) {
+	t.Run("FindOne", func(t *testing.T) {
+		asserts := assert.New(t)
+		store := newStore()
+		ctx := context.Background()
+
+		_, err := store.FindOne(ctx, UserModel{Username: "nobody"})
+		asserts.ErrorIs(err, common.ErrNotFound, "unknown user should not be found")
+
+		user := UserModel{Username: "store1", Email: "store1@g.cn", Bio: "bio1", PasswordHash: "hash"}
+		asserts.NoError(store.Create(ctx, &user))
+		asserts.NotZero(user.ID, "Create should fill the ID")
+
+		for _, condition := range []UserModel{{ID: user.ID}, {Username: "store1"}, {Email: "store1@g.cn"}} {
+			found, err := store.FindOne(ctx, condition)
+			asserts.NoError(err)
+			asserts.Equal(user, found, "user should be found by %+v", condition)
+		}
+		_, err = store.FindOne(ctx, UserModel{Username: "store1", Email: "other@g.cn"})
+		asserts.ErrorIs(err, common.ErrNotFound, "every field of the condition should match")
+		_, err = store.FindOne(ctx, UserModel{Bio: "bio1"})
+		asserts.ErrorIs(err, common.ErrNotFound, "an empty condition should not match anybody")
+	})
+
+	t.Run("CreateDuplicateEmail", func(t *testing.T) {
+		asserts := assert.New(t)
+		store := newStore()
+		ctx := context.Background()
+
+		asserts.NoError(store.Create(ctx, &UserModel{Username: "dup1", Email: "dup@g.cn", PasswordHash: "hash"}))
+		err := store.Create(ctx, &UserModel{Username: "dup2", Email: "dup@g.cn", PasswordHash: "hash"})
+		asserts.ErrorIs(err, common.ErrDuplicate, "email should be unique")
+		var dupErr *common.DuplicateError
+		if asserts.ErrorAs(err, &dupErr) {
+			asserts.Equal("email", dupErr.Column)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		asserts := assert.New(t)
+		store := newStore()
+		ctx := context.Background()
+
+		user := UserModel{Username: "update1", Email: "update1@g.cn", Bio: "bio", PasswordHash: "hash"}
+		other := UserModel{Username: "update2", Email: "update2@g.cn", PasswordHash: "hash"}
+		asserts.NoError(store.Create(ctx, &user))
+		asserts.NoError(store.Create(ctx, &other))
+
+		image := "http://image/1.jpg"
+		asserts.NoError(store.Update(ctx, &user, UserModel{Username: "renamed", Image: &image}))
+		asserts.Equal("renamed", user.Username, "Update should change the struct")
+		asserts.Equal("bio", user.Bio, "zero fields should be left alone")
+
+		found, err := store.FindOne(ctx, UserModel{ID: user.ID})
+		asserts.NoError(err)
+		asserts.Equal("renamed", found.Username, "Update should be saved")
+		asserts.Equal(image, *found.Image)
+
+		asserts.ErrorIs(store.Update(ctx, &user, UserModel{Email: "update2@g.cn"}), common.ErrDuplicate, "email should stay unique")
+
+		now := time.Now()
+		asserts.NoError(store.Update(ctx, &user, UserModel{DisabledAt: &now}))
+		found, _ = store.FindOne(ctx, UserModel{ID: user.ID})
+		asserts.True(found.Disabled(), "disabling should be saved")
+	})
+
+	t.Run("Follow", func(t *testing.T) {
+		asserts := assert.New(t)
+		store := newStore()
+		ctx := context.Background()
+
+		var users []UserModel
+		for _, name := range []string{"follow1", "follow2", "follow3"} {
+			user := UserModel{Username: name, Email: name + "@g.cn", PasswordHash: "hash"}
+			asserts.NoError(store.Create(ctx, &user))
+			users = append(users, user)
+		}
+		a, b, c := users[0], users[1], users[2]
+
+		followings, err := store.Followings(ctx, a)
+		asserts.NoError(err)
+		asserts.Empty(followings)
+
+		asserts.NoError(store.Follow(ctx, a, b))
+		asserts.NoError(store.Follow(ctx, a, b), "following twice should not be an error")
+		asserts.NoError(store.Follow(ctx, a, c))
+		following, err := store.IsFollowing(ctx, a, b)
+		asserts.NoError(err)
+		asserts.True(following)
+		following, _ = store.IsFollowing(ctx, b, a)
+		asserts.False(following, "following should not be symmetric")
+		following, _ = store.IsFollowing(ctx, UserModel{}, b)
+		asserts.False(following, "the anonymous user follows nobody")
+
+		followings, err = store.Followings(ctx, a)
+		asserts.NoError(err)
+		asserts.Equal([]UserModel{b, c}, followings, "Followings should keep the following order")
+
+		asserts.NoError(store.Unfollow(ctx, a, b))
+		following, _ = store.IsFollowing(ctx, a, b)
+		asserts.False(following)
+		followings, _ = store.Followings(ctx, a)
+		asserts.Equal([]UserModel{c}, followings)
+
+		asserts.NoError(store.Follow(ctx, a, b), "following again after unfollowing should work")
+		followings, _ = store.Followings(ctx, a)
+		asserts.Equal([]UserModel{c, b}, followings)
+	})
+
+	t.Run("EndedContext", func(t *testing.T) {
+		asserts := assert.New(t)
+		store := newStore()
+		ctx := context.Background()
+
+		user := UserModel{Username: "ctx1", Email: "ctx1@g.cn", PasswordHash: "hash"}
+		asserts.NoError(store.Create(ctx, &user))
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := store.FindOne(cancelled, UserModel{ID: user.ID})
+		asserts.ErrorIs(err, context.Canceled, "a cancelled context should stop the lookup")
+		asserts.ErrorIs(store.Create(cancelled, &UserModel{Username: "ctx2", Email: "ctx2@g.cn"}), context.Canceled)
+
+		expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+		defer cancel()
+		_, err = store.Followings(expired, user)
+		asserts.ErrorIs(err, context.DeadlineExceeded, "a passed deadline should stop the query")
+	})
+}
+
+func TestGormUserStore(t *testing.T) {
+	testUserStoreConformance(t, func() UserStore {
+		test_db.DropTableIfExists(&FollowModel{}, &UserModel{})
+		AutoMigrate(test_db)
+		return NewGormUserStore(test_db)
+	})
+}
+
+func TestMemoryUserStore(t *testing.T) {
+	testUserStoreConformance(t, NewMemoryUserStore)
+}
+
+// The behaviour every TokenStore implementation should share.
+func testTokenStoreConformance(t *testing.T, newStore func() (UserStore, TokenStore)) {
+	t.Run("Use", func(t *testing.T) {
+		asserts := assert.New(t)
+		_, store := newStore()
+		ctx := context.Background()
+		now := time.Now()
+
+		_, err := store.FindByHash(ctx, "unknown")
+		asserts.ErrorIs(err, common.ErrNotFound)
+		token := RefreshTokenModel{UserID: 1, Family: "f1", TokenHash: "h1", AccessTokenID: "a1",
+			AccessExpiresAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}
+		asserts.NoError(store.Create(ctx, &token))
+		asserts.NotZero(token.ID, "Create should fill the ID")
+		asserts.ErrorIs(store.Create(ctx, &RefreshTokenModel{Family: "f2", TokenHash: "h1"}), common.ErrDuplicate)
+
+		found, err := store.FindByHash(ctx, "h1")
+		asserts.NoError(err)
+		asserts.Equal(token.ID, found.ID)
+		asserts.Equal("f1", found.Family)
+		asserts.Nil(found.UsedAt)
+
+		asserts.NoError(store.Use(ctx, &found, now))
+		asserts.NotNil(found.UsedAt)
+		asserts.ErrorIs(store.Use(ctx, &found, now), ErrRefreshTokenReused, "a token should only be used once")
+		found, _ = store.FindByHash(ctx, "h1")
+		asserts.NotNil(found.UsedAt, "the use should be stored")
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		asserts := assert.New(t)
+		_, store := newStore()
+		ctx := context.Background()
+		now := time.Now()
+
+		for i, hash := range []string{"h1", "h2"} {
+			asserts.NoError(store.Create(ctx, &RefreshTokenModel{UserID: 1, Family: "f1", TokenHash: hash,
+				AccessTokenID: hash + "-access", AccessExpiresAt: now.Add(time.Duration(2*i-1) * time.Minute), ExpiresAt: now.Add(time.Hour)}))
+		}
+		asserts.NoError(store.Create(ctx, &RefreshTokenModel{UserID: 1, Family: "f2", TokenHash: "h3",
+			AccessTokenID: "h3-access", AccessExpiresAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}))
+
+		asserts.NoError(store.RevokeFamily(ctx, "f1", now))
+		revoked, err := store.IsRevoked(ctx, "h2-access")
+		asserts.NoError(err)
+		asserts.True(revoked, "the live access tokens of the family should be revoked")
+		revoked, _ = store.IsRevoked(ctx, "h1-access")
+		asserts.False(revoked, "the expired ones need not be")
+		revoked, _ = store.IsRevoked(ctx, "h3-access")
+		asserts.False(revoked, "the other families should be kept")
+		token, _ := store.FindByHash(ctx, "h2")
+		asserts.NotNil(token.RevokedAt)
+		asserts.ErrorIs(store.Use(ctx, &token, now), ErrRefreshTokenReused, "a revoked token should not be used")
+		asserts.NoError(store.RevokeFamily(ctx, "f1", now), "revoking twice is not an error")
+
+		asserts.NoError(store.Revoke(ctx, "jti1", now.Add(time.Minute)))
+		asserts.NoError(store.Revoke(ctx, "jti1", now.Add(time.Minute)), "revoking twice is not an error")
+		revoked, _ = store.IsRevoked(ctx, "jti1")
+		asserts.True(revoked)
+
+		asserts.NoError(store.DeleteExpired(ctx, now.Add(2*time.Minute)))
+		revoked, _ = store.IsRevoked(ctx, "jti1")
+		asserts.False(revoked, "an expired revocation should be forgotten")
+		_, err = store.FindByHash(ctx, "h3")
+		asserts.NoError(err, "the refresh tokens should be kept until they expire")
+		asserts.NoError(store.DeleteExpired(ctx, now.Add(2*time.Hour)))
+		_, err = store.FindByHash(ctx, "h3")
+		asserts.ErrorIs(err, common.ErrNotFound)
+	})
+
+	t.Run("RevokeUser", func(t *testing.T) {
+		asserts := assert.New(t)
+		_, store := newStore()
+		ctx := context.Background()
+		now := time.Now()
+
+		for i, userID := range []uint{1, 1, 2} {
+			hash := fmt.Sprintf("h%d", i)
+			asserts.NoError(store.Create(ctx, &RefreshTokenModel{UserID: userID, Family: hash, TokenHash: hash,
+				AccessTokenID: hash + "-access", AccessExpiresAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}))
+		}
+		asserts.NoError(store.RevokeUser(ctx, 1, now))
+		for hash, want := range map[string]bool{"h0": true, "h1": true, "h2": false} {
+			revoked, err := store.IsRevoked(ctx, hash+"-access")
+			asserts.NoError(err)
+			asserts.Equal(want, revoked, "access token %s", hash)
+			token, _ := store.FindByHash(ctx, hash)
+			asserts.Equal(want, token.RevokedAt != nil, "refresh token %s", hash)
+		}
+	})
+
+	t.Run("CompleteReset", func(t *testing.T) {
+		asserts := assert.New(t)
+		users, store := newStore()
+		ctx := context.Background()
+		now := time.Now()
+
+		jake := UserModel{Username: "jake", Email: "jake@jake.jake", PasswordHash: "old"}
+		anna := UserModel{Username: "anna", Email: "anna@jake.jake", PasswordHash: "old"}
+		asserts.NoError(users.Create(ctx, &jake))
+		asserts.NoError(users.Create(ctx, &anna))
+		for i, userID := range []uint{jake.ID, jake.ID, anna.ID} {
+			reset := PasswordResetModel{UserID: userID, TokenHash: fmt.Sprintf("r%d", i), ExpiresAt: now.Add(time.Hour)}
+			asserts.NoError(store.CreateReset(ctx, &reset))
+			asserts.NotZero(reset.ID, "CreateReset should fill the ID")
+		}
+		asserts.ErrorIs(store.CreateReset(ctx, &PasswordResetModel{UserID: anna.ID, TokenHash: "r0"}), common.ErrDuplicate)
+		asserts.NoError(store.CreateReset(ctx, &PasswordResetModel{UserID: anna.ID, TokenHash: "expired", ExpiresAt: now}))
+		for i, userID := range []uint{jake.ID, anna.ID} {
+			hash := fmt.Sprintf("h%d", i)
+			asserts.NoError(store.Create(ctx, &RefreshTokenModel{UserID: userID, Family: hash, TokenHash: hash,
+				AccessTokenID: hash + "-access", AccessExpiresAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}))
+		}
+
+		_, err := store.FindReset(ctx, "unknown", now)
+		asserts.ErrorIs(err, common.ErrNotFound)
+		found, err := store.FindReset(ctx, "r0", now)
+		asserts.NoError(err)
+		asserts.Equal(jake.ID, found.UserID)
+		asserts.Nil(found.UsedAt, "FindReset should not use the reset")
+		_, err = store.FindReset(ctx, "expired", now)
+		asserts.ErrorIs(err, common.ErrNotFound)
+
+		asserts.ErrorIs(store.CompleteReset(ctx, "unknown", jake.ID, "new", now), common.ErrNotFound)
+		asserts.ErrorIs(store.CompleteReset(ctx, "expired", anna.ID, "new", now), common.ErrNotFound,
+			"an expired reset should not be used")
+		asserts.ErrorIs(store.CompleteReset(ctx, "r0", anna.ID, "new", now), common.ErrNotFound,
+			"the reset of another user should not be used")
+		_, err = store.FindReset(ctx, "r0", now)
+		asserts.NoError(err, "a refused reset should stay usable")
+
+		asserts.NoError(store.CompleteReset(ctx, "r0", jake.ID, "new", now))
+		user, _ := users.FindOne(ctx, UserModel{ID: jake.ID})
+		asserts.Equal("new", user.PasswordHash, "the password should be set")
+		user, _ = users.FindOne(ctx, UserModel{ID: anna.ID})
+		asserts.Equal("old", user.PasswordHash, "the password of the other users should be kept")
+		for hash, want := range map[string]bool{"h0": true, "h1": false} {
+			revoked, err := store.IsRevoked(ctx, hash+"-access")
+			asserts.NoError(err)
+			asserts.Equal(want, revoked, "access token %s", hash)
+			token, _ := store.FindByHash(ctx, hash)
+			asserts.Equal(want, token.RevokedAt != nil, "refresh token %s", hash)
+		}
+		asserts.ErrorIs(store.CompleteReset(ctx, "r0", jake.ID, "new", now), common.ErrNotFound,
+			"a reset should only be used once")
+		_, err = store.FindReset(ctx, "r1", now)
+		asserts.ErrorIs(err, common.ErrNotFound, "a used reset should not be found")
+		asserts.ErrorIs(store.CompleteReset(ctx, "r1", jake.ID, "new", now), common.ErrNotFound,
+			"the other resets of the user should be spent too")
+		asserts.NoError(store.CompleteReset(ctx, "r2", anna.ID, "new", now), "the resets of the other users should be kept")
+
+		asserts.NoError(store.CreateReset(ctx, &PasswordResetModel{UserID: 999, TokenHash: "gone", ExpiresAt: now.Add(time.Hour)}))
+		asserts.ErrorIs(store.CompleteReset(ctx, "gone", 999, "new", now), common.ErrNotFound, "the user should exist")
+		_, err = store.FindReset(ctx, "gone", now)
+		asserts.NoError(err, "a failed reset should stay usable")
+
+		asserts.NoError(store.CreateReset(ctx, &PasswordResetModel{UserID: anna.ID, TokenHash: "r3", ExpiresAt: now.Add(time.Hour)}))
+		asserts.NoError(store.DeleteExpired(ctx, now.Add(2*time.Hour)))
+		asserts.ErrorIs(store.CompleteReset(ctx, "r3", anna.ID, "new", now), common.ErrNotFound,
+			"the expired resets should be deleted")
+	})
+
+	t.Run("UseVerification", func(t *testing.T) {
+		asserts := assert.New(t)
+		_, store := newStore()
+		ctx := context.Background()
+		now := time.Now()
+
+		verification := EmailVerificationModel{UserID: 1, Email: "jake@jake.jake", TokenHash: "v0", ExpiresAt: now.Add(time.Hour)}
+		asserts.NoError(store.CreateVerification(ctx, &verification))
+		asserts.NotZero(verification.ID, "CreateVerification should fill the ID")
+		asserts.ErrorIs(store.CreateVerification(ctx, &EmailVerificationModel{UserID: 2, TokenHash: "v0"}), common.ErrDuplicate)
+		asserts.NoError(store.CreateVerification(ctx, &EmailVerificationModel{UserID: 2, TokenHash: "expired", ExpiresAt: now}))
+
+		_, err := store.UseVerification(ctx, "unknown", now)
+		asserts.ErrorIs(err, common.ErrNotFound)
+		_, err = store.UseVerification(ctx, "expired", now)
+		asserts.ErrorIs(err, common.ErrNotFound, "an expired verification should not be used")
+
+		found, err := store.UseVerification(ctx, "v0", now)
+		asserts.NoError(err)
+		asserts.Equal(uint(1), found.UserID)
+		asserts.Equal("jake@jake.jake", found.Email)
+		asserts.NotNil(found.UsedAt)
+		_, err = store.UseVerification(ctx, "v0", now)
+		asserts.ErrorIs(err, common.ErrNotFound, "a verification should only be used once")
+
+		asserts.NoError(store.CreateVerification(ctx, &EmailVerificationModel{UserID: 3, TokenHash: "v1", ExpiresAt: now.Add(time.Hour)}))
+		asserts.NoError(store.DeleteExpired(ctx, now.Add(2*time.Hour)))
+		_, err = store.UseVerification(ctx, "v1", now)
+		asserts.ErrorIs(err, common.ErrNotFound, "the expired verifications should be deleted")
+	})
+
+	t.Run("LoginAttempts", func(t *testing.T) {
+		asserts := assert.New(t)
+		_, store := newStore()
+		ctx := context.Background()
+		now := time.Now().Truncate(time.Second)
+
+		for i, attempt := range []LoginAttemptModel{
+			{UserID: 1, Email: "jake@jake.jake", IP: "10.0.0.1", Outcome: LoginFailure, CreatedAt: now.Add(-3 * time.Minute)},
+			{UserID: 1, Email: "jake@jake.jake", IP: "10.0.0.2", Outcome: LoginSuccess, CreatedAt: now.Add(-2 * time.Minute)},
+			{Email: "nobody@jake.jake", IP: "10.0.0.1", Outcome: LoginFailure, CreatedAt: now.Add(-time.Minute)},
+			{UserID: 1, Email: "jake@jake.jake", IP: "10.0.0.1", Outcome: LoginFailure, CreatedAt: now},
+		} {
+			asserts.NoError(store.CreateLoginAttempt(ctx, &attempt))
+			asserts.NotZero(attempt.ID, "CreateLoginAttempt should fill the ID of attempt %d", i)
+		}
+		attempt := LoginAttemptModel{Email: "anna@jake.jake", Outcome: LoginFailure}
+		asserts.NoError(store.CreateLoginAttempt(ctx, &attempt))
+		asserts.False(attempt.CreatedAt.IsZero(), "CreateLoginAttempt should fill CreatedAt")
+
+		attempts, err := store.LoginAttempts(ctx, LoginAttemptModel{UserID: 1}, time.Time{}, 10)
+		asserts.NoError(err)
+		if asserts.Len(attempts, 3) {
+			asserts.Equal(now.Unix(), attempts[0].CreatedAt.Unix(), "the newest should come first")
+			asserts.Equal(LoginSuccess, attempts[1].Outcome)
+			asserts.Equal("10.0.0.2", attempts[1].IP)
+		}
+		attempts, err = store.LoginAttempts(ctx, LoginAttemptModel{IP: "10.0.0.1", Outcome: LoginFailure}, now.Add(-2*time.Minute), 10)
+		asserts.NoError(err)
+		asserts.Len(attempts, 2, "the failures of the IP after since, whatever the account")
+		attempts, err = store.LoginAttempts(ctx, LoginAttemptModel{Email: "jake@jake.jake"}, time.Time{}, 1)
+		asserts.NoError(err)
+		asserts.Len(attempts, 1, "limit should cap the attempts")
+
+		asserts.NoError(store.DeleteExpired(ctx, now.Add(LoginAttemptRetention).Add(-90*time.Second)))
+		attempts, err = store.LoginAttempts(ctx, LoginAttemptModel{UserID: 1}, time.Time{}, 10)
+		asserts.NoError(err)
+		asserts.Len(attempts, 1, "the attempts older than the retention should be deleted")
+	})
+}
+
+func TestGormTokenStore(t *testing.T) {
+	testTokenStoreConformance(t, func() (UserStore, TokenStore) {
+		test_db.DropTableIfExists(&RefreshTokenModel{}, &RevokedTokenModel{}, &PasswordResetModel{}, &EmailVerificationModel{},
+			&LoginAttemptModel{}, &FollowModel{}, &UserModel{})
+		AutoMigrate(test_db)
+		return NewGormUserStore(test_db), NewGormTokenStore(test_db)
+	})
+}
+
+func TestMemoryTokenStore(t *testing.T) {
+	testTokenStoreConformance(t, func() (UserStore, TokenStore) {
+		users := NewMemoryUserStore()
+		return users, NewMemoryTokenStore(users)
+	})
+}
+
+// A reset whose revocation fails should change nothing, its token staying usable.
+func TestGormCompleteResetRollsBack(t *testing.T) {
+	asserts := assert.New(t)
+	test_db.DropTableIfExists(&RefreshTokenModel{}, &RevokedTokenModel{}, &PasswordResetModel{}, &FollowModel{}, &UserModel{})
+	AutoMigrate(test_db)
+	users, store := NewGormUserStore(test_db), NewGormTokenStore(test_db)
+	ctx := context.Background()
+	now := time.Now()
+
+	jake := UserModel{Username: "jake", Email: "jake@jake.jake", PasswordHash: "old"}
+	asserts.NoError(users.Create(ctx, &jake))
+	asserts.NoError(store.CreateReset(ctx, &PasswordResetModel{UserID: jake.ID, TokenHash: "r0", ExpiresAt: now.Add(time.Hour)}))
+	asserts.NoError(store.Create(ctx, &RefreshTokenModel{UserID: jake.ID, Family: "h0", TokenHash: "h0",
+		AccessTokenID: "h0-access", AccessExpiresAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}))
+
+	// The access token cannot be put on the revocation list without its table
+	test_db.DropTableIfExists(&RevokedTokenModel{})
+	defer AutoMigrate(test_db)
+	asserts.Error(store.CompleteReset(ctx, "r0", jake.ID, "new", now))
+
+	_, err := store.FindReset(ctx, "r0", now)
+	asserts.NoError(err, "the reset token should stay usable")
+	user, _ := users.FindOne(ctx, UserModel{ID: jake.ID})
+	asserts.Equal("old", user.PasswordHash, "the password should be kept")
+	token, _ := store.FindByHash(ctx, "h0")
+	asserts.Nil(token.RevokedAt, "the session should be kept")
+}
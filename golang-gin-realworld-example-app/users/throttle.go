@@ -0,0 +1,90 @@
+package users
+
+import (
+	"context"
+	"time"
+
+	"realworld-backend/config"
+)
+
+// Slows down the guessing of passwords, then stops it. The failed logins of an account and of a client IP over
+// Window make their next login wait, twice as long after each failure past FreeFailures, and lockout of them refuse
+// every login for LockoutDuration, the right password included. An unknown email counts like a registered one, so
+// the answers do not tell them apart.
+type LoginThrottle struct {
+	Window       time.Duration
+	FreeFailures int
+	Delay        time.Duration
+	MaxDelay     time.Duration
+	// The failures locking an account, or every account from an IP, 0 never locks.
+	AccountLockout  int
+	IPLockout       int
+	LockoutDuration time.Duration
+}
+
+// The throttle of cfg.
+func NewLoginThrottle(cfg config.LoginConfig) LoginThrottle {
+	return LoginThrottle{
+		Window:          time.Duration(cfg.FailureWindow),
+		FreeFailures:    cfg.FreeFailures,
+		Delay:           time.Duration(cfg.Delay),
+		MaxDelay:        time.Duration(cfg.MaxDelay),
+		AccountLockout:  cfg.AccountLockout,
+		IPLockout:       cfg.IPLockout,
+		LockoutDuration: time.Duration(cfg.LockoutDuration),
+	}
+}
+
+// How long a login of email from ip has to wait at now, 0 when it can go on.
+func (t LoginThrottle) Wait(ctx context.Context, store TokenStore, email, ip string, now time.Time) (time.Duration, error) {
+	since := now.Add(-t.Window)
+	// A successful login forgets the failures of its account before it, not the ones of its IP: an attacker
+	// could log in an account of their own between guesses otherwise.
+	successes, err := store.LoginAttempts(ctx, LoginAttemptModel{Email: email, Outcome: LoginSuccess}, since, 1)
+	if err != nil {
+		return 0, err
+	}
+	accountSince := since
+	if len(successes) > 0 {
+		accountSince = successes[0].CreatedAt
+	}
+	failures, err := store.LoginAttempts(ctx, LoginAttemptModel{Email: email, Outcome: LoginFailure}, accountSince, t.limit(t.AccountLockout))
+	if err != nil {
+		return 0, err
+	}
+	wait := t.wait(failures, t.AccountLockout, now)
+	if ip == "" {
+		return wait, nil
+	}
+	failures, err = store.LoginAttempts(ctx, LoginAttemptModel{IP: ip, Outcome: LoginFailure}, since, t.limit(t.IPLockout))
+	if err != nil {
+		return 0, err
+	}
+	return max(wait, t.wait(failures, t.IPLockout, now)), nil
+}
+
+// The failures worth reading: the ones reaching the lockout, or the ones reaching MaxDelay when it never locks.
+func (t LoginThrottle) limit(lockout int) int {
+	if lockout > 0 {
+		return lockout
+	}
+	return t.FreeFailures + 64
+}
+
+// The wait left at now after failures, newest first.
+func (t LoginThrottle) wait(failures []LoginAttemptModel, lockout int, now time.Time) time.Duration {
+	n := len(failures)
+	if n <= t.FreeFailures {
+		return 0
+	}
+	delay := t.Delay
+	if lockout > 0 && n >= lockout {
+		delay = t.LockoutDuration
+	} else {
+		for i := t.FreeFailures + 1; i < n && delay < t.MaxDelay; i++ {
+			delay *= 2
+		}
+		delay = min(delay, t.MaxDelay)
+	}
+	return max(failures[0].CreatedAt.Add(delay).Sub(now), 0)
+}
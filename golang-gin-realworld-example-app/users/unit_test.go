@@ -1,18 +1,32 @@
 package users
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"testing"
 
 	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/jinzhu/gorm"
 	"realworld-backend/common"
+	"realworld-backend/fixtures"
+	"realworld-backend/mail"
+	"realworld-backend/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"net/http/httptest"
 	"os"
-	_ "regexp"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 var image_url = "https://golang.org/doc/gopher/frontpage.png"
@@ -29,21 +43,27 @@ func newUserModel() UserModel {
 	}
 }
 
+// Load n more users userN through the fixture loader, all with the password password123.
 func userModelMocker(n int) []UserModel {
 	var offset int
 	test_db.Model(&UserModel{}).Count(&offset)
-	var ret []UserModel
+	f := &fixtures.Fixture{}
 	for i := offset + 1; i <= offset+n; i++ {
-		image := fmt.Sprintf("http://image/%v.jpg", i)
-		userModel := UserModel{
+		f.Users = append(f.Users, fixtures.User{
 			Username: fmt.Sprintf("user%v", i),
 			Email:    fmt.Sprintf("user%v@linkedin.com", i),
+			Password: "password123",
 			Bio:      fmt.Sprintf("bio%v", i),
-			Image:    &image,
-		}
-		userModel.setPassword("password123")
-		test_db.Create(&userModel)
-		ret = append(ret, userModel)
+			Image:    fmt.Sprintf("http://image/%v.jpg", i),
+		})
+	}
+	loaded, err := LoadFixture(context.Background(), NewGormUserStore(test_db), f)
+	if err != nil {
+		panic(err)
+	}
+	var ret []UserModel
+	for _, user := range f.Users {
+		ret = append(ret, loaded[user.Key()])
 	}
 	return ret
 }
@@ -57,13 +77,13 @@ func TestUserModel(t *testing.T) {
 	asserts.Error(err, "empty password should return err")
 
 	userModel = newUserModel()
-	err = userModel.setPassword("")
+	err = userModel.SetPassword("")
 	asserts.Error(err, "empty password can not be set null")
 
 	userModel = newUserModel()
-	err = userModel.setPassword("asd123!@#ASD")
+	err = userModel.SetPassword("asd123!@#ASD")
 	asserts.NoError(err, "password should be set successful")
-	asserts.Len(userModel.PasswordHash, 60, "password hash length should be 60")
+	asserts.True(strings.HasPrefix(userModel.PasswordHash, "$argon2id$"), "password should be hashed with argon2id by default")
 
 	err = userModel.checkPassword("sd123!@#ASD")
 	asserts.Error(err, "password should be checked and not validated")
@@ -72,30 +92,40 @@ func TestUserModel(t *testing.T) {
 	asserts.NoError(err, "password should be checked and validated")
 
 	//Testing the following relationship between users
+	store := NewGormUserStore(test_db)
+	followings := func(u UserModel) []UserModel {
+		res, err := store.Followings(context.Background(), u)
+		asserts.NoError(err, "Followings should not return error")
+		return res
+	}
+	isFollowing := func(u, v UserModel) bool {
+		res, err := store.IsFollowing(context.Background(), u, v)
+		asserts.NoError(err, "IsFollowing should not return error")
+		return res
+	}
 	users := userModelMocker(3)
 	a := users[0]
 	b := users[1]
 	c := users[2]
-	asserts.Equal(0, len(a.GetFollowings()), "GetFollowings should be right before following")
-	asserts.Equal(false, a.isFollowing(b), "isFollowing relationship should be right at init")
-	a.following(b)
-	asserts.Equal(1, len(a.GetFollowings()), "GetFollowings should be right after a following b")
-	asserts.Equal(true, a.isFollowing(b), "isFollowing should be right after a following b")
-	a.following(c)
-	asserts.Equal(2, len(a.GetFollowings()), "GetFollowings be right after a following c")
-	asserts.EqualValues(b, a.GetFollowings()[0], "GetFollowings should be right")
-	asserts.EqualValues(c, a.GetFollowings()[1], "GetFollowings should be right")
-	a.unFollowing(b)
-	asserts.Equal(1, len(a.GetFollowings()), "GetFollowings should be right after a unFollowing b")
-	asserts.EqualValues(c, a.GetFollowings()[0], "GetFollowings should be right after a unFollowing b")
-	asserts.Equal(false, a.isFollowing(b), "isFollowing should be right after a unFollowing b")
+	asserts.Equal(0, len(followings(a)), "Followings should be right before following")
+	asserts.Equal(false, isFollowing(a, b), "IsFollowing relationship should be right at init")
+	store.Follow(context.Background(), a, b)
+	asserts.Equal(1, len(followings(a)), "Followings should be right after a following b")
+	asserts.Equal(true, isFollowing(a, b), "IsFollowing should be right after a following b")
+	store.Follow(context.Background(), a, c)
+	asserts.Equal(2, len(followings(a)), "Followings be right after a following c")
+	asserts.EqualValues(b, followings(a)[0], "Followings should be right")
+	asserts.EqualValues(c, followings(a)[1], "Followings should be right")
+	store.Unfollow(context.Background(), a, b)
+	asserts.Equal(1, len(followings(a)), "Followings should be right after a unFollowing b")
+	asserts.EqualValues(c, followings(a)[0], "Followings should be right after a unFollowing b")
+	asserts.Equal(false, isFollowing(a, b), "IsFollowing should be right after a unFollowing b")
 }
 
 //Reset test DB and create new one with mock data
 func resetDBWithMock() {
-	common.TestDBFree(test_db)
-	test_db = common.TestDBInit()
-	AutoMigrate()
+	test_db.DropTableIfExists(&FollowModel{}, &UserModel{})
+	AutoMigrate(test_db)
 	userModelMocker(3)
 }
 
@@ -125,7 +155,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"username": "wangzitian0","email": "wzt@gg.cn","password": "jakejxke"}}`,
 		http.StatusCreated,
-		`{"user":{"username":"wangzitian0","email":"wzt@gg.cn","bio":"","image":null,"token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"wangzitian0","email":"wzt@gg.cn","bio":"","image":null,"emailVerified":false,"token":"([a-zA-Z0-9-_]+\.[a-zA-Z0-9-_]+\.[a-zA-Z0-9-_]+)","refreshToken":"([a-zA-Z0-9-_]{43})"}}`,
 		"valid data and should return StatusCreated",
 	},
 	{
@@ -133,9 +163,9 @@ var unauthRequestTests = []struct {
 		"/users/",
 		"POST",
 		`{"user":{"username": "wangzitian0","email": "wzt@gg.cn","password": "jakejxke"}}`,
-		http.StatusUnprocessableEntity,
-		`{"errors":{"database":"UNIQUE constraint failed: user_models.email"}}`,
-		"duplicated data and should return StatusUnprocessableEntity",
+		http.StatusConflict,
+		`{"error":{"code":"duplicate","message":"The user already exists","fields":\[{"path":"user.email","code":"unique","message":"email has already been taken"}\]}}`,
+		"duplicated data and should return StatusConflict",
 	},
 	{
 		func(req *http.Request) {},
@@ -143,16 +173,27 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"username": "u","email": "wzt@gg.cn","password": "jakejxke"}}`,
 		http.StatusUnprocessableEntity,
-		`{"errors":{"Username":"{min: 4}"}}`,
+		`{"error":{"code":"validation_failed","message":"The request is invalid","fields":\[{"path":"user.username","code":"min","param":"4","message":"username must be at least 4 characters in length"}\]}}`,
 		"too short username should return error",
 	},
+	{
+		func(req *http.Request) {
+			req.Header.Set("Accept-Language", "fr-CA, en;q=0.8")
+		},
+		"/users/",
+		"POST",
+		`{"user":{"username": "u","email": "wzt@gg.cn","password": "jakejxke"}}`,
+		http.StatusUnprocessableEntity,
+		`{"error":{"code":"validation_failed","message":"The request is invalid","fields":\[{"path":"user.username","code":"min","param":"4","message":"username doit faire une taille minimum de 4 caractères"}\]}}`,
+		"validation messages should follow Accept-Language",
+	},
 	{
 		func(req *http.Request) {},
 		"/users/",
 		"POST",
 		`{"user":{"username": "wangzitian0","email": "wzt@gg.cn","password": "j"}}`,
 		http.StatusUnprocessableEntity,
-		`{"errors":{"Password":"{min: 8}"}}`,
+		`{"error":{"code":"validation_failed","message":"The request is invalid","fields":\[{"path":"user.password","code":"min","param":"8","message":"password must be at least 8 characters in length"}\]}}`,
 		"too short password should return error",
 	},
 	{
@@ -161,7 +202,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"username": "wangzitian0","email": "wztgg.cn","password": "jakejxke"}}`,
 		http.StatusUnprocessableEntity,
-		`{"errors":{"Email":"{key: email}"}}`,
+		`{"error":{"code":"validation_failed","message":"The request is invalid","fields":\[{"path":"user.email","code":"email","message":"email must be a valid email address"}\]}}`,
 		"email invalid should return error",
 	},
 
@@ -174,7 +215,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"email": "user1@linkedin.com","password": "password123"}}`,
 		http.StatusOK,
-		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","emailVerified":true,"token":"([a-zA-Z0-9-_]+\.[a-zA-Z0-9-_]+\.[a-zA-Z0-9-_]+)","refreshToken":"([a-zA-Z0-9-_]{43})"}}`,
 		"right info login should return user",
 	},
 	{
@@ -182,8 +223,8 @@ var unauthRequestTests = []struct {
 		"/users/login",
 		"POST",
 		`{"user":{"email": "user112312312@linkedin.com","password": "password123"}}`,
-		http.StatusForbidden,
-		`{"errors":{"login":"Not Registered email or invalid password"}}`,
+		http.StatusUnauthorized,
+		`{"error":{"code":"invalid_credentials","message":"Not Registered email or invalid password"}}`,
 		"email not exist should return error info",
 	},
 	{
@@ -191,8 +232,8 @@ var unauthRequestTests = []struct {
 		"/users/login",
 		"POST",
 		`{"user":{"email": "user1@linkedin.com","password": "password126"}}`,
-		http.StatusForbidden,
-		`{"errors":{"login":"Not Registered email or invalid password"}}`,
+		http.StatusUnauthorized,
+		`{"error":{"code":"invalid_credentials","message":"Not Registered email or invalid password"}}`,
 		"password error should return error info",
 	},
 	{
@@ -201,7 +242,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"email": "user1@linkedin.com","password": "passw"}}`,
 		http.StatusUnprocessableEntity,
-		`{"errors":{"Password":"{min: 8}"}}`,
+		`{"error":{"code":"validation_failed","message":"The request is invalid","fields":\[{"path":"user.password","code":"min","param":"8","message":"password must be at least 8 characters in length"}\]}}`,
 		"password too short should return error info",
 	},
 	{
@@ -210,10 +251,34 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"email": "user1@linkedin.com","password": "passw"}}`,
 		http.StatusUnprocessableEntity,
-		`{"errors":{"Password":"{min: 8}"}}`,
+		`{"error":{"code":"validation_failed","message":"The request is invalid","fields":\[{"path":"user.password","code":"min","param":"8","message":"password must be at least 8 characters in length"}\]}}`,
 		"password too short should return error info",
 	},
 
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			test_db.Model(&UserModel{}).Where("email = ?", "user1@linkedin.com").Update("disabled_at", time.Now())
+		},
+		"/users/login",
+		"POST",
+		`{"user":{"email": "user1@linkedin.com","password": "password123"}}`,
+		http.StatusForbidden,
+		`{"error":{"code":"forbidden","message":"The account is disabled"}}`,
+		"disabled user should not login",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/",
+		"GET",
+		``,
+		http.StatusUnauthorized,
+		`{"error":{"code":"unauthorized","message":"The account is disabled"}}`,
+		"token of a disabled user should return 401",
+	},
+
 	//---------------------   Testing for self info get & auth module  ---------------------
 	{
 		func(req *http.Request) {
@@ -245,7 +310,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","emailVerified":true,"token":"([a-zA-Z0-9-_]+\.[a-zA-Z0-9-_]+\.[a-zA-Z0-9-_]+)"}}`,
 		"request should return current user with token",
 	},
 
@@ -295,7 +360,7 @@ var unauthRequestTests = []struct {
 		"PUT",
 		`{"user":{"username":"user123","password": "password126","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg"}}`,
 		http.StatusOK,
-		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","emailVerified":false,"token":"([a-zA-Z0-9-_]+\.[a-zA-Z0-9-_]+\.[a-zA-Z0-9-_]+)"}}`,
 		"current user profile should be changed",
 	},
 	{
@@ -315,7 +380,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"email": "user123@linkedin.com","password": "password126"}}`,
 		http.StatusOK,
-		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","emailVerified":false,"token":"([a-zA-Z0-9-_]+\.[a-zA-Z0-9-_]+\.[a-zA-Z0-9-_]+)","refreshToken":"([a-zA-Z0-9-_]{43})"}}`,
 		"user should login using new password after changed",
 	},
 	{
@@ -326,7 +391,7 @@ var unauthRequestTests = []struct {
 		"PUT",
 		`{"user":{"password": "pas"}}`,
 		http.StatusUnprocessableEntity,
-		`{"errors":{"Password":"{min: 8}"}}`,
+		`{"error":{"code":"validation_failed","message":"The request is invalid","fields":\[{"path":"user.password","code":"min","param":"8","message":"password must be at least 8 characters in length"}\]}}`,
 		"current user profile should not be changed with error user info",
 	},
 
@@ -339,9 +404,9 @@ var unauthRequestTests = []struct {
 		"/user/",
 		"PUT",
 		`{"password": "password321"}}`,
-		http.StatusUnprocessableEntity,
-		`{"errors":{"Email":"{key: email}","Username":"{key: alphanum}"}}`,
-		"test database pk error for user update",
+		http.StatusUnauthorized,
+		`{"error":{"code":"unauthorized","message":"The user of the token no longer exists"}}`,
+		"a token of a user no longer in the database should be refused",
 	},
 	{
 		func(req *http.Request) {
@@ -350,15 +415,13 @@ var unauthRequestTests = []struct {
 		"/user/",
 		"PUT",
 		`{"user":{"username": "wangzitian0","email": "wzt@gg.cn","password": "jakejxke"}}`,
-		http.StatusUnprocessableEntity,
-		`{"errors":{"database":"UNIQUE constraint failed: user_models.email"}}`,
-		"cheat validator and test database connecting error for user update",
+		http.StatusUnauthorized,
+		`{"error":{"code":"unauthorized","message":"Authentication required"}}`,
+		"a token without a user id should be refused",
 	},
 	{
 		func(req *http.Request) {
-			common.TestDBFree(test_db)
-			test_db = common.TestDBInit()
-
+			test_db.DropTableIfExists(&FollowModel{}, &UserModel{})
 			test_db.AutoMigrate(&UserModel{})
 			userModelMocker(3)
 			HeaderTokenMock(req, 2)
@@ -366,8 +429,8 @@ var unauthRequestTests = []struct {
 		"/profiles/user1/follow",
 		"POST",
 		``,
-		http.StatusUnprocessableEntity,
-		`{"errors":{"database":"no such table: follow_models"}}`,
+		http.StatusInternalServerError,
+		`{"error":{"code":"internal_error","message":"Internal server error"}}`,
 		"test database error for following",
 	},
 	{
@@ -377,8 +440,8 @@ var unauthRequestTests = []struct {
 		"/profiles/user1/follow",
 		"DELETE",
 		``,
-		http.StatusUnprocessableEntity,
-		`{"errors":{"database":"no such table: follow_models"}}`,
+		http.StatusInternalServerError,
+		`{"error":{"code":"internal_error","message":"Internal server error"}}`,
 		"test database error for canceling following",
 	},
 	{
@@ -390,7 +453,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		``,
 		http.StatusNotFound,
-		`{"errors":{"profile":"Invalid username"}}`,
+		`{"error":{"code":"not_found","message":"The profile was not found"}}`,
 		"following wrong user name should return errors",
 	},
 	{
@@ -401,7 +464,7 @@ var unauthRequestTests = []struct {
 		"DELETE",
 		``,
 		http.StatusNotFound,
-		`{"errors":{"profile":"Invalid username"}}`,
+		`{"error":{"code":"not_found","message":"The profile was not found"}}`,
 		"cancel following wrong user name should return errors",
 	},
 
@@ -458,11 +521,12 @@ func TestWithoutAuth(t *testing.T) {
 	//You could write the reset database code here if you want to create a database for this block
 	//resetDB()
 
+	h := NewHandler(NewGormUserStore(test_db), NewGormTokenStore(test_db))
 	r := gin.New()
-	UsersRegister(r.Group("/users"))
-	r.Use(AuthMiddleware(true))
-	UserRegister(r.Group("/user"))
-	ProfileRegister(r.Group("/profiles"))
+	h.UsersRegister(r.Group("/users"))
+	r.Use(h.AuthMiddleware(true))
+	h.UserRegister(r.Group("/user"))
+	h.ProfileRegister(r.Group("/profiles"))
 	for _, testData := range unauthRequestTests {
 		bodyData := testData.bodyData
 		req, err := http.NewRequest(testData.method, testData.url, bytes.NewBufferString(bodyData))
@@ -483,8 +547,712 @@ func TestWithoutAuth(t *testing.T) {
 //You can read TestWithoutAuth's comment to know how to not share database each case.
 func TestMain(m *testing.M) {
 	test_db = common.TestDBInit()
-	AutoMigrate()
+	AutoMigrate(test_db)
 	exitVal := m.Run()
 	common.TestDBFree(test_db)
 	os.Exit(exitVal)
 }
+
+func TestLoadFixture(t *testing.T) {
+	asserts := assert.New(t)
+	ctx := context.Background()
+	store := NewMemoryUserStore()
+
+	f := &fixtures.Fixture{
+		Users: []fixtures.User{
+			{Ref: "j", Username: "jake", Email: "jake@jake.jake", Password: "password123"},
+			{Username: "anna", Email: "anna@anna.anna", Password: "password123", Image: "http://image/anna.jpg"},
+		},
+		Follows: []fixtures.Follow{{Follower: "anna", Followed: "j"}},
+	}
+	loaded, err := LoadFixture(ctx, store, f)
+	asserts.NoError(err)
+	jake, anna := loaded["j"], loaded["anna"]
+	asserts.NotZero(jake.ID)
+	asserts.NoError(jake.checkPassword("password123"), "the password should be hashed like on sign up")
+	asserts.Equal("http://image/anna.jpg", *anna.Image)
+	following, err := store.IsFollowing(ctx, anna, jake)
+	asserts.NoError(err)
+	asserts.True(following, "the follows should be loaded")
+
+	_, err = LoadFixture(ctx, store, &fixtures.Fixture{Users: []fixtures.User{{Username: "bob", Email: "bob", Password: "password123"}}})
+	asserts.ErrorContains(err, "user bob:")
+	_, err = LoadFixture(ctx, store, &fixtures.Fixture{Users: []fixtures.User{{Username: "jake2", Email: "jake@jake.jake", Password: "password123"}}})
+	asserts.ErrorIs(err, common.ErrDuplicate)
+}
+
+func TestSession(t *testing.T) {
+	asserts := assert.New(t)
+	userStore := NewMemoryUserStore()
+	tokenStore := NewMemoryTokenStore(userStore)
+	h := NewHandler(userStore, tokenStore)
+	r := gin.New()
+	h.UsersRegister(r.Group("/users"))
+	r.Use(h.AuthMiddleware(true))
+	h.SessionRegister(r.Group("/users"))
+	h.UserRegister(r.Group("/user"))
+
+	jake := UserModel{Username: "jake", Email: "jake@jake.jake"}
+	jake.SetPassword("jakejake")
+	asserts.NoError(userStore.Create(context.Background(), &jake))
+
+	type session struct {
+		User struct {
+			Token        string `json:"token"`
+			RefreshToken string `json:"refreshToken"`
+		} `json:"user"`
+	}
+	send := func(method, url, token, body string) (int, session) {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var res session
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res
+	}
+	login := func() session {
+		code, res := send("POST", "/users/login", "", `{"user":{"email":"jake@jake.jake","password":"jakejake"}}`)
+		asserts.Equal(http.StatusOK, code)
+		asserts.NotEmpty(res.User.RefreshToken)
+		return res
+	}
+	refresh := func(refreshToken string) (int, session) {
+		return send("POST", "/users/refresh", "", `{"refreshToken":"`+refreshToken+`"}`)
+	}
+
+	first := login()
+	code, res := send("GET", "/user/", first.User.Token, "")
+	asserts.Equal(http.StatusOK, code)
+	asserts.Equal(first.User.Token, res.User.Token, "the token of the request should be answered, not a new one")
+	asserts.Empty(res.User.RefreshToken)
+
+	code, second := refresh(first.User.RefreshToken)
+	asserts.Equal(http.StatusOK, code)
+	asserts.NotEqual(first.User.Token, second.User.Token)
+	asserts.NotEqual(first.User.RefreshToken, second.User.RefreshToken, "the refresh token should rotate")
+	code, _ = send("GET", "/user/", second.User.Token, "")
+	asserts.Equal(http.StatusOK, code)
+
+	reused := testutil.ToFloat64(metrics.Refreshes.WithLabelValues("reused"))
+	code, _ = refresh(first.User.RefreshToken)
+	asserts.Equal(http.StatusUnauthorized, code, "a refresh token should work once")
+	asserts.Equal(reused+1, testutil.ToFloat64(metrics.Refreshes.WithLabelValues("reused")))
+	code, _ = refresh(second.User.RefreshToken)
+	asserts.Equal(http.StatusUnauthorized, code, "a reuse should revoke the whole session")
+	code, _ = send("GET", "/user/", second.User.Token, "")
+	asserts.Equal(http.StatusUnauthorized, code, "the last access token of the session should be revoked too")
+
+	third, other := login(), login()
+	code, _ = send("POST", "/users/logout", third.User.Token, "")
+	asserts.Equal(http.StatusNoContent, code)
+	code, _ = send("GET", "/user/", third.User.Token, "")
+	asserts.Equal(http.StatusUnauthorized, code, "a logged out token should be refused")
+	code, _ = refresh(third.User.RefreshToken)
+	asserts.Equal(http.StatusUnauthorized, code)
+	code, _ = send("GET", "/user/", other.User.Token, "")
+	asserts.Equal(http.StatusOK, code, "the other sessions should be kept")
+	code, _ = send("POST", "/users/logout", "", "")
+	asserts.Equal(http.StatusUnauthorized, code)
+
+	code, _ = refresh("not-a-refresh-token")
+	asserts.Equal(http.StatusUnauthorized, code)
+	code, _ = send("POST", "/users/refresh", "", `{}`)
+	asserts.Equal(http.StatusUnprocessableEntity, code)
+
+	now := time.Now()
+	userStore.Update(context.Background(), &jake, UserModel{DisabledAt: &now})
+	code, _ = refresh(other.User.RefreshToken)
+	asserts.Equal(http.StatusUnauthorized, code, "a disabled user should not get new tokens")
+}
+
+func TestAuthSchemes(t *testing.T) {
+	asserts := assert.New(t)
+	userStore := NewMemoryUserStore()
+	h := NewHandler(userStore, NewMemoryTokenStore(userStore))
+	r := gin.New()
+	r.Use(h.AuthMiddleware(true))
+	h.UserRegister(r.Group("/user"))
+
+	jake := UserModel{Username: "jake", Email: "jake@jake.jake"}
+	asserts.NoError(userStore.Create(context.Background(), &jake))
+	token := common.GenToken(jake.ID)
+	send := func(url, authorization string) int {
+		req, _ := http.NewRequest("GET", url, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	asserts.Equal(http.StatusOK, send("/user/", "Token "+token))
+	asserts.Equal(http.StatusOK, send("/user/", "Bearer "+token))
+	asserts.Equal(http.StatusOK, send("/user/", "bearer "+token), "the scheme should be case insensitive")
+	asserts.Equal(http.StatusUnauthorized, send("/user/", "Basic "+token))
+	asserts.Equal(http.StatusUnauthorized, send("/user/", token), "a token without scheme should be refused")
+
+	asserts.Equal(http.StatusUnauthorized, send("/user/?access_token="+token, ""), "the query token should be off by default")
+	h.AllowQueryToken = true
+	asserts.Equal(http.StatusOK, send("/user/?access_token="+token, ""))
+	asserts.Equal(http.StatusUnauthorized, send("/user/?access_token="+token, "Basic "+token), "the header should be read first")
+
+	asserts.Equal(http.StatusUnauthorized, send("/user/", "Bearer "+common.GenToken(jake.ID+1)), "the user of the token should exist")
+}
+
+func TestPasswordReset(t *testing.T) {
+	asserts := assert.New(t)
+	userStore := NewMemoryUserStore()
+	tokenStore, mailer := NewMemoryTokenStore(userStore), &mail.MemoryMailer{}
+	h := NewHandler(userStore, tokenStore)
+	h.Mailer = mailer
+	h.Background = func(fn func()) { fn() }
+	h.PasswordResetURL = "https://conduit.example/reset-password"
+	r := gin.New()
+	h.UsersRegister(r.Group("/users"))
+	r.Use(h.AuthMiddleware(true))
+	h.UserRegister(r.Group("/user"))
+
+	jake := UserModel{Username: "jake", Email: "jake@jake.jake"}
+	jake.SetPassword("jakejake")
+	asserts.NoError(userStore.Create(context.Background(), &jake))
+
+	send := func(method, url, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	login := func(password string) (int, string) {
+		w := send("POST", "/users/login", "", `{"user":{"email":"jake@jake.jake","password":"`+password+`"}}`)
+		var res struct {
+			User struct {
+				Token        string `json:"token"`
+				RefreshToken string `json:"refreshToken"`
+			} `json:"user"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res.User.Token
+	}
+	forgot := func(email string) int {
+		return send("POST", "/users/password/forgot", "", `{"user":{"email":"`+email+`"}}`).Code
+	}
+	reset := func(token, password string) *httptest.ResponseRecorder {
+		return send("POST", "/users/password/reset", "", `{"user":{"token":"`+token+`","password":"`+password+`"}}`)
+	}
+	link := regexp.MustCompile(`https://conduit\.example/reset-password\?token=([a-zA-Z0-9-_]{43})`)
+
+	asserts.Equal(http.StatusAccepted, forgot("nobody@jake.jake"))
+	asserts.Empty(mailer.Messages(), "an unknown email should get nothing, with the same answer")
+	asserts.Equal(http.StatusUnprocessableEntity, forgot("not an email"))
+
+	// The mail goes out after the answer, which takes as long for a registered email as for an unknown one
+	var pending []func()
+	h.Background = func(fn func()) { pending = append(pending, fn) }
+	h.Mailer = &mail.MemoryMailer{}
+	asserts.Equal(http.StatusAccepted, forgot("jake@jake.jake"))
+	asserts.Empty(h.Mailer.(*mail.MemoryMailer).Messages(), "the answer should not wait for the mail")
+	if asserts.Len(pending, 1) {
+		pending[0]()
+	}
+	asserts.Len(h.Mailer.(*mail.MemoryMailer).Messages(), 1)
+	h.Background, h.Mailer = func(fn func()) { fn() }, mailer
+
+	_, session := login("jakejake")
+	asserts.Equal(http.StatusAccepted, forgot("jake@jake.jake"))
+	asserts.Equal(http.StatusAccepted, forgot("jake@jake.jake"))
+	messages := mailer.Messages()
+	if !asserts.Len(messages, 2) {
+		return
+	}
+	asserts.Equal("jake@jake.jake", messages[0].To)
+	asserts.Contains(messages[0].Body, "within 60 minutes")
+	first, second := link.FindStringSubmatch(messages[0].Body), link.FindStringSubmatch(messages[1].Body)
+	if !asserts.NotNil(first, messages[0].Body) || !asserts.NotNil(second) {
+		return
+	}
+
+	w := reset("not-a-reset-token", "newpassword")
+	asserts.Equal(http.StatusUnauthorized, w.Code)
+	asserts.Contains(w.Body.String(), "The reset token is invalid or expired")
+	asserts.Equal(http.StatusUnprocessableEntity, reset(first[1], "short").Code)
+
+	asserts.Equal(http.StatusNoContent, reset(first[1], "newpassword").Code)
+	code, _ := login("jakejake")
+	asserts.Equal(http.StatusUnauthorized, code, "the old password should not work anymore")
+	code, _ = login("newpassword")
+	asserts.Equal(http.StatusOK, code)
+	asserts.Equal(http.StatusUnauthorized, send("GET", "/user/", session, "").Code, "the sessions of the old password should be revoked")
+
+	asserts.Equal(http.StatusUnauthorized, reset(first[1], "otherpassword").Code, "a reset token should work once")
+	asserts.Equal(http.StatusUnauthorized, reset(second[1], "otherpassword").Code, "the older links should be spent with it")
+
+	now := time.Now()
+	userStore.Update(context.Background(), &jake, UserModel{DisabledAt: &now})
+	asserts.Equal(http.StatusAccepted, forgot("jake@jake.jake"))
+	asserts.Len(mailer.Messages(), 2, "a disabled user should get no link")
+}
+
+func TestEmailVerification(t *testing.T) {
+	asserts := assert.New(t)
+	userStore := NewMemoryUserStore()
+	tokenStore, mailer := NewMemoryTokenStore(userStore), &mail.MemoryMailer{}
+	h := NewHandler(userStore, tokenStore)
+	h.Mailer = mailer
+	h.EmailVerifyURL = "https://conduit.example/verify-email"
+	r := gin.New()
+	h.UsersRegister(r.Group("/users"))
+	r.Use(h.AuthMiddleware(true), h.RequireVerifiedEmail("POST /articles/"))
+	h.UserRegister(r.Group("/user"))
+	r.POST("/articles/", func(c *gin.Context) { c.Status(http.StatusCreated) })
+	r.GET("/articles/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	send := func(method, url, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	type user struct {
+		User struct {
+			Email         string `json:"email"`
+			EmailVerified bool   `json:"emailVerified"`
+			Token         string `json:"token"`
+		} `json:"user"`
+	}
+	current := func(token string) user {
+		var res user
+		json.Unmarshal(send("GET", "/user/", token, "").Body.Bytes(), &res)
+		return res
+	}
+	verify := func(token string) *httptest.ResponseRecorder {
+		return send("POST", "/users/email/verify", "", `{"user":{"token":"`+token+`"}}`)
+	}
+	link := regexp.MustCompile(`https://conduit\.example/verify-email\?token=([a-zA-Z0-9-_]{43})`)
+	lastLink := func() string {
+		messages := mailer.Messages()
+		if !asserts.NotEmpty(messages) {
+			return ""
+		}
+		match := link.FindStringSubmatch(messages[len(messages)-1].Body)
+		if !asserts.NotNil(match, messages[len(messages)-1].Body) {
+			return ""
+		}
+		return match[1]
+	}
+
+	w := send("POST", "/users/", "", `{"user":{"username":"jake","email":"jake@jake.jake","password":"correcthorse"}}`)
+	asserts.Equal(http.StatusCreated, w.Code)
+	var registered user
+	json.Unmarshal(w.Body.Bytes(), &registered)
+	asserts.False(registered.User.EmailVerified)
+	token := registered.User.Token
+	if !asserts.Len(mailer.Messages(), 1, "the sign up should send a link") {
+		return
+	}
+	asserts.Equal("jake@jake.jake", mailer.Messages()[0].To)
+	asserts.Contains(mailer.Messages()[0].Body, "within 24 hours")
+	first := lastLink()
+
+	w = send("POST", "/articles/", token, "")
+	asserts.Equal(http.StatusForbidden, w.Code, "an unverified user should not publish")
+	asserts.Contains(w.Body.String(), "email_unverified")
+	asserts.Equal(http.StatusOK, send("GET", "/articles/", token, "").Code, "the other routes should stay open")
+
+	asserts.Equal(http.StatusAccepted, send("POST", "/user/email/verification", token, "").Code)
+	second := lastLink()
+	asserts.NotEqual(first, second)
+
+	w = verify("not-a-verification-token")
+	asserts.Equal(http.StatusUnauthorized, w.Code)
+	asserts.Contains(w.Body.String(), "The verification token is invalid or expired")
+	asserts.Equal(http.StatusUnprocessableEntity, send("POST", "/users/email/verify", "", `{"user":{}}`).Code)
+	asserts.Equal(http.StatusNoContent, verify(first).Code)
+	asserts.True(current(token).User.EmailVerified)
+	asserts.Equal(http.StatusUnauthorized, verify(first).Code, "a verification token should work once")
+	asserts.Equal(http.StatusCreated, send("POST", "/articles/", token, "").Code)
+	asserts.Equal(http.StatusNoContent, send("POST", "/user/email/verification", token, "").Code, "a verified email needs no link")
+
+	sent := len(mailer.Messages())
+	asserts.Equal(http.StatusOK, send("PUT", "/user/", token, `{"user":{"bio":"I work at statefarm"}}`).Code)
+	asserts.Len(mailer.Messages(), sent, "an update keeping the email should send nothing")
+	asserts.True(current(token).User.EmailVerified)
+
+	asserts.Equal(http.StatusOK, send("PUT", "/user/", token, `{"user":{"email":"jake@statefarm.example"}}`).Code)
+	asserts.False(current(token).User.EmailVerified, "a new email should be verified again")
+	asserts.Equal(http.StatusForbidden, send("POST", "/articles/", token, "").Code)
+	messages := mailer.Messages()
+	asserts.Equal("jake@statefarm.example", messages[len(messages)-1].To)
+	third := lastLink()
+
+	asserts.Equal(http.StatusUnauthorized, verify(second).Code, "a link of the old email should not verify the new one")
+	asserts.Equal(http.StatusNoContent, verify(third).Code)
+	res := current(token)
+	asserts.Equal("jake@statefarm.example", res.User.Email)
+	asserts.True(res.User.EmailVerified)
+}
+
+func TestPasswordHasher(t *testing.T) {
+	asserts := assert.New(t)
+	defer SetPasswordHasher(Passwords)
+	weak := Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1}
+	strong := Argon2idHasher{Memory: 128, Iterations: 2, Parallelism: 2}
+	bcryptHasher := BcryptHasher{Cost: bcrypt.MinCost}
+
+	for _, hasher := range []PasswordHasher{weak, bcryptHasher} {
+		hash, err := hasher.Hash("jakejake")
+		asserts.NoError(err)
+		asserts.NoError(verifyPassword(hash, "jakejake"))
+		asserts.ErrorIs(verifyPassword(hash, "jakejak"), ErrPasswordMismatch)
+		asserts.False(hasher.NeedsRehash(hash))
+		other, _ := hasher.Hash("jakejake")
+		asserts.NotEqual(hash, other, "every hash should get its own salt")
+	}
+	hash, _ := weak.Hash("jakejake")
+	asserts.True(strong.NeedsRehash(hash), "weaker parameters should be rehashed")
+	asserts.True(bcryptHasher.NeedsRehash(hash), "another algorithm should be rehashed")
+	asserts.True(BcryptHasher{Cost: bcrypt.MinCost + 1}.NeedsRehash(hash))
+	asserts.Error(verifyPassword("$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5", "jakejake"), "a broken hash should not verify")
+	asserts.Error(verifyPassword("plain", "plain"))
+
+	long := strings.Repeat("a", 73)
+	_, err := bcryptHasher.Hash(long)
+	asserts.ErrorIs(err, ErrPasswordTooLong, "bcrypt should not cut the long passwords")
+	_, err = weak.Hash(long)
+	asserts.NoError(err)
+
+	// A bcrypt hash of the old code is replaced by the next successful check
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("jakejake"), bcrypt.MinCost)
+	SetPasswordHasher(strong)
+	userModel := UserModel{PasswordHash: string(legacy)}
+	asserts.Error(userModel.checkPassword("jakejak"))
+	asserts.Equal(string(legacy), userModel.PasswordHash, "a wrong password should not rehash")
+	asserts.NoError(userModel.checkPassword("jakejake"))
+	asserts.True(strings.HasPrefix(userModel.PasswordHash, "$argon2id$v=19$m=128,t=2,p=2$"), userModel.PasswordHash)
+	rehashed := userModel.PasswordHash
+	asserts.NoError(userModel.checkPassword("jakejake"))
+	asserts.Equal(rehashed, userModel.PasswordHash, "a current hash should be kept")
+
+	// The login saves it
+	userStore := NewMemoryUserStore()
+	h := NewHandler(userStore, NewMemoryTokenStore(userStore))
+	r := gin.New()
+	h.UsersRegister(r.Group("/users"))
+	jake := UserModel{Username: "jake", Email: "jake@jake.jake", PasswordHash: string(legacy)}
+	asserts.NoError(userStore.Create(context.Background(), &jake))
+	send := func(url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	asserts.Equal(http.StatusOK, send("/users/login", `{"user":{"email":"jake@jake.jake","password":"jakejake"}}`).Code)
+	stored, _ := userStore.FindOne(context.Background(), UserModel{ID: jake.ID})
+	asserts.True(strings.HasPrefix(stored.PasswordHash, "$argon2id$"), "the login should save the new hash")
+	asserts.Equal(http.StatusOK, send("/users/login", `{"user":{"email":"jake@jake.jake","password":"jakejake"}}`).Code)
+
+	SetPasswordHasher(bcryptHasher)
+	w := send("/users/", `{"user":{"username":"anna","email":"anna@jake.jake","password":"`+long+`"}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "a password bcrypt would cut should be refused")
+	asserts.Contains(w.Body.String(), `"path":"user.password","code":"too_long","param":"72"`)
+	asserts.Equal(http.StatusCreated, send("/users/", `{"user":{"username":"anna","email":"anna@jake.jake","password":"batterystaple"}}`).Code)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	asserts := assert.New(t)
+	code := func(err error) string {
+		var policyErr *PolicyError
+		if errors.As(err, &policyErr) {
+			return policyErr.Code
+		}
+		return fmt.Sprint(err)
+	}
+	policy := PasswordPolicy{MinLength: 10, MinClasses: 3, RejectSimilar: true}
+	asserts.Equal("too_short", code(policy.Check("Tr0ub4dor", "jake", "jake@jake.jake")))
+	asserts.Equal("too_simple", code(policy.Check("troubadour", "jake", "jake@jake.jake")))
+	asserts.Equal("too_simple", code(policy.Check("troubadour12", "jake", "jake@jake.jake")))
+	asserts.Equal("similar", code(policy.Check("Jake-2024-Jake", "jake", "jake@jake.jake")))
+	asserts.Equal("similar", code(policy.Check("StateFarm-2024", "jake", "jake@statefarm.com")), "the domain of the email should count")
+	asserts.NoError(policy.Check("Compass-2024", "jake", "jake@statefarm.com"), "the top level domain should not count")
+	asserts.NoError(policy.Check("Tr0ub4dor&3", "jake", "jake@jake.jake"))
+	asserts.Equal("too_short", code(policy.Check("Tr0ub4dé!", "jake", "jake@jake.jake")), "the length should be in characters")
+	policy.RejectSimilar = false
+	asserts.NoError(policy.Check("Jake-2024-Jake", "jake", "jake@jake.jake"))
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	leaked := sha1.Sum([]byte("Tr0ub4dor&3"))
+	content := "password123\r\n\n" +
+		strings.ToUpper(hex.EncodeToString(leaked[:])) + ":3861493\n" +
+		"CorrectHorse!9\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	breached, err := LoadBreachedList(path)
+	if !asserts.NoError(err) {
+		return
+	}
+	asserts.Equal(3, breached.Count)
+	for _, password := range []string{"password123", "Tr0ub4dor&3", "CorrectHorse!9"} {
+		asserts.True(breached.Contains(password), password)
+	}
+	asserts.False(breached.Contains("CorrectHorse!8"))
+	asserts.False(breached.Contains("Password123"))
+	policy.Breached = breached
+	asserts.Equal("breached", code(policy.Check("Tr0ub4dor&3", "jake", "jake@jake.jake")))
+	_, err = LoadBreachedList(filepath.Join(t.TempDir(), "missing.txt"))
+	asserts.ErrorContains(err, "password.breached_list")
+
+	// The field errors of the routes
+	defer func(policy PasswordPolicy) { Policy = policy }(Policy)
+	Policy = policy
+	userStore := NewMemoryUserStore()
+	tokenStore, mailer := NewMemoryTokenStore(userStore), &mail.MemoryMailer{}
+	h := NewHandler(userStore, tokenStore)
+	h.Mailer = mailer
+	h.Background = func(fn func()) { fn() }
+	h.PasswordResetURL = "https://conduit.example/reset-password"
+	r := gin.New()
+	h.UsersRegister(r.Group("/users"))
+	r.Use(h.AuthMiddleware(true))
+	h.UserRegister(r.Group("/user"))
+	send := func(method, url, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	register := func(password string) *httptest.ResponseRecorder {
+		return send("POST", "/users/", "", `{"user":{"username":"jake","email":"jake@jake.jake","password":"`+password+`"}}`)
+	}
+	w := register("Tr0ub4dor&3")
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+	asserts.Contains(w.Body.String(), `"path":"user.password","code":"breached"`)
+	asserts.Contains(w.Body.String(), "appeared in a data breach")
+	w = register("troubadour12")
+	asserts.Contains(w.Body.String(), `"code":"too_simple","param":"3"`)
+	w = register("Correct-Horse-7")
+	if !asserts.Equal(http.StatusCreated, w.Code, w.Body.String()) {
+		return
+	}
+	var res struct {
+		User struct {
+			Token string `json:"token"`
+		} `json:"user"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	w = send("PUT", "/user/", res.User.Token, `{"user":{"password":"troubadour"}}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "a password change should follow the policy")
+	asserts.Contains(w.Body.String(), `"code":"too_simple"`)
+	Policy.RejectSimilar = true
+	w = send("PUT", "/user/", res.User.Token, `{"user":{"username":"horse","password":"Correct-Horse-7"}}`)
+	asserts.Contains(w.Body.String(), `"code":"similar"`, "the new username should count")
+	asserts.Equal(http.StatusOK, send("PUT", "/user/", res.User.Token, `{"user":{"bio":"I like to skateboard"}}`).Code,
+		"an update without password should not check it")
+
+	asserts.Equal(http.StatusAccepted, send("POST", "/users/password/forgot", "", `{"user":{"email":"jake@jake.jake"}}`).Code)
+	messages := mailer.Messages()
+	token := regexp.MustCompile(`reset-password\?token=([a-zA-Z0-9-_]+)`).FindStringSubmatch(messages[len(messages)-1].Body)
+	if !asserts.NotNil(token) {
+		return
+	}
+	reset := func(password string) *httptest.ResponseRecorder {
+		return send("POST", "/users/password/reset", "", `{"user":{"token":"`+token[1]+`","password":"`+password+`"}}`)
+	}
+	w = reset("Jake-Jake-2024")
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+	asserts.Contains(w.Body.String(), `"code":"similar"`)
+	asserts.Equal(http.StatusNoContent, reset("Battery-Staple-9").Code, "a refused password should not spend the token")
+}
+
+func TestLoginThrottle(t *testing.T) {
+	asserts := assert.New(t)
+	throttle := LoginThrottle{Window: 15 * time.Minute, FreeFailures: 2, Delay: time.Second, MaxDelay: 8 * time.Second,
+		AccountLockout: 6, IPLockout: 10, LockoutDuration: 15 * time.Minute}
+	now := time.Now()
+	failures := func(n int) []LoginAttemptModel {
+		attempts := make([]LoginAttemptModel, n)
+		for i := range attempts {
+			attempts[i] = LoginAttemptModel{Outcome: LoginFailure, CreatedAt: now.Add(-time.Duration(i) * time.Minute)}
+		}
+		return attempts
+	}
+	asserts.Zero(throttle.wait(failures(2), throttle.AccountLockout, now), "the free failures should not wait")
+	asserts.Equal(time.Second, throttle.wait(failures(3), throttle.AccountLockout, now))
+	asserts.Equal(4*time.Second, throttle.wait(failures(5), throttle.AccountLockout, now), "the delay should double")
+	asserts.Equal(15*time.Minute, throttle.wait(failures(6), throttle.AccountLockout, now), "the lockout should start")
+	asserts.Equal(8*time.Second, throttle.wait(failures(9), 0, now), "no lockout should cap the delay")
+	asserts.Zero(throttle.wait(failures(3), throttle.AccountLockout, now.Add(time.Second)), "the delay should pass")
+
+	ctx := context.Background()
+	tokenStore := NewMemoryTokenStore(NewMemoryUserStore())
+	for i := 0; i < 3; i++ {
+		tokenStore.CreateLoginAttempt(ctx, &LoginAttemptModel{Email: "jake@jake.jake", IP: "10.0.0.1", Outcome: LoginFailure, CreatedAt: now})
+	}
+	wait, err := throttle.Wait(ctx, tokenStore, "jake@jake.jake", "10.0.0.2", now)
+	asserts.NoError(err)
+	asserts.Equal(time.Second, wait, "the failures of the account should count from any IP")
+	wait, err = throttle.Wait(ctx, tokenStore, "anna@jake.jake", "10.0.0.1", now)
+	asserts.NoError(err)
+	asserts.Equal(time.Second, wait, "the failures of the IP should count for any account")
+	wait, err = throttle.Wait(ctx, tokenStore, "jake@jake.jake", "10.0.0.2", now.Add(throttle.Window))
+	asserts.NoError(err)
+	asserts.Zero(wait, "the failures should leave the window")
+
+	tokenStore.CreateLoginAttempt(ctx, &LoginAttemptModel{Email: "jake@jake.jake", IP: "10.0.0.1", Outcome: LoginSuccess, CreatedAt: now.Add(time.Millisecond)})
+	wait, err = throttle.Wait(ctx, tokenStore, "jake@jake.jake", "10.0.0.2", now)
+	asserts.NoError(err)
+	asserts.Zero(wait, "a success should forget the failures of the account")
+	wait, err = throttle.Wait(ctx, tokenStore, "anna@jake.jake", "10.0.0.1", now)
+	asserts.NoError(err)
+	asserts.Equal(time.Second, wait, "a success should not forget the failures of the IP")
+}
+
+func TestLoginLockout(t *testing.T) {
+	asserts := assert.New(t)
+	userStore := NewMemoryUserStore()
+	tokenStore := NewMemoryTokenStore(userStore)
+	h := NewHandler(userStore, tokenStore)
+	h.Throttle = LoginThrottle{Window: time.Hour, FreeFailures: 2, Delay: time.Minute, MaxDelay: 2 * time.Minute,
+		AccountLockout: 4, LockoutDuration: time.Hour}
+	r := gin.New()
+	h.UsersRegister(r.Group("/users"))
+	r.Use(h.AuthMiddleware(true))
+	h.UserRegister(r.Group("/user"))
+
+	jake := UserModel{Username: "jake", Email: "jake@jake.jake"}
+	jake.SetPassword("correcthorse")
+	asserts.NoError(userStore.Create(context.Background(), &jake))
+	login := func(email, password string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/users/login", bytes.NewBufferString(`{"user":{"email":"`+email+`","password":"`+password+`"}}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "k6/0.50")
+		// The IP of each email, not to add up their failures
+		req.RemoteAddr = "10.0.0.1:4242"
+		if email == "nobody@jake.jake" {
+			req.RemoteAddr = "10.0.0.2:4242"
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	asserts.Equal(http.StatusOK, login("jake@jake.jake", "correcthorse").Code)
+	asserts.Equal(http.StatusUnauthorized, login("jake@jake.jake", "wrongpassword").Code)
+	asserts.Equal(http.StatusUnauthorized, login("JAKE@jake.jake", "wrongpassword").Code)
+	w := login("jake@jake.jake", "wrongpassword")
+	asserts.Equal(http.StatusUnauthorized, w.Code, "the free failures should be answered")
+	w = login("jake@jake.jake", "correcthorse")
+	asserts.Equal(http.StatusTooManyRequests, w.Code, "the right password should wait too")
+	asserts.Contains(w.Body.String(), `"code":"rate_limited"`)
+	asserts.Equal("60", w.Header().Get("Retry-After"))
+
+	w = login("nobody@jake.jake", "wrongpassword")
+	asserts.Equal(http.StatusUnauthorized, w.Code)
+	for i := 0; i < 3; i++ {
+		login("nobody@jake.jake", "wrongpassword")
+	}
+	asserts.Equal(http.StatusTooManyRequests, login("nobody@jake.jake", "wrongpassword").Code, "an unknown email should be throttled alike")
+
+	// A fourth failure, the throttled logins did not check the password
+	asserts.NoError(tokenStore.CreateLoginAttempt(context.Background(), &LoginAttemptModel{UserID: jake.ID, Email: "jake@jake.jake",
+		Outcome: LoginFailure}))
+	w = login("jake@jake.jake", "correcthorse")
+	asserts.Equal(http.StatusTooManyRequests, w.Code)
+	asserts.Equal("3600", w.Header().Get("Retry-After"), "the fourth failure should lock the account")
+
+	req, _ := http.NewRequest("GET", "/user/logins?limit=3", nil)
+	req.Header.Set("Authorization", "Token "+common.GenToken(jake.ID))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	var history struct {
+		Logins []LoginAttemptResponse `json:"logins"`
+	}
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &history))
+	if asserts.Len(history.Logins, 3) {
+		asserts.Equal(LoginThrottled, history.Logins[0].Outcome, "the newest should come first")
+		asserts.Equal("10.0.0.1", history.Logins[0].IP)
+		asserts.Equal("k6/0.50", history.Logins[0].UserAgent)
+		asserts.Equal(LoginFailure, history.Logins[1].Outcome)
+		asserts.Equal(LoginThrottled, history.Logins[2].Outcome)
+	}
+	attempts, err := tokenStore.LoginAttempts(context.Background(), LoginAttemptModel{UserID: jake.ID}, time.Time{}, 100)
+	asserts.NoError(err)
+	asserts.Len(attempts, 6, "every login of jake should be kept, not the ones of nobody nor of JAKE")
+
+	asserts.Equal("k6", truncate("k6", 255))
+	asserts.Equal("é", truncate("éé", 3), "a character should not be cut")
+}
+
+// A hasher counting its hashes, failing them with err when set.
+type countingHasher struct {
+	BcryptHasher
+	hashes *int
+	err    error
+}
+
+func (h countingHasher) Hash(password string) (string, error) {
+	*h.hashes++
+	if h.err != nil {
+		return "", h.err
+	}
+	return h.BcryptHasher.Hash(password)
+}
+
+func TestLoginUnknownEmailChecksHash(t *testing.T) {
+	asserts := assert.New(t)
+	hashes := 0
+	defer SetPasswordHasher(Passwords)
+	SetPasswordHasher(countingHasher{BcryptHasher{Cost: bcrypt.MinCost}, &hashes, nil})
+	userStore := NewMemoryUserStore()
+	h := NewHandler(userStore, NewMemoryTokenStore(userStore))
+	r := gin.New()
+	h.UsersRegister(r.Group("/users"))
+	login := func() int {
+		req, _ := http.NewRequest("POST", "/users/login", bytes.NewBufferString(`{"user":{"email":"nobody@jake.jake","password":"wrongpassword"}}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	asserts.Equal(http.StatusUnauthorized, login())
+	asserts.Equal(1, hashes, "an unknown email should be checked against a hash of the current hasher")
+	hash, err := dummyPasswordHash()
+	asserts.NoError(err)
+	asserts.True(strings.HasPrefix(hash, "$2a$04$"))
+	asserts.Equal(http.StatusUnauthorized, login())
+	asserts.Equal(1, hashes, "the dummy hash should be made once")
+
+	SetPasswordHasher(countingHasher{BcryptHasher{Cost: bcrypt.MinCost + 1}, &hashes, nil})
+	asserts.Equal(http.StatusUnauthorized, login())
+	asserts.Equal(2, hashes, "a new hasher should make a new dummy hash")
+	hash, err = dummyPasswordHash()
+	asserts.NoError(err)
+	asserts.True(strings.HasPrefix(hash, "$2a$05$"))
+
+	SetPasswordHasher(countingHasher{BcryptHasher{Cost: bcrypt.MinCost}, &hashes, errors.New("hasher down")})
+	asserts.Equal(http.StatusUnauthorized, login(), "a failing hasher should not let the unknown email in")
+	asserts.Equal(3, hashes)
+	_, err = dummyPasswordHash()
+	asserts.EqualError(err, "hasher down")
+	asserts.Equal(4, hashes, "a failed dummy hash should not be kept")
+}
@@ -1,8 +1,11 @@
 package users
 
 import (
+	"errors"
 	"realworld-backend/common"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"strconv"
 )
 
 // *ModelValidator containing two parts:
@@ -28,12 +31,36 @@ func (self *UserModelValidator) Bind(c *gin.Context) error {
 	if err != nil {
 		return err
 	}
+	return self.fill()
+}
+
+// Check the User fields filled by hand and return the model they describe, like Bind does for a request.
+// It serves the callers outside of gin such as the CLI.
+// 	validator := users.NewUserModelValidator()
+// 	validator.User.Username, validator.User.Email, validator.User.Password = "jake", "jake@jake.jake", "jakejake"
+// 	userModel, err := validator.Validate()
+func (self *UserModelValidator) Validate() (UserModel, error) {
+	if err := binding.Validator.ValidateStruct(self); err != nil {
+		return UserModel{}, err
+	}
+	if err := self.fill(); err != nil {
+		return UserModel{}, err
+	}
+	return self.userModel, nil
+}
+
+func (self *UserModelValidator) fill() error {
 	self.userModel.Username = self.User.Username
 	self.userModel.Email = self.User.Email
 	self.userModel.Bio = self.User.Bio
 
 	if self.User.Password != common.NBRandomPassword {
-		self.userModel.setPassword(self.User.Password)
+		if err := Policy.Check(self.User.Password, self.User.Username, self.User.Email); err != nil {
+			return passwordError("user.password", err)
+		}
+		if err := self.userModel.SetPassword(self.User.Password); err != nil {
+			return passwordError("user.password", err)
+		}
 	}
 	if self.User.Image != "" {
 		self.userModel.Image = &self.User.Image
@@ -41,6 +68,18 @@ func (self *UserModelValidator) Bind(c *gin.Context) error {
 	return nil
 }
 
+// The field error of a password the policy or the hasher refuses, the other hashing errors are kept as they are.
+func passwordError(path string, err error) error {
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) {
+		return common.FieldsError(common.FieldError{Path: path, Code: policyErr.Code, Param: policyErr.Param})
+	}
+	if errors.Is(err, ErrPasswordTooLong) {
+		return common.FieldsError(common.FieldError{Path: path, Code: "too_long", Param: strconv.Itoa(bcryptMaxBytes)})
+	}
+	return err
+}
+
 // You can put the default value of a Validator here
 func NewUserModelValidator() UserModelValidator {
 	userModelValidator := UserModelValidator{}
@@ -84,3 +123,58 @@ func NewLoginValidator() LoginValidator {
 	loginValidator := LoginValidator{}
 	return loginValidator
 }
+
+type RefreshValidator struct {
+	RefreshToken string `form:"refreshToken" json:"refreshToken" binding:"required,max=255"`
+}
+
+func (self *RefreshValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewRefreshValidator() RefreshValidator {
+	return RefreshValidator{}
+}
+
+type PasswordForgotValidator struct {
+	User struct {
+		Email string `form:"email" json:"email" binding:"required,email"`
+	} `json:"user"`
+}
+
+func (self *PasswordForgotValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewPasswordForgotValidator() PasswordForgotValidator {
+	return PasswordForgotValidator{}
+}
+
+type PasswordResetValidator struct {
+	User struct {
+		Token    string `form:"token" json:"token" binding:"required,max=255"`
+		Password string `form:"password" json:"password" binding:"required,min=8,max=255"`
+	} `json:"user"`
+}
+
+func (self *PasswordResetValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewPasswordResetValidator() PasswordResetValidator {
+	return PasswordResetValidator{}
+}
+
+type EmailVerifyValidator struct {
+	User struct {
+		Token string `form:"token" json:"token" binding:"required,max=255"`
+	} `json:"user"`
+}
+
+func (self *EmailVerifyValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewEmailVerifyValidator() EmailVerifyValidator {
+	return EmailVerifyValidator{}
+}